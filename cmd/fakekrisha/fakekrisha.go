@@ -0,0 +1,45 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/fakekrisha"
+	"krisha_kz_bot/pkg/logger"
+)
+
+const (
+	DefaultReadTimeout = 5 * time.Second
+)
+
+// Serves fake krisha.kz with generated ads for offline runs of the crawling pipeline.
+// Search pages are served on any path, ads are controlled by /_fake/ endpoints.
+func main() {
+	addr := flag.String("addr", ":8081", "listen address")
+	ads := flag.Int("ads", 30, "number of generated ads published today")
+	oldAds := flag.Int("old-ads", 10, "number of generated ads published yesterday")
+	pageSize := flag.Int("page-size", fakekrisha.DefaultPageSize, "ads per search page")
+	seed := flag.Int64("seed", 1, "seed of generated ads")
+	flag.Parse()
+
+	clk := clock.New()
+	now := clk.Now()
+
+	srv := fakekrisha.New(clk, *pageSize)
+	srv.Add(fakekrisha.Generate(*oldAds, now.AddDate(0, 0, -1), *seed+1)...)
+	srv.Add(fakekrisha.Generate(*ads, now, *seed)...)
+
+	server := &http.Server{
+		Addr:              *addr,
+		Handler:           srv,
+		ReadHeaderTimeout: DefaultReadTimeout,
+	}
+
+	logger.Default().Info("fake krisha.kz listening", "addr", *addr)
+	if err := server.ListenAndServe(); err != nil {
+		log.Panicf("failed to start fake krisha.kz, error %v", err)
+	}
+}
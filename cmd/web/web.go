@@ -1,11 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"krisha_kz_bot/pkg/dashboard"
+	"krisha_kz_bot/pkg/health"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/metrics"
+	"krisha_kz_bot/pkg/ops"
 	"krisha_kz_bot/pkg/utils"
+	"krisha_kz_bot/pkg/webapi"
 	"log"
 	"net/http"
+	"os"
 	"time"
+
+	"github.com/go-redis/redis/v9"
 )
 
 const (
@@ -13,6 +23,16 @@ const (
 )
 
 func main() {
+	level, err := logger.ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		log.Panic(err)
+	}
+	lg := logger.New(os.Stderr, level, nil)
+	lg.Redact(os.Getenv("BOT_API_TOKEN"), os.Getenv("WEB_API_TOKEN"))
+	logger.SetDefault(lg)
+	log.SetFlags(0)
+	log.SetOutput(lg.Printer(logger.Info))
+
 	port := utils.ParseEnvOrPanic[string]("PORT")
 	addr := fmt.Sprintf(":%s", port)
 
@@ -20,12 +40,50 @@ func main() {
 		fmt.Fprintf(w, "ok")
 	})
 
+	http.Handle(metrics.Path, metrics.Handler())
+
+	// admin api is served when redis is configured
+	if redisURL := os.Getenv("REDIS_URL"); redisURL != "" {
+		opt, errURL := redis.ParseURL(redisURL)
+		if errURL != nil {
+			log.Panicf("failed to parse redis url %s, error %v", redisURL, errURL)
+		}
+		rdb := redis.NewClient(opt)
+		rdb.AddHook(metrics.RedisHook{})
+		defer rdb.Close()
+
+		// health of worker by its heartbeats
+		healthHandler := health.NewHandler(
+			health.Config{
+				MaxCrawlAge: utils.ParseEnvOrPanic[time.Duration]("HEALTH_MAX_CRAWL_AGE"),
+			},
+			func(ctx context.Context) error {
+				return rdb.Ping(ctx).Err()
+			},
+			ops.NewStore(rdb).GetHeartbeat,
+		)
+		http.Handle(health.LivenessPath, healthHandler)
+		http.Handle(health.ReadinessPath, healthHandler)
+
+		http.Handle(webapi.Prefix, webapi.New(rdb, webapi.Config{
+			Token: os.Getenv("WEB_API_TOKEN"),
+		}))
+
+		// dashboard requires the bot to verify telegram logins
+		if botToken, botUserName := os.Getenv("BOT_API_TOKEN"), os.Getenv("BOT_USER_NAME"); botToken != "" && botUserName != "" {
+			http.Handle(dashboard.Prefix, dashboard.New(rdb, dashboard.Config{
+				BotToken:    botToken,
+				BotUserName: botUserName,
+			}))
+		}
+	}
+
 	server := &http.Server{
 		Addr:              addr,
 		ReadHeaderTimeout: DefaultReadTimout,
 	}
 
-	if err := server.ListenAndServe(); err != nil {
+	if err = server.ListenAndServe(); err != nil {
 		log.Panicf("failed to start web, error %v", err)
 	}
 }
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/pacer"
+	"krisha_kz_bot/pkg/scanner"
+	"krisha_kz_bot/pkg/utils"
+)
+
+// Adapts crawl intervals of subscriptions to arrivals of their ads by interval until context is done.
+func adaptIntervals(ctx context.Context, app *Application, interval time.Duration) {
+	p := pacer.New(pacer.Config{
+		// subscriptions are not scanned more often than quota allows
+		MinInterval: utils.GraterOrEqDefOr(app.config.Scanner.AdaptiveMin, app.config.Bot.MinScanInterval),
+		MaxInterval: app.config.Scanner.AdaptiveMax,
+		Target:      app.config.Scanner.AdaptiveTarget,
+	})
+
+	timer := app.clock.NewTimer(interval)
+	defer timer.Stop()
+
+	adaptAll(ctx, app, p)
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.log.Info("stopping adapting scan intervals")
+			return
+
+		case <-timer.C():
+			adaptAll(ctx, app, p)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// Adapts crawl intervals of all registered subscriptions.
+func adaptAll(ctx context.Context, app *Application, p *pacer.Pacer) {
+	for key := range app.scanServ.GetStatuses() {
+		if ctx.Err() != nil {
+			return
+		}
+		adapt(ctx, app, p, key)
+	}
+}
+
+// Adapts crawl interval of the subscription to arrivals of its ads within retention policy.
+// The service interval is kept until there are enough notifications to learn from.
+func adapt(ctx context.Context, app *Application, p *pacer.Pacer, key id.Key) {
+	ctx, stop := context.WithTimeout(ctx, scanner.DefaultRedisTimeout)
+	defer stop()
+
+	notifications, err := app.ops.Notifications(ctx, key, ops.DefaultHistoryCap)
+	if err != nil {
+		app.log.WithKey(key).Error("failed to load notifications", logger.Err, err)
+		return
+	}
+
+	arrivals := make([]time.Time, len(notifications))
+	for i, n := range notifications {
+		arrivals[i] = n.At
+	}
+
+	interval, _ := p.Interval(arrivals, app.scanServ.RetainedSince(), app.clock.Now().In(app.location))
+	if err = app.scanServ.Adapt(key, interval); err != nil {
+		app.log.WithKey(key).Debug("subscription stopped before adapting", logger.Err, err)
+	}
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"krisha_kz_bot/pkg/bot"
+	"krisha_kz_bot/pkg/health"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/listing"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/metrics"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/scanner"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Returns callback recording delivery of the notification to the subscriber.
+func recordNotification(app *Application, key id.Key, href, origin string) bot.SentFunc {
+	return func(msg tgbotapi.Message, err error) {
+		notification := ops.Notification{
+			AdID:      path.Base(href),
+			Href:      href,
+			Origin:    origin,
+			At:        app.clock.Now(),
+			Status:    ops.Delivered,
+			MessageID: msg.MessageID,
+		}
+		if err != nil {
+			notification.Status = ops.Failed
+			notification.Error = err.Error()
+		}
+
+		// sender is not blocked by scanner lock and redis
+		go func() {
+			if err != nil {
+				releaseNotification(app, key, href)
+			}
+			addNotification(app, key, notification)
+		}()
+	}
+}
+
+// Adds the notification to the log with the listing of the crawl cycle.
+func addNotification(app *Application, key id.Key, notification ops.Notification) {
+	href := notification.Href
+	if res, found := app.scanServ.Lookup(key, href); found {
+		if l, ok := res.(*listing.Listing); ok {
+			if l.ID != "" {
+				notification.AdID = l.ID
+			}
+			notification.Title = l.Title
+			notification.Price = l.Price
+			notification.Photo = l.Photo
+		}
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	if err := app.ops.AddNotification(ctx, key, notification); err != nil {
+		app.log.WithKey(key).Error("failed to record notification", "href", href, logger.Err, err)
+	}
+}
+
+// Returns text of the recent notifications, the newest first.
+func history(app *Application, key id.Key, notifications []ops.Notification) string {
+	if len(notifications) == 0 {
+		return fmt.Sprintf("@%s no notifications yet", key.UserName)
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "@%s recent notifications\n", key.UserName)
+	for _, n := range notifications {
+		status := string(n.Status)
+		if status == "" {
+			status = "sent"
+		}
+
+		fmt.Fprintf(&b, "%s %s https://krisha.kz%s", n.At.In(app.location).Format("02.01 15:04"), status, n.Href)
+		if n.Price > 0 {
+			fmt.Fprintf(&b, " %d ₸", n.Price)
+		}
+		b.WriteByte('\n')
+	}
+
+	return b.String()
+}
+
+// Cleaner of the notification log, notifications are kept as long as visited ads.
+type notificationsCleaner struct {
+	app *Application
+}
+
+// Implements cleaner.Cleaner.
+func (c notificationsCleaner) Clean() {
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	before := c.app.scanServ.RetainedSince()
+	removed, err := c.app.ops.TrimNotifications(ctx, before)
+	if err != nil {
+		c.app.log.Error("failed to cleanse notifications", logger.Err, err)
+		return
+	}
+
+	c.app.log.Info("cleansing notifications", "retained_since", before, "removed", removed)
+}
+
+// Publishes crawling statuses of subscriptions by interval until context is done.
+func publishStatuses(ctx context.Context, app *Application, interval time.Duration) {
+	timer := app.clock.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.log.Info("stopping publishing statuses")
+			return
+
+		case <-timer.C():
+			for key, status := range app.scanServ.GetStatuses() {
+				stats, _ := app.scanServ.GetStats(key)
+
+				published := ops.Status{
+					At:        status.At,
+					URL:       status.URL,
+					Results:   status.Results,
+					Blocked:   status.IsBlocked(),
+					Crawls:    stats.Crawls,
+					Requests:  stats.Requests,
+					Published: app.clock.Now(),
+				}
+				if status.Err != nil {
+					published.Error = status.Err.Error()
+				}
+
+				// status of removed subscription expires
+				if err := app.ops.PutStatus(ctx, key, published, 3*interval); err != nil {
+					app.log.WithKey(key).Error("failed to publish status", logger.Err, err)
+				}
+			}
+
+			timer.Reset(interval)
+		}
+	}
+}
+
+// Returns current state of worker, pings Telegram Bot API.
+func heartbeat(app *Application) health.Heartbeat {
+	hb := health.Heartbeat{
+		At:         app.clock.Now(),
+		BotAliveAt: app.botServ.AliveAt(),
+	}
+
+	if err := app.botServ.Ping(); err != nil {
+		hb.TelegramErr = err.Error()
+	}
+
+	statuses := app.scanServ.GetStatuses()
+	hb.Subscriptions = len(statuses)
+	for _, status := range statuses {
+		if status.Err == nil && status.At.After(hb.LastCrawlAt) {
+			hb.LastCrawlAt = status.At
+		}
+	}
+
+	return hb
+}
+
+// Publishes heartbeats of worker by interval until context is done.
+func publishHeartbeats(ctx context.Context, app *Application, interval time.Duration) {
+	timer := app.clock.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.log.Info("stopping publishing heartbeats")
+			return
+
+		case <-timer.C():
+			// stale heartbeat expires, so web reports worker is down
+			if err := app.ops.PutHeartbeat(ctx, heartbeat(app), health.DefaultMaxHeartbeatAge); err != nil {
+				app.log.Error("failed to publish heartbeat", logger.Err, err)
+			}
+
+			timer.Reset(interval)
+		}
+	}
+}
+
+// Serves health and metrics endpoints of worker itself on the given address.
+func serveHealth(app *Application, addr string) {
+	handler := health.NewHandler(
+		health.Config{Clock: app.clock},
+		func(ctx context.Context) error {
+			return app.rdb.Ping(ctx).Err()
+		},
+		func(ctx context.Context) (health.Heartbeat, bool, error) {
+			return heartbeat(app), true, nil
+		},
+	)
+
+	mux := http.NewServeMux()
+	mux.Handle(health.LivenessPath, handler)
+	mux.Handle(health.ReadinessPath, handler)
+	mux.Handle(metrics.Path, metrics.Handler())
+
+	server := &http.Server{
+		Addr:              addr,
+		Handler:           mux,
+		ReadHeaderTimeout: health.DefaultCheckTimeout,
+	}
+
+	if err := server.ListenAndServe(); err != nil {
+		app.log.Error("failed to serve health and metrics endpoints", logger.Err, err)
+	}
+}
+
+// Serves commands queued by web api or forwarded by worker instances until the channel is closed.
+func serveCommands(ctx context.Context, app *Application, cmds <-chan ops.Command) {
+	for cmd := range cmds {
+		key := cmd.Key()
+		log := app.log.WithKey(key).With("op", cmd.Op)
+		log.Info("serving command", "requested_at", cmd.At)
+
+		var err error
+		switch cmd.Op {
+		case ops.OpSubscribe:
+			var filter *url.URL
+			if filter, err = url.Parse(cmd.URL); err == nil {
+				err = app.botServ.Subscribe(key, *filter)
+			}
+
+		case ops.OpUnsubscribe:
+			err = app.botServ.Kick(key)
+
+		case ops.OpCrawlNow:
+			err = crawlNow(app, key)
+
+		case ops.OpFavorite, ops.OpUnfavorite:
+			err = favorite(ctx, app, key, cmd.Href, cmd.Op == ops.OpFavorite)
+
+		default:
+			err = ops.ErrUnknownOp
+		}
+
+		if err != nil {
+			log.Error("failed to serve command", logger.Err, err)
+		}
+	}
+
+	app.log.Info("stopping serving commands")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"krisha_kz_bot/pkg/config"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/utils"
+)
+
+// Reloads config on SIGHUP and on changes of config file checked by interval until context is done.
+func watchConfig(ctx context.Context, app *Application, interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	modTime := configModTime(app)
+
+	timer := app.clock.NewTimer(interval)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.log.Info("stopping watching config")
+			return
+
+		case <-hup:
+			app.log.Info("reloading config on SIGHUP")
+			modTime = configModTime(app)
+			reloadConfig(app)
+
+		case <-timer.C():
+			if t := configModTime(app); !t.Equal(modTime) {
+				app.log.Info("reloading changed config", "path", app.configPath)
+				modTime = t
+				reloadConfig(app)
+			}
+
+			timer.Reset(interval)
+		}
+	}
+}
+
+// Returns modification time of config file, zero if there is no file.
+func configModTime(app *Application) time.Time {
+	if app.configPath == "" {
+		return time.Time{}
+	}
+
+	info, err := os.Stat(app.configPath)
+	if err != nil {
+		app.log.Warn("failed to stat config", "path", app.configPath, logger.Err, err)
+		return time.Time{}
+	}
+
+	return info.ModTime()
+}
+
+// Loads config and applies reloadable changes, changes requiring restart are rejected.
+// Invalid config is ignored.
+func reloadConfig(app *Application) {
+	next, err := config.Load(app.configPath, os.LookupEnv)
+	if err != nil {
+		app.log.Error("failed to reload config, keeping current one", logger.Err, err)
+		return
+	}
+
+	cfg, applied, rejected := app.config.Reload(next)
+	for _, path := range rejected {
+		app.log.Warn("rejected config change, restart is required", "key", path)
+	}
+	if len(applied) == 0 {
+		app.log.Info("no config changes to apply")
+		return
+	}
+
+	if cfg.Log.Level != app.config.Log.Level {
+		// validated by config
+		level, _ := logger.ParseLevel(cfg.Log.Level)
+		app.log.SetLevel(level)
+	}
+	if cfg.Bot.SendMsgDelay != app.config.Bot.SendMsgDelay {
+		app.botServ.SetSendDelay(cfg.Bot.SendMsgDelay)
+	}
+	if cfg.Scanner.Interval != app.config.Scanner.Interval {
+		// subscriptions are not scanned more often than quota allows
+		app.scanServ.SetInterval(utils.GraterOrEqDefOr(cfg.Scanner.Interval, cfg.Bot.MinScanInterval))
+	}
+	if cfg.Scanner.RetentionPolicy != app.config.Scanner.RetentionPolicy {
+		app.scanServ.SetRetentionPolicy(cfg.Scanner.RetentionPolicy)
+	}
+
+	app.config = cfg
+	app.log.Info("applied config changes", "keys", applied)
+}
@@ -0,0 +1,486 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"krisha_kz_bot/pkg/bot"
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/lease"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/scanner"
+)
+
+const (
+	leaderLease = "leader"
+
+	claimTimeout = 5 * time.Second // notification is sent unclaimed rather than delayed by redis
+)
+
+func instanceLease(instance string) string {
+	return "instance:" + instance
+}
+
+func subscriptionLease(key id.Key) string {
+	return "sub:" + key.String()
+}
+
+// Setups leases of the worker instance if subscriptions are shared by instances.
+func setupLeases(app *Application) {
+	if !app.config.Worker.Sharding {
+		return
+	}
+
+	instance := app.config.Worker.InstanceID
+	if instance == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			hostname = "worker"
+		}
+		instance = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+
+	app.leases = lease.New(app.rdb, instance, app.config.Worker.LeaseTTL, app.clock)
+	app.log = app.log.With("instance", instance)
+}
+
+// Starts sharing subscriptions with other worker instances until context is done.
+// Campaigns for leadership, the leader polls Telegram updates, serves commands of web and cleanses shared state.
+// Invokes exit if the leadership is lost, polling can not be resumed in the same process.
+func startSharding(ctx context.Context, app *Application, wg *sync.WaitGroup, exit func()) {
+	cleansingInterval := app.config.Worker.CleansingInterval
+
+	// outbound messages are sent by every instance
+	app.botServ.Serve(context.Background())
+
+	// cleansing of own scanners
+	go app.cleansingServ.Start(ctx, cleansingInterval, func() {
+		clean(app.cleaners)
+	})
+
+	// commands forwarded by other instances
+	go serveCommands(ctx, app, app.ops.InstanceCommands(ctx, app.leases.Owner()))
+
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		runShard(ctx, app)
+	}()
+	go func() {
+		defer wg.Done()
+		app.leases.Campaign(ctx, leaderLease, func(leaderCtx context.Context) {
+			lead(leaderCtx, app, cleansingInterval)
+
+			if ctx.Err() == nil {
+				app.log.Error("lost leadership, shutting down")
+				exit()
+			}
+		})
+	}()
+}
+
+// Leads worker instances until context is done.
+func lead(ctx context.Context, app *Application, cleansingInterval time.Duration) {
+	app.log.Info("elected leader")
+
+	// bot cache is loaded, subscriptions are scanned by their lease holders
+	app.botServ.LoadFromRedis(ctx)
+
+	go publishHeartbeats(ctx, app, ops.DefaultHeartbeatInterval)
+	go serveCommands(ctx, app, app.ops.Commands(ctx))
+	go app.cleansingServ.Start(ctx, cleansingInterval, func() {
+		clean(app.leaderCleaners)
+	})
+
+	if err := app.botServ.Poll(ctx); err != nil {
+		app.log.Error("failed to poll updates", logger.Err, err)
+	}
+}
+
+// Subscription scanned by the instance.
+type owned struct {
+	url      string
+	settings bot.Settings
+}
+
+// Subscriptions shared with other worker instances by leases.
+// Each instance scans up to its fair share of subscriptions, leases of crashed instances expire and are taken over.
+type shard struct {
+	app    *Application
+	leases *lease.Leases
+	owned  map[id.Key]owned
+
+	pages int
+	quota bot.Quota
+}
+
+func newShard(app *Application) *shard {
+	return &shard{
+		app:    app,
+		leases: app.leases,
+		owned:  make(map[id.Key]owned),
+		pages:  app.config.Scanner.Pages,
+		quota:  quota(app),
+	}
+}
+
+// Balances subscriptions of the instance every third of lease ttl until context is done,
+// leases are released on return.
+func runShard(ctx context.Context, app *Application) {
+	sh := newShard(app)
+
+	interval := sh.leases.TTL() / 3
+	timer := app.clock.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			app.log.Info("stopping sharding subscriptions")
+			sh.releaseAll()
+			return
+
+		case <-timer.C():
+			sh.balance(ctx, interval)
+			timer.Reset(interval)
+		}
+	}
+}
+
+// Renews leases of owned subscriptions and applies their changes,
+// acquires free subscriptions up to the fair share and hands over the excess one.
+func (sh *shard) balance(ctx context.Context, timeout time.Duration) {
+	ctx, stop := context.WithTimeout(ctx, timeout)
+	defer stop()
+
+	// the instance is counted while it is alive
+	if _, err := sh.leases.Acquire(ctx, instanceLease(sh.leases.Owner())); err != nil {
+		sh.app.log.Error("failed to acquire instance lease", logger.Err, err)
+		return
+	}
+	instances, err := sh.leases.Count(ctx, instanceLease("*"))
+	if err != nil {
+		sh.app.log.Error("failed to count instances", logger.Err, err)
+		return
+	}
+
+	for key, sub := range sh.owned {
+		sh.keep(ctx, key, sub)
+	}
+
+	subs := sh.subscriptions(ctx)
+
+	share := len(subs)
+	if instances > 1 {
+		share = (len(subs) + instances - 1) / instances
+	}
+
+	for _, sub := range subs {
+		if len(sh.owned) >= share {
+			break
+		}
+		if _, found := sh.owned[sub.key]; !found {
+			sh.acquire(ctx, sub)
+		}
+	}
+
+	// excess is handed over gradually, e.g. to a started instance
+	if len(sh.owned) > share {
+		for key := range sh.owned {
+			sh.handOver(key)
+			break
+		}
+	}
+}
+
+// Subscription stored by the bot.
+type subscription struct {
+	key    id.Key
+	filter *url.URL
+}
+
+// Returns subscribed members stored by the bot, e.g. members who stopped are skipped.
+func (sh *shard) subscriptions(ctx context.Context) []subscription {
+	var subs []subscription
+	for key := range bot.LoadKeys(ctx, sh.app.rdb) {
+		state, filter, err := bot.LoadSubscription(ctx, sh.app.rdb, key)
+		if err != nil || state != bot.Subscribed {
+			if err != nil && !errors.Is(err, bot.ErrNotFound) {
+				sh.app.log.WithKey(key).Error("failed to load subscription", logger.Err, err)
+			}
+			continue
+		}
+
+		subs = append(subs, subscription{key: key, filter: filter})
+	}
+
+	return subs
+}
+
+// Renews lease of the owned subscription, the stopped subscription is unregistered.
+func (sh *shard) keep(ctx context.Context, key id.Key, sub owned) {
+	log := sh.app.log.WithKey(key)
+
+	ok, err := sh.leases.Renew(ctx, subscriptionLease(key))
+	switch {
+	case err != nil:
+		// scanning goes on while redis is unavailable, notifications are claimed
+		log.Error("failed to renew subscription lease", logger.Err, err)
+		return
+	case !ok:
+		log.Warn("lost subscription lease")
+		_ = sh.app.scanServ.Detach(key)
+		delete(sh.owned, key)
+		return
+	}
+
+	state, filter, err := bot.LoadSubscription(ctx, sh.app.rdb, key)
+	switch {
+	case errors.Is(err, bot.ErrNotFound) || err == nil && state != bot.Subscribed:
+		log.Info("subscription stopped")
+		if err = sh.app.scanServ.UnRegister(key); err != nil {
+			log.Error("failed to unsubscribe", logger.Err, err)
+		}
+		sh.release(key)
+		return
+	case err != nil:
+		log.Error("failed to load subscription", logger.Err, err)
+		return
+	}
+
+	settings, err := bot.LoadSettings(ctx, sh.app.rdb, key)
+	if err != nil {
+		log.Error("failed to load settings", logger.Err, err)
+		return
+	}
+	if filter.String() == sub.url && settings == sub.settings {
+		return
+	}
+
+	// settings changed by the leader
+	if err = sh.app.scanServ.Retarget(key, pageURLs(*filter, settings.Pages, sh.pages, sh.quota)); err == nil {
+		err = sh.app.scanServ.Reschedule(key, ownInterval(settings, sh.quota))
+	}
+	if err != nil {
+		log.Error("failed to apply settings", logger.Err, err)
+		return
+	}
+	sh.owned[key] = owned{url: filter.String(), settings: settings}
+}
+
+// Acquires lease of the free subscription and starts scanning it.
+func (sh *shard) acquire(ctx context.Context, sub subscription) {
+	key, filter := sub.key, sub.filter
+	log := sh.app.log.WithKey(key)
+
+	settings, err := bot.LoadSettings(ctx, sh.app.rdb, key)
+	if err != nil {
+		log.Error("failed to load settings", logger.Err, err)
+	}
+
+	ok, err := sh.leases.Acquire(ctx, subscriptionLease(key))
+	if err != nil {
+		log.Error("failed to acquire subscription lease", logger.Err, err)
+	}
+	if !ok {
+		return
+	}
+
+	if err = sh.app.scanServ.Register(key, pageURLs(*filter, settings.Pages, sh.pages, sh.quota)); err != nil {
+		log.Error("failed to register scanning", logger.Err, err)
+		sh.release(key)
+		return
+	}
+	_ = sh.app.scanServ.Reschedule(key, ownInterval(settings, sh.quota))
+
+	log.Info("acquired subscription")
+	sh.owned[key] = owned{url: filter.String(), settings: settings}
+
+	// balance is not blocked by loading of visited links
+	go func() {
+		if err := sh.app.scanServ.Start(context.Background(), key); err != nil {
+			log.Error("failed to start scanning", logger.Err, err)
+
+			// the subscription is dropped on renewal of the released lease
+			_ = sh.app.scanServ.Detach(key)
+			sh.releaseLease(key)
+		}
+	}()
+}
+
+// Stops scanning the subscription and releases its lease to another instance.
+func (sh *shard) handOver(key id.Key) {
+	sh.app.log.WithKey(key).Info("handing over subscription")
+
+	_ = sh.app.scanServ.Detach(key)
+	sh.release(key)
+}
+
+func (sh *shard) release(key id.Key) {
+	sh.releaseLease(key)
+	delete(sh.owned, key)
+}
+
+// Releases lease of the subscription, safe to invoke concurrently with balance.
+func (sh *shard) releaseLease(key id.Key) {
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	if err := sh.leases.Release(ctx, subscriptionLease(key)); err != nil {
+		sh.app.log.WithKey(key).Error("failed to release subscription lease", logger.Err, err)
+	}
+}
+
+// Releases leases of the instance, so its subscriptions are taken over without waiting for expiration.
+func (sh *shard) releaseAll() {
+	for key := range sh.owned {
+		sh.release(key)
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	if err := sh.leases.Release(ctx, instanceLease(sh.leases.Owner())); err != nil {
+		sh.app.log.Error("failed to release instance lease", logger.Err, err)
+	}
+}
+
+// Returns quota of subscriptions of the config.
+func quota(app *Application) bot.Quota {
+	return bot.Quota{
+		MaxSubscriptions: app.config.Bot.MaxSubscriptions,
+		MinInterval:      app.config.Bot.MinScanInterval,
+		MaxInterval:      app.config.Bot.MaxScanInterval,
+		MaxPages:         app.config.Bot.MaxPages,
+	}
+}
+
+// Returns own interval of the subscription within quota, zero if the service interval is used.
+func ownInterval(settings bot.Settings, quota bot.Quota) time.Duration {
+	if settings.Interval <= 0 {
+		return 0
+	}
+
+	return boundInterval(settings.Interval, quota)
+}
+
+// Stores the subscription before it is acquired by an instance, so it survives restart of the leader.
+// Restored subscription is stored already. Returns scanner.ErrExist if the member is subscribed.
+func storeSubscription(app *Application, key id.Key, filter url.URL, restore bool) error {
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	state, stored, err := bot.LoadSubscription(ctx, app.rdb, key)
+	switch {
+	case err != nil && !errors.Is(err, bot.ErrNotFound):
+		return err
+	case err == nil && state == bot.Subscribed:
+		if restore && stored.String() == filter.String() {
+			return nil
+		}
+		return scanner.ErrExist
+	}
+
+	return bot.StoreSubscription(ctx, app.rdb, key, filter)
+}
+
+// Removes the stored subscription, so it is unregistered by the instance scanning it.
+// Returns scanner.ErrNotExist if the member is not subscribed.
+func deleteSubscription(app *Application, key id.Key) error {
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	state, _, err := bot.LoadSubscription(ctx, app.rdb, key)
+	switch {
+	case errors.Is(err, bot.ErrNotFound) || err == nil && state != bot.Subscribed:
+		return scanner.ErrNotExist
+	case err != nil:
+		return err
+	}
+
+	return bot.DeleteSubscription(ctx, app.rdb, key)
+}
+
+// Forwards the command to the instance scanning the subscription.
+// Returns scanner.ErrNotExist if the subscription is not scanned by any instance.
+func forward(app *Application, cmd ops.Command) error {
+	if app.leases == nil {
+		return scanner.ErrNotExist
+	}
+
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	holder, err := app.leases.Holder(ctx, subscriptionLease(cmd.Key()))
+	switch {
+	case err != nil:
+		return err
+	case holder == "" || holder == app.leases.Owner():
+		return scanner.ErrNotExist
+	}
+
+	cmd.At = app.clock.Now()
+	return app.ops.PushTo(ctx, holder, cmd)
+}
+
+// Crawls the subscription scanned by any instance immediately.
+func crawlNow(app *Application, key id.Key) error {
+	err := app.scanServ.CrawlNow(key)
+	if errors.Is(err, scanner.ErrNotExist) {
+		return forward(app, ops.Command{Op: ops.OpCrawlNow, ChatID: int64(key.ChatID), User: key.UserName})
+	}
+
+	return err
+}
+
+// Adds or removes the favorite of the subscription scanned by any instance.
+func favorite(ctx context.Context, app *Application, key id.Key, href string, add bool) error {
+	var err error
+	op := ops.OpFavorite
+	if add {
+		err = app.scanServ.AddFavorite(ctx, key, href)
+	} else {
+		op = ops.OpUnfavorite
+		err = app.scanServ.RemoveFavorite(ctx, key, href)
+	}
+
+	if errors.Is(err, scanner.ErrNotExist) {
+		return forward(app, ops.Command{Op: op, ChatID: int64(key.ChatID), User: key.UserName, Href: href})
+	}
+
+	return err
+}
+
+// Claims notification of the subscription about the ad, so it is sent once, e.g. after takeover of the subscription.
+// The notification is sent if redis is unavailable.
+func claimNotification(ctx context.Context, app *Application, key id.Key, href string) bool {
+	ctx, stop := context.WithTimeout(ctx, claimTimeout)
+	defer stop()
+
+	// claim expires with visited ads
+	ok, err := app.ops.ClaimNotification(ctx, key, path.Base(href), clock.Since(app.clock, app.scanServ.RetainedSince()))
+	if err != nil {
+		app.log.WithKey(key).Warn("failed to claim notification", "href", href, logger.Err, err)
+		return true
+	}
+
+	return ok
+}
+
+// Releases claim of the notification failed to send, so it is sent again, e.g. by the instance taking over the subscription.
+func releaseNotification(app *Application, key id.Key, href string) {
+	ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+	defer stop()
+
+	if err := app.ops.ReleaseNotification(ctx, key, path.Base(href)); err != nil {
+		app.log.WithKey(key).Warn("failed to release notification", "href", href, logger.Err, err)
+	}
+}
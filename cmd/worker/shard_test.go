@@ -0,0 +1,328 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/bot"
+	"krisha_kz_bot/pkg/bot/fakeapi"
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/config"
+	webcrawler "krisha_kz_bot/pkg/crawler/web_crawler"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/lease"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/parser"
+	"krisha_kz_bot/pkg/scanner"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const (
+	testTimeout  = 5 * time.Second
+	testLeaseTTL = time.Minute
+	testFilter   = "https://krisha.kz/arenda/kvartiry/almaty/?das[live.rooms]=2"
+)
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// Returns application of the worker instance sharing subscriptions in the given redis,
+// its scanners do not reach krisha.kz.
+func newTestApp(t *testing.T, mr *miniredis.Miniredis, instance string) *Application {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	app := &Application{
+		config: &config.Config{},
+		rdb:    rdb,
+		ops:    ops.NewStore(rdb),
+		clock:  clock.New(),
+		log:    logger.Default().With("instance", instance),
+		leases: lease.New(rdb, instance, testLeaseTTL, nil),
+	}
+	app.config.Scanner.Pages = 1
+
+	app.scanServ = scanner.NewServiceFromConfig(&scanner.Config[string]{
+		Config: webcrawler.Config[holder.WithDT[string]]{
+			Interval: time.Hour,
+			Parser: parser.Func[holder.WithDT[string]](func(io.Reader, parser.HandlerFunc[holder.WithDT[string]]) error {
+				return nil
+			}),
+		},
+		Client: &http.Client{Transport: roundTripFunc(func(*http.Request) (*http.Response, error) {
+			return nil, errors.New("unreachable")
+		})},
+		OnResult: func(ctx context.Context, key id.Key, href string) {},
+	}).WithRedis(rdb)
+	t.Cleanup(app.scanServ.StopAll)
+
+	return app
+}
+
+// Subscribes the members in private chats through the bot, the way the leader stores subscriptions.
+// Returns keys of the members.
+func subscribe(t *testing.T, mr *miniredis.Miniredis, users ...string) []id.Key {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	api := fakeapi.New("")
+	t.Cleanup(func() {
+		api.Close()
+		rdb.Close()
+	})
+
+	reply := func(key id.Key, text string) tgbotapi.Chattable {
+		return tgbotapi.NewMessage(int64(key.ChatID), text)
+	}
+	fake := clock.NewFake(time.Now())
+	serv, err := bot.NewServiceFromConfig(&bot.Config{
+		Token:        "test",
+		APIEndpoint:  api.Endpoint(),
+		UpdateConfig: tgbotapi.UpdateConfig{Timeout: bot.DefaultUpdateTimeout},
+		Clock:        fake,
+		HandlersConfig: bot.HandlersConfig{
+			OnSubscribe: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				return reply(key, "subscribed"), nil
+			},
+			OnStop: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				return reply(key, "stopped"), nil
+			},
+			OnKicked: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				return nil, nil
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create bot service, got error %v", err)
+	}
+	serv = serv.WithRedis(rdb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	go func() {
+		_ = serv.Start(ctx)
+	}()
+	defer func() { _ = serv.Shutdown() }()
+
+	keys := make([]id.Key, len(users))
+	for i, user := range users {
+		keys[i] = id.Key{ChatID: id.ChatID(fakeapi.User(user).ID), UserName: user}
+		api.PushUpdate(fakeapi.Message(int64(keys[i].ChatID), user, "/url "+testFilter))
+	}
+
+	// every subscription is replied once it is stored, outbound queue is flushed by the fake clock
+	deadline := time.Now().Add(testTimeout)
+	for len(api.Sent()) < len(users) {
+		if time.Now().After(deadline) {
+			t.Fatalf("want %d subscriptions replied, got %v", len(users), api.Sent())
+		}
+		fake.Advance(time.Minute)
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, key := range keys {
+		if state, _, err := bot.LoadSubscription(ctx, rdb, key); err != nil || state != bot.Subscribed {
+			t.Fatalf("want subscription of @%s stored, got state %v, error %v", key.UserName, state, err)
+		}
+	}
+
+	return keys
+}
+
+// Checks the subscriptions scanned by the instance.
+func expectOwned(t *testing.T, sh *shard, want ...id.Key) {
+	t.Helper()
+
+	if len(sh.owned) != len(want) {
+		t.Fatalf("want %s owning %d subscriptions, got %d", sh.leases.Owner(), len(want), len(sh.owned))
+	}
+	for _, key := range want {
+		if _, found := sh.owned[key]; !found {
+			t.Errorf("want %s owning @%s", sh.leases.Owner(), key.UserName)
+		}
+		if _, found := sh.app.scanServ.GetInterval(key); !found {
+			t.Errorf("want @%s scanned by %s", key.UserName, sh.leases.Owner())
+		}
+	}
+}
+
+func TestShardTakeover(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	keys := subscribe(t, mr, "alice", "bob")
+
+	a := newShard(newTestApp(t, mr, "a"))
+	a.balance(ctx, time.Second)
+	expectOwned(t, a, keys...)
+
+	// subscriptions are held by the alive instance
+	b := newShard(newTestApp(t, mr, "b"))
+	b.balance(ctx, time.Second)
+	expectOwned(t, b)
+
+	// crashed instance does not renew its leases
+	mr.FastForward(testLeaseTTL)
+	b.balance(ctx, time.Second)
+	expectOwned(t, b, keys...)
+
+	// and loses subscriptions once it is back
+	a.balance(ctx, time.Second)
+	expectOwned(t, a)
+}
+
+func TestShardHandover(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	keys := subscribe(t, mr, "alice", "bob")
+
+	a := newShard(newTestApp(t, mr, "a"))
+	a.balance(ctx, time.Second)
+	expectOwned(t, a, keys...)
+
+	// started instance gets the excess of the fair share
+	b := newShard(newTestApp(t, mr, "b"))
+	b.balance(ctx, time.Second)
+	a.balance(ctx, time.Second)
+	b.balance(ctx, time.Second)
+
+	if len(a.owned) != 1 || len(b.owned) != 1 {
+		t.Fatalf("want subscriptions shared equally, got %d and %d", len(a.owned), len(b.owned))
+	}
+	for key := range b.owned {
+		if _, found := a.app.scanServ.GetInterval(key); found {
+			t.Errorf("want @%s handed over, got scanned by both", key.UserName)
+		}
+		expectOwned(t, b, key)
+	}
+
+	// stopped instance releases its subscriptions to the rest
+	a.releaseAll()
+	b.balance(ctx, time.Second)
+	expectOwned(t, b, keys...)
+}
+
+func TestShardSubscribedOnly(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+	keys := subscribe(t, mr, "alice", "bob", "carol", "dave")
+
+	// members are stored, but not subscribed
+	for _, key := range keys[2:] {
+		state, _ := bot.Default.MarshalBinary()
+		mr.HSet("bot;"+key.String(), "state", string(state))
+	}
+
+	// fair share is counted over subscribed members only
+	a := newShard(newTestApp(t, mr, "a"))
+	a.balance(ctx, time.Second)
+	expectOwned(t, a, keys[:2]...)
+
+	b := newShard(newTestApp(t, mr, "b"))
+	b.balance(ctx, time.Second)
+	a.balance(ctx, time.Second)
+	b.balance(ctx, time.Second)
+
+	if len(a.owned) != 1 || len(b.owned) != 1 {
+		t.Fatalf("want subscriptions shared equally, got %d and %d", len(a.owned), len(b.owned))
+	}
+}
+
+func TestStoreSubscription(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := newTestApp(t, mr, "a")
+	key := id.Key{ChatID: 1, UserName: "alice"}
+	ctx := context.Background()
+
+	filter, _ := url.Parse(testFilter)
+	other, _ := url.Parse(testFilter + "&das[price][to]=300000")
+
+	if err := deleteSubscription(app, key); !errors.Is(err, scanner.ErrNotExist) {
+		t.Fatalf("want stop of missing subscription failed with %v, got %v", scanner.ErrNotExist, err)
+	}
+
+	// subscription is stored before it is acquired by an instance
+	if err := storeSubscription(app, key, *filter, false); err != nil {
+		t.Fatalf("failed to store subscription, got error %v", err)
+	}
+	if state, stored, err := bot.LoadSubscription(ctx, app.rdb, key); err != nil || state != bot.Subscribed || stored.String() != filter.String() {
+		t.Fatalf("want subscription on %s stored, got state %v, url %v, error %v", filter, state, stored, err)
+	}
+
+	if err := storeSubscription(app, key, *other, false); !errors.Is(err, scanner.ErrExist) {
+		t.Errorf("want subscription on other filter failed with %v, got %v", scanner.ErrExist, err)
+	}
+	if err := storeSubscription(app, key, *filter, false); !errors.Is(err, scanner.ErrExist) {
+		t.Errorf("want repeated subscription failed with %v, got %v", scanner.ErrExist, err)
+	}
+	// restored subscription is stored already
+	if err := storeSubscription(app, key, *filter, true); err != nil {
+		t.Errorf("want restored subscription accepted, got error %v", err)
+	}
+
+	if err := deleteSubscription(app, key); err != nil {
+		t.Fatalf("failed to delete subscription, got error %v", err)
+	}
+	if _, _, err := bot.LoadSubscription(ctx, app.rdb, key); !errors.Is(err, bot.ErrNotFound) {
+		t.Errorf("want subscription removed, got error %v", err)
+	}
+}
+
+func TestClaimNotificationOnFailure(t *testing.T) {
+	mr := miniredis.RunT(t)
+	app := newTestApp(t, mr, "a")
+	key := id.Key{ChatID: 1, UserName: "alice"}
+	ctx := context.Background()
+
+	// waits until the send is recorded
+	expectRecorded := func(n int) {
+		t.Helper()
+
+		deadline := time.Now().Add(testTimeout)
+		for {
+			notifications, err := app.ops.Notifications(ctx, key, n+1)
+			if err == nil && len(notifications) == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("want %d notifications recorded, got %d, error %v", n, len(notifications), err)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	for i, sent := range []error{nil, errors.New("Forbidden: bot was blocked by the user")} {
+		href := fmt.Sprintf("/a/show/%d", i+1)
+		if !claimNotification(ctx, app, key, href) {
+			t.Fatalf("want notification about %s claimed", href)
+		}
+		if claimNotification(ctx, app, key, href) {
+			t.Fatalf("want notification about %s claimed once", href)
+		}
+
+		// claim is released before the send is recorded
+		recordNotification(app, key, href, "")(tgbotapi.Message{MessageID: i + 1}, sent)
+		expectRecorded(i + 1)
+
+		// failed notification is claimed again, e.g. by the instance taking over the subscription
+		if got := claimNotification(ctx, app, key, href); got != (sent != nil) {
+			t.Errorf("want notification about %s claimed again %v, got %v", href, sent != nil, got)
+		}
+	}
+}
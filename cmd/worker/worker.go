@@ -3,48 +3,83 @@ package main
 import (
 	"context"
 	"errors"
+	"flag"
 	"fmt"
 	"krisha_kz_bot/pkg/bot"
 	"krisha_kz_bot/pkg/cleaner"
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/config"
+	"krisha_kz_bot/pkg/crawler/fixture"
+	"krisha_kz_bot/pkg/crawler/httpcache"
+	"krisha_kz_bot/pkg/crawler/scheduler"
+	"krisha_kz_bot/pkg/crawler/transport"
 	webcrawler "krisha_kz_bot/pkg/crawler/web_crawler"
+	"krisha_kz_bot/pkg/dedup"
 	"krisha_kz_bot/pkg/holder"
 	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/lease"
+	"krisha_kz_bot/pkg/listing"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/metrics"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/pacer"
 	krishakz "krisha_kz_bot/pkg/parser/krisha_kz"
 	"krisha_kz_bot/pkg/scanner"
 	"krisha_kz_bot/pkg/serv"
+	"krisha_kz_bot/pkg/tracing"
 	"krisha_kz_bot/pkg/utils"
 	"log"
+	"net/http"
 	"net/url"
 	"os"
 	"os/signal"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
 
 	"github.com/go-redis/redis/v9"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel"
 )
 
 type Application struct {
+	config     *config.Config
+	configPath string // optional YAML file watched for changes
+
 	scanServ *scanner.Service[string]
 	botServ  *bot.Service
 
 	shutdowners []serv.Shutdowner
 
-	cleansingServ cleaner.Cleansinger
-	cleaners      []cleaner.Cleaner
+	cleansingServ  cleaner.Cleansinger
+	cleaners       []cleaner.Cleaner
+	leaderCleaners []cleaner.Cleaner // cleaners of state shared by worker instances
+
+	leases *lease.Leases // leases of the worker instance, nil unless subscriptions are sharded
+
+	rdb      *redis.Client
+	ops      *ops.Store // operational state shared with web
+	clock    clock.Clock
+	location *time.Location // time zone of scanning
+	log      *logger.Logger
 
-	rdb *redis.Client
+	stopTracing func(context.Context) error // flushes exported spans
 }
 
 func main() {
-	app := &Application{}
+	app := &Application{clock: clock.New()}
 
+	setupConfig(app)
+	setupLogger(app)
+	setupTracing(app)
 	setupScanServ(app)
 	setupBotServ(app)
 
 	setupRedis(app)
+	setupLeases(app)
 
 	setupShutdowners(app)
 	setupCleansing(app)
@@ -52,11 +87,104 @@ func main() {
 	startWithGS(app)
 }
 
+// Loads config of optional YAML file of -config flag or CONFIG_FILE overridden by env variables.
+// Exits listing all invalid values, -print-config prints the config with secrets masked and exits.
+func setupConfig(app *Application) {
+	configPath := flag.String("config", os.Getenv("CONFIG_FILE"), "path of YAML config file, env variables override its values")
+	printConfig := flag.Bool("print-config", false, "print effective config with secrets masked and exit")
+	flag.Parse()
+
+	var err error
+	app.configPath = *configPath
+	if app.config, err = config.Load(app.configPath, os.LookupEnv); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if *printConfig {
+		if err = app.config.Print(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+}
+
+// Setups leveled logger of the config for the worker and libraries.
+func setupLogger(app *Application) {
+	// validated by config
+	level, _ := logger.ParseLevel(app.config.Log.Level)
+
+	app.log = logger.New(os.Stderr, level, app.clock)
+	logger.SetDefault(app.log)
+
+	// standard log of libraries and panics of setup
+	log.SetFlags(0)
+	log.SetOutput(app.log.Printer(logger.Info))
+
+	// bot api dumps requests and responses in debug mode
+	if level == logger.Debug {
+		_ = tgbotapi.SetLogger(app.log.Printer(logger.Debug))
+	} else {
+		_ = tgbotapi.SetLogger(app.log.Printer(logger.Warn))
+	}
+}
+
+// Setups export of traces to optional OTLP/HTTP collector.
+func setupTracing(app *Application) {
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		app.log.Warn("failed to export traces", logger.Err, err)
+	}))
+
+	var err error
+	if app.stopTracing, err = tracing.Setup(context.Background(), tracing.Config{
+		Endpoint:    app.config.Tracing.OTLPEndpoint,
+		ServiceName: "krisha_kz_bot_worker",
+		SampleRatio: app.config.Tracing.SampleRatio,
+	}); err != nil {
+		log.Panicf("failed to setup tracing, error %v", err)
+	}
+}
+
 func setupScanServ(app *Application) {
-	var scanInterval time.Duration = utils.ParseEnvOrPanic[time.Duration]("SCANNER_INTERVAL")
-	var scanTimeZone time.Location = utils.ParseEnvOrPanic[time.Location]("SCANNER_TIME_ZONE")
-	var visitedBufSize int = utils.ParseEnvOrPanic[int]("SCANNER_VISITED_BUF_SIZE")
-	var retentionPolicy time.Duration = utils.ParseEnvOrPanic[time.Duration]("SCANNER_RETENTION_POLICY")
+	var scanInterval time.Duration = app.config.Scanner.Interval
+	var scanTimeZone time.Location = *app.config.Location()
+	var visitedBufSize int = app.config.Scanner.VisitedBufSize
+	var retentionPolicy time.Duration = app.config.Scanner.RetentionPolicy
+
+	var scanRequestsPerMinute int = app.config.Scanner.RequestsPerMinute
+	var scanParallelism int = app.config.Scanner.Parallelism
+	var scanPagesDelay time.Duration = app.config.Scanner.PagesDelay
+
+	app.location = &scanTimeZone
+
+	// subscriptions are not scanned more often than quota allows
+	scanInterval = utils.GraterOrEqDefOr(scanInterval, app.config.Bot.MinScanInterval)
+
+	// optional proxies
+	proxyTransport, errProxy := transport.New(transport.Config{
+		Proxies: app.config.Crawler.Proxies,
+	})
+	if errProxy != nil {
+		log.Panic(errProxy)
+	}
+	proxyTransport.StartHealthCheck(context.Background())
+	// photos are loaded through proxies, but not cached
+	photoClient := transport.NewClient(proxyTransport)
+
+	// validated by config
+	dedupMode, _ := dedup.ParseMode(app.config.Scanner.DedupMode)
+
+	// old ads are kept to detect removed favorites, scanner notifies about today ads only
+	krishaParser := krishakz.NewParser(app.clock, &scanTimeZone)
+	krishaParser.KeepOld = true
+
+	var rt http.RoundTripper = httpcache.NewTransport(proxyTransport)
+	// optional directory to record crawled pages as parser fixtures
+	if recordDir := app.config.Crawler.RecordDir; recordDir != "" {
+		rt = fixture.NewRecorder(rt, recordDir)
+	}
+	client := transport.NewClient(rt)
 
 	app.scanServ = scanner.NewServiceFromConfig(
 		&scanner.Config[string]{
@@ -64,40 +192,239 @@ func setupScanServ(app *Application) {
 			VisitedBufSize:  visitedBufSize,
 			RetentionPolicy: retentionPolicy,
 			Config: webcrawler.Config[holder.WithDT[string]]{
-				Interval: scanInterval,
-				Parser:   krishakz.NewParser(&scanTimeZone),
+				Interval:    scanInterval,
+				Parser:      krishaParser,
+				Parallelism: scanParallelism,
+				PageDelay:   scanPagesDelay,
+				ContentTTL:  webcrawler.DefaultContentTTL,
+				Location:    &scanTimeZone,
+				Breakers:    webcrawler.NewBreakers(webcrawler.BreakerConfig{}),
+				Scheduler: scheduler.New(scheduler.Config{
+					RequestsPerMinute: scanRequestsPerMinute,
+					Clock:             app.clock,
+				}),
+				Clock: app.clock,
 			},
-			OnResult: func(key id.Key, href string) {
+			OnResult: func(ctx context.Context, key id.Key, href string) {
+				if !claimNotification(ctx, app, key, href) {
+					app.log.WithKey(key).Debug("notification already sent", "href", href)
+					return
+				}
+
 				text := fmt.Sprintf("@%s pls look at https://krisha.kz%s\n", key.UserName, href)
 
-				if err := app.botServ.SendMessage(tgbotapi.NewMessage(int64(key.ChatID), text)); err != nil {
-					log.Printf("failed to send message, error %v\n", err)
+				msg := tgbotapi.NewMessage(int64(key.ChatID), text)
+				if err := app.botServ.SendMessageFunc(ctx, msg, recordNotification(app, key, href, "")); err != nil {
+					app.log.WithKey(key).Error("failed to send message", logger.Err, err)
 				}
 			},
+			Client:      client,
+			DedupMode:   dedupMode,
+			PhotoHasher: dedup.NewHTTPPhotoHasher(photoClient),
+			OnDuplicate: func(ctx context.Context, key id.Key, href string, origin string) {
+				if !claimNotification(ctx, app, key, href) {
+					app.log.WithKey(key).Debug("notification already sent", "href", href)
+					return
+				}
+
+				text := fmt.Sprintf("@%s pls look at https://krisha.kz%s\npossibly same as https://krisha.kz%s\n",
+					key.UserName, href, origin)
+
+				msg := tgbotapi.NewMessage(int64(key.ChatID), text)
+				if err := app.botServ.SendMessageFunc(ctx, msg, recordNotification(app, key, href, origin)); err != nil {
+					app.log.WithKey(key).Error("failed to send message", logger.Err, err)
+				}
+			},
+			OnRemoved: func(ctx context.Context, key id.Key, href string) {
+				text := fmt.Sprintf("@%s ad https://krisha.kz%s has been removed\n", key.UserName, href)
+
+				if err := app.botServ.SendMessageContext(ctx, tgbotapi.NewMessage(int64(key.ChatID), text)); err != nil {
+					app.log.WithKey(key).Error("failed to send message", logger.Err, err)
+				}
+			},
+			OnChanged: func(ctx context.Context, key id.Key, prev, cur holder.WithDT[string]) {
+				text := fmt.Sprintf("@%s ad https://krisha.kz%s has been changed\n", key.UserName, cur.GetValue())
+				if p, c, ok := listings(prev, cur); ok && p.Price != c.Price {
+					text = fmt.Sprintf("@%s ad https://krisha.kz%s price changed from %d to %d\n",
+						key.UserName, cur.GetValue(), p.Price, c.Price)
+				}
+
+				if err := app.botServ.SendMessageContext(ctx, tgbotapi.NewMessage(int64(key.ChatID), text)); err != nil {
+					app.log.WithKey(key).Error("failed to send message", logger.Err, err)
+				}
+			},
+			IsRemoved: func(ctx context.Context, href string) bool {
+				return isRemoved(ctx, client, "https://krisha.kz"+href)
+			},
 		},
 	)
 }
 
+// Returns both values as listings.
+func listings(prev, cur holder.WithDT[string]) (*listing.Listing, *listing.Listing, bool) {
+	p, okPrev := prev.(*listing.Listing)
+	c, okCur := cur.(*listing.Listing)
+
+	return p, c, okPrev && okCur
+}
+
+// Returns true if the ad is not found, the ad is kept on failure.
+func isRemoved(ctx context.Context, client *http.Client, link string) bool {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, link, http.NoBody)
+	if err != nil {
+		return false
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		logger.FromContext(ctx).Warn("failed to check ad", logger.URL, link, logger.Err, err)
+		return false
+	}
+	resp.Body.Close()
+
+	return resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone
+}
+
 //nolint:gocognit // TODO
 func setupBotServ(app *Application) {
 	var (
 		err error
 
-		botAPIToken     string        = utils.ParseEnvOrPanic[string]("BOT_API_TOKEN")
-		botSendMsgBuf   int           = utils.ParseEnvOrPanic[int]("BOT_SEND_MSG_BUFFER")
-		botSendMsgDelay time.Duration = utils.ParseEnvOrPanic[time.Duration]("BOT_SEND_MSG_DELAY")
+		botAPIToken     string        = app.config.Bot.Token
+		botSendMsgBuf   int           = app.config.Bot.SendMsgBuffer
+		botSendMsgDelay time.Duration = app.config.Bot.SendMsgDelay
+
+		scanPagesCnt int = app.config.Scanner.Pages
 
-		scanPagesCnt int = utils.ParseEnvOrPanic[int]("SCANNER_PAGES")
+		botAdmins []int64 = app.config.Bot.Admins
+
+		botAllowlist        []int64       = app.config.Bot.Allowlist
+		botInvites          bool          = app.config.Bot.Invites
+		botInviteTTL        time.Duration = app.config.Bot.InviteTTL
+		botMaxSubscriptions int           = app.config.Bot.MaxSubscriptions
+		botMaxPages         int           = app.config.Bot.MaxPages
+		botMinScanInterval  time.Duration = app.config.Bot.MinScanInterval
+		botMaxScanInterval  time.Duration = app.config.Bot.MaxScanInterval
 	)
 
+	// token is a part of api urls, e.g. in errors of requests
+	app.log.Redact(botAPIToken)
+
 	if app.botServ, err = bot.NewServiceFromConfig(&bot.Config{
 		Token:        botAPIToken,
 		UpdateConfig: tgbotapi.UpdateConfig{Timeout: bot.DefaultUpdateTimeout},
-		Debug:        true,
+		Debug:        app.log.Enabled(logger.Debug),
 		SendMsgBuf:   botSendMsgBuf,
 		SendMsgDelay: botSendMsgDelay,
+		Admins:       botAdmins,
+		Access: bot.Access{
+			Allowlist: botAllowlist,
+			Invites:   botInvites,
+			InviteTTL: botInviteTTL,
+			Quota: bot.Quota{
+				MaxSubscriptions: botMaxSubscriptions,
+				MinInterval:      botMinScanInterval,
+				MaxInterval:      botMaxScanInterval,
+				MaxPages:         botMaxPages,
+			},
+		},
 		HandlersConfig: bot.HandlersConfig{
+			OnAdmin: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				reply := func(text string) tgbotapi.Chattable {
+					return tgbotapi.NewMessage(int64(key.ChatID), text)
+				}
+
+				if len(params) < 2 {
+					return reply(bot.AdminHelpText), nil
+				}
+
+				cmd, _ := params[0].(string)
+				args, _ := params[1].([]string)
+
+				switch cmd {
+				case "crawl-now":
+					target, e1 := bot.ParseAdminKey(args)
+					if e1 != nil {
+						return reply(e1.Error()), e1
+					}
+					if e2 := crawlNow(app, target); e2 != nil {
+						text := fmt.Sprintf("@%s not subscribed in chat %d", target.UserName, target.ChatID)
+						return reply(text), errors.New(text)
+					}
+					return reply(fmt.Sprintf("crawling @%s in chat %d", target.UserName, target.ChatID)), nil
+
+				case "health":
+					return reply(crawlHealth(app)), nil
+				}
+
+				return reply(bot.AdminHelpText), nil
+			},
+			OnHistory: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				if len(params) < 1 {
+					text := fmt.Sprintf("@%s, Please send /history [n]", key.UserName)
+					return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+				}
+
+				n, _ := params[0].(int)
+
+				ctx, stop := context.WithTimeout(context.Background(), scanner.DefaultRedisTimeout)
+				defer stop()
+
+				notifications, e1 := app.ops.Notifications(ctx, key, n)
+				if e1 != nil {
+					app.log.WithKey(key).Error("failed to load notifications", logger.Err, e1)
+					text := fmt.Sprintf("failed to load history of @%s", key.UserName)
+					return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+				}
+
+				return tgbotapi.NewMessage(int64(key.ChatID), history(app, key, notifications)), nil
+			},
+			OnSettings: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				if len(params) < 2 {
+					text := fmt.Sprintf("@%s, Please send /interval <duration> or /pages <n>", key.UserName)
+					return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+				}
+
+				filter, _ := params[0].(url.URL)
+				settings, _ := params[1].(bot.Settings)
+
+				urls := pageURLs(filter, settings.Pages, scanPagesCnt, bot.Quota{MaxPages: botMaxPages})
+
+				e1 := app.scanServ.Retarget(key, urls)
+				if e1 == nil {
+					e1 = app.scanServ.Reschedule(key, settings.Interval)
+				}
+				// stored settings are applied by the instance scanning the subscription
+				if e1 != nil && (app.leases == nil || !errors.Is(e1, scanner.ErrNotExist)) {
+					text := fmt.Sprintf("@%s not subscribed", key.UserName)
+					return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+				}
+
+				interval, found := app.scanServ.GetInterval(key)
+				if !found {
+					interval = utils.GraterOrEqDefOr(ownInterval(settings, quota(app)), app.config.Scanner.Interval)
+				}
+				text := fmt.Sprintf("@%s scanning %d pages every %s", key.UserName, len(urls), interval)
+
+				return tgbotapi.NewMessage(int64(key.ChatID), text), nil
+			},
 			OnStop: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				// stopped subscription is unregistered by the instance scanning it
+				if app.leases != nil {
+					if e1 := deleteSubscription(app, key); e1 != nil {
+						switch {
+						case errors.Is(e1, scanner.ErrNotExist):
+							text := fmt.Sprintf("@%s not subscribed", key.UserName)
+							return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+						default:
+							text := fmt.Sprintf("failed to unsubscribe @%s", key.UserName)
+							return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+						}
+					}
+
+					return tgbotapi.NewMessage(int64(key.ChatID), fmt.Sprintf("Subscription stop scheduled for @%s", key.UserName)), nil
+				}
+
 				if e1 := app.scanServ.UnRegister(key); e1 != nil {
 					switch {
 					case errors.Is(e1, scanner.ErrNotExist):
@@ -112,14 +439,58 @@ func setupBotServ(app *Application) {
 				return tgbotapi.NewMessage(int64(key.ChatID), fmt.Sprintf("Subscription stopped for @%s", key.UserName)), nil
 			},
 			OnKicked: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				if app.leases != nil {
+					if e1 := deleteSubscription(app, key); e1 != nil && !errors.Is(e1, scanner.ErrNotExist) {
+						app.log.WithKey(key).Error("failed to unsubscribe", logger.Err, e1)
+					}
+					return nil, nil
+				}
+
 				if e1 := app.scanServ.UnRegister(key); e1 != nil {
-					e2 := fmt.Errorf("failed to unsubscribe @%s, error %w", key.UserName, e1)
-					log.Println(e2)
+					app.log.WithKey(key).Error("failed to unsubscribe", logger.Err, e1)
 					return nil, err
 				}
 
 				return nil, nil
 			},
+			OnFavorite: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				var (
+					href   string
+					add    bool
+					okHref bool
+					okAdd  bool
+				)
+				if len(params) == 2 {
+					href, okHref = params[0].(string)
+					add, okAdd = params[1].(bool)
+				}
+				if !okHref || !okAdd {
+					text := fmt.Sprintf("@%s failed to parse ad link", key.UserName)
+					return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+				}
+
+				if !add {
+					if e1 := favorite(context.Background(), app, key, href, false); e1 != nil {
+						text := fmt.Sprintf("@%s not subscribed", key.UserName)
+						return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+					}
+
+					return tgbotapi.NewMessage(int64(key.ChatID), fmt.Sprintf("@%s stopped tracking %s", key.UserName, href)), nil
+				}
+
+				if e1 := favorite(context.Background(), app, key, href, true); e1 != nil {
+					switch {
+					case errors.Is(e1, scanner.ErrNotExist):
+						text := fmt.Sprintf("@%s not subscribed", key.UserName)
+						return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+					default:
+						text := fmt.Sprintf("failed to add favorite for @%s", key.UserName)
+						return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+					}
+				}
+
+				return tgbotapi.NewMessage(int64(key.ChatID), fmt.Sprintf("@%s tracking %s", key.UserName, href)), nil
+			},
 			OnSubscribe: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
 				var (
 					textErrParse                    = fmt.Sprintf("@%s failed to parse url", key.UserName)
@@ -127,7 +498,7 @@ func setupBotServ(app *Application) {
 					errParse                        = errors.New(textErrParse)
 				)
 
-				if len(params) < 1 {
+				if len(params) < 2 {
 					return respErrParse, errParse
 				}
 
@@ -138,17 +509,34 @@ func setupBotServ(app *Application) {
 					return respErrParse, errParse
 				}
 
-				urls := make([]string, scanPagesCnt)
+				quota, _ := params[1].(bot.Quota)
+				var settings bot.Settings
+				if len(params) > 2 {
+					settings, _ = params[2].(bot.Settings)
+				}
 
-				for i := 0; i < scanPagesCnt; i++ {
-					u := filter
-					vals := u.Query()
-					vals.Add("page", strconv.Itoa(i+1))
-					u.RawQuery = vals.Encode()
+				// subscription is scanned by the instance acquiring it
+				if app.leases != nil {
+					if filter.Hostname() != "krisha.kz" {
+						return respErrParse, errParse
+					}
 
-					urls[i] = u.String()
+					if e1 := storeSubscription(app, key, filter, update == nil); e1 != nil {
+						switch {
+						case errors.Is(e1, scanner.ErrExist):
+							text := fmt.Sprintf("@%s already subscribed", key.UserName)
+							return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+						default:
+							text := fmt.Sprintf("failed to subscribe @%s", key.UserName)
+							return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+						}
+					}
+
+					return tgbotapi.NewMessage(int64(key.ChatID), fmt.Sprintf("@%s subscription scheduled for notifications", key.UserName)), nil
 				}
 
+				urls := pageURLs(filter, settings.Pages, scanPagesCnt, quota)
+
 				if e1 := app.scanServ.Register(key, urls); e1 != nil {
 					switch {
 					case errors.Is(e1, scanner.ErrExist):
@@ -159,6 +547,7 @@ func setupBotServ(app *Application) {
 						return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
 					}
 				} else {
+					_ = app.scanServ.Reschedule(key, ownInterval(settings, quota))
 					if e2 := app.scanServ.Start(context.Background(), key); e2 != nil {
 						switch {
 						case errors.Is(e2, scanner.ErrNotExist):
@@ -180,26 +569,106 @@ func setupBotServ(app *Application) {
 	}
 }
 
+// Returns urls of the filter pages to scan.
+// Pages of the subscription or the default ones are limited by quota.
+func pageURLs(filter url.URL, pages, defaultPages int, quota bot.Quota) []string {
+	if pages <= 0 {
+		pages = defaultPages
+	}
+	if quota.MaxPages > 0 && quota.MaxPages < pages {
+		pages = quota.MaxPages
+	}
+
+	urls := make([]string, pages)
+	for i := 0; i < pages; i++ {
+		u := filter
+		vals := u.Query()
+		vals.Add("page", strconv.Itoa(i+1))
+		u.RawQuery = vals.Encode()
+
+		urls[i] = u.String()
+	}
+
+	return urls
+}
+
+// Returns interval of the subscription within quota, the quota could be changed after the interval has been set.
+func boundInterval(interval time.Duration, quota bot.Quota) time.Duration {
+	if interval < quota.MinInterval {
+		return quota.MinInterval
+	}
+	if quota.MaxInterval > 0 && interval > quota.MaxInterval {
+		return quota.MaxInterval
+	}
+
+	return interval
+}
+
+// Returns crawling status of subscriptions.
+func crawlHealth(app *Application) string {
+	statuses := app.scanServ.GetStatuses()
+	keys := make([]id.Key, 0, len(statuses))
+	for key := range statuses {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].String() < keys[j].String()
+	})
+
+	var b strings.Builder
+	blocked := 0
+	for _, key := range keys {
+		status := statuses[key]
+		stats, _ := app.scanServ.GetStats(key)
+
+		state := "ok"
+		switch {
+		case status.At.IsZero():
+			state = "pending"
+		case status.IsBlocked():
+			state = "blocked"
+			blocked++
+		case status.Err != nil:
+			state = "failed"
+		}
+
+		fmt.Fprintf(&b, "%d @%s %s at %s, results %d, crawls %d, requests %d\n",
+			key.ChatID, key.UserName, state, status.At.Format(time.RFC3339), status.Results, stats.Crawls, stats.Requests)
+	}
+
+	return fmt.Sprintf("subscriptions %d, blocked %d\n%s", len(keys), blocked, b.String())
+}
+
 func setupCleansing(app *Application) {
-	app.cleaners = []cleaner.Cleaner{app.botServ, app.scanServ}
-	app.cleansingServ = cleaner.CleansingFnc(cleansing)
+	app.cleaners = []cleaner.Cleaner{app.scanServ}
+	app.leaderCleaners = []cleaner.Cleaner{app.botServ, notificationsCleaner{app: app}}
+	app.cleansingServ = cleansing(app.clock)
 }
 
-func cleansing(ctx context.Context, interval time.Duration, onTimer func()) {
-	retryTimer := time.NewTimer(interval)
-	defer retryTimer.Stop()
+func clean(cleaners []cleaner.Cleaner) {
+	for _, cleaner := range cleaners {
+		cleaner.Clean()
+	}
+}
 
-	for {
-		select {
-		case <-ctx.Done():
-			log.Printf("stopping cleansing\n")
-			return
+// Returns cleansing invoking onTimer by interval of the given clock.
+func cleansing(clk clock.Clock) cleaner.CleansingFnc {
+	return func(ctx context.Context, interval time.Duration, onTimer func()) {
+		retryTimer := clk.NewTimer(interval)
+		defer retryTimer.Stop()
 
-		case <-retryTimer.C:
-			onTimer()
+		for {
+			select {
+			case <-ctx.Done():
+				logger.FromContext(ctx).Info("stopping cleansing")
+				return
 
-			// reset timer
-			retryTimer.Reset(interval)
+			case <-retryTimer.C():
+				onTimer()
+
+				// reset timer
+				retryTimer.Reset(interval)
+			}
 		}
 	}
 }
@@ -209,25 +678,34 @@ func setupShutdowners(app *Application) {
 		app.scanServ,
 		app.botServ,
 		serv.ShutdownerFunc(func() error {
-			log.Println("shutting down redis client")
+			app.log.Info("shutting down redis client")
 			return app.rdb.Close()
 		}),
+		serv.ShutdownerFunc(func() error {
+			ctx, cancel := context.WithTimeout(context.Background(), tracing.DefaultShutdownTimeout)
+			defer cancel()
+
+			app.log.Info("flushing traces")
+			return app.stopTracing(ctx)
+		}),
 	}
 }
 
 func setupRedis(app *Application) {
 	var (
-		redisURL string = utils.ParseEnvOrPanic[string]("REDIS_URL")
+		redisURL string = app.config.Redis.URL
 	)
 
 	if opt, err := redis.ParseURL(redisURL); err == nil {
 		app.rdb = redis.NewClient(opt)
+		app.rdb.AddHook(metrics.RedisHook{})
 	} else {
 		log.Panicf("failed to parse redis url %s, error %v", redisURL, err)
 	}
 
 	app.scanServ.WithRedis(app.rdb)
 	app.botServ.WithRedis(app.rdb)
+	app.ops = ops.NewStore(app.rdb)
 }
 
 func startWithGS(app *Application) {
@@ -238,45 +716,70 @@ func startWithGS(app *Application) {
 	// except SIGKILL, SIGQUIT will not be caught
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
 
-	var cleansingInterval time.Duration = utils.ParseEnvOrPanic[time.Duration]("CACHE_CLEANSING_INTERVAL")
+	var cleansingInterval time.Duration = app.config.Worker.CleansingInterval
 
-	// run non-blocking cleansing
-	go app.cleansingServ.Start(context.Background(), cleansingInterval, func() {
-		for _, cleaner := range app.cleaners {
-			cleaner.Clean()
-		}
-	})
+	opsCtx, stopOps := context.WithCancel(context.Background())
 
-	// run non-blocking serv
-	go func() {
-		// start telegram bot
-		if err := app.botServ.Start(context.Background()); err != nil {
-			log.Panic(err)
-		} else {
-			log.Println("bot service stoppped")
-		}
-	}()
+	// leases are released before redis client is closed
+	var leasesWG sync.WaitGroup
 
-	// load from persistance storage
-	app.botServ.LoadFromRedis(context.Background())
+	if app.leases != nil {
+		startSharding(opsCtx, app, &leasesWG, func() {
+			select {
+			case c <- syscall.SIGTERM:
+			default:
+			}
+		})
+	} else {
+		// run non-blocking cleansing
+		go app.cleansingServ.Start(context.Background(), cleansingInterval, func() {
+			clean(app.cleaners)
+			clean(app.leaderCleaners)
+		})
+
+		// run non-blocking serv
+		go func() {
+			// start telegram bot
+			if err := app.botServ.Start(context.Background()); err != nil {
+				log.Panic(err)
+			} else {
+				app.log.Info("bot service stopped")
+			}
+		}()
 
-	// block until we receive our signal.
-	<-c
+		// load from persistance storage
+		app.botServ.LoadFromRedis(context.Background())
 
-	const (
-		defaultGSTimeout = time.Second * 15
-	)
+		go publishHeartbeats(opsCtx, app, ops.DefaultHeartbeatInterval)
+		go serveCommands(opsCtx, app, app.ops.Commands(opsCtx))
+	}
 
-	var (
-		gsTimeout time.Duration
-		err       error
-	)
+	// share operational state with web
+	go publishStatuses(opsCtx, app, ops.DefaultStatusInterval)
+
+	// apply changes of config file without restart
+	go watchConfig(opsCtx, app, config.DefaultWatchInterval)
+
+	// crawl subscriptions by arrivals of their ads
+	if app.config.Scanner.Adaptive {
+		go adaptIntervals(opsCtx, app, pacer.DefaultUpdateInterval)
+	}
 
-	if gsTimeout, err = time.ParseDuration(os.Getenv("GRACEFUL_SHUTDOWN_TIMEOUT")); err != nil {
-		gsTimeout = defaultGSTimeout
+	// optional health endpoints of worker itself
+	if addr := app.config.Worker.HealthAddr; addr != "" {
+		go serveHealth(app, addr)
 	}
 
-	log.Println("starting shut down")
+	// block until we receive our signal.
+	<-c
+
+	// stop accepting commands before services shut down
+	stopOps()
+	leasesWG.Wait()
+
+	gsTimeout := app.config.Worker.GracefulShutdownTimeout
+
+	app.log.Info("starting shut down")
 	ctx, cancel := context.WithTimeout(context.Background(), gsTimeout)
 	defer cancel()
 
@@ -288,7 +791,7 @@ func startWithGS(app *Application) {
 			defer wg.Done()
 
 			if e := shutdowner.Shutdown(); e != nil {
-				log.Println(e.Error())
+				app.log.Error("failed to shut down", logger.Err, e)
 			}
 		}(shutdowner)
 	}
@@ -300,10 +803,10 @@ func startWithGS(app *Application) {
 
 	// block until all services completed their work or timeout
 	<-ctx.Done()
-	if e := ctx.Err(); e != nil {
-		log.Println(e.Error())
+	if e := ctx.Err(); e != nil && !errors.Is(e, context.Canceled) {
+		app.log.Error("failed to shut down in time", logger.Err, e)
 	}
 
-	log.Println("ending shut down")
+	app.log.Info("ending shut down")
 	// os.Exit(0)
 }
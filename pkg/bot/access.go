@@ -0,0 +1,189 @@
+package bot
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+var ErrAccessDenied = errors.New("access denied")
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+const (
+	grantedKey   = "bot;access;granted" // set of user ids granted by invite codes
+	invitePrefix = "bot;invite:"        // one-time invite codes
+
+	inviteCodeLen = 8
+)
+
+// Access policy of the bot.
+// The bot is open to everyone if allowlist is empty and invites are not required.
+type Access struct {
+	Allowlist []int64       // user or chat ids allowed to use the bot
+	Invites   bool          // requires one-time invite code given in /start <code> from users not in allowlist
+	InviteTTL time.Duration // invite codes never expire if zero
+	Quota     Quota
+}
+
+// Quota of a user, zero values are unlimited.
+type Quota struct {
+	MaxSubscriptions int           // subscriptions of a user across all chats
+	MinInterval      time.Duration // minimal scan interval of a subscription
+	MaxInterval      time.Duration // maximal scan interval of a subscription
+	MaxPages         int           // maximal pages to scan of a filter
+}
+
+// Returns true if access is restricted by allowlist or invites.
+func (a *Access) isRestricted() bool {
+	return len(a.Allowlist) > 0 || a.Invites
+}
+
+// Returns true if the user or chat is allowed to use the bot.
+// Invoke under lock.
+func (s *Service) isAllowed(userID int64, chatID id.ChatID) bool {
+	if !s.config.Access.isRestricted() || s.IsAdmin(userID) {
+		return true
+	}
+
+	for _, v := range s.config.Access.Allowlist {
+		if v == userID || v == int64(chatID) {
+			return true
+		}
+	}
+
+	_, found := s.granted[userID]
+
+	return found
+}
+
+// Returns number of subscriptions of the user across all chats.
+// Invoke under lock.
+func (s *Service) countSubs(userName string) int {
+	cnt := 0
+	for key, state := range s.states {
+		if key.UserName == userName && state == Subscribed {
+			cnt++
+		}
+	}
+
+	return cnt
+}
+
+func (w *wrapper) isAllowed(userID int64) bool {
+	return w.s.isAllowed(userID, w.key.ChatID)
+}
+
+func (w *wrapper) countSubs() int {
+	return w.s.countSubs(w.key.UserName)
+}
+
+// Checks access of the message sender.
+func checkAccess(update *tgbotapi.Update, key id.Key, s getter) (tgbotapi.Chattable, error) {
+	if update.Message.From != nil && s.isAllowed(update.Message.From.ID) {
+		return nil, nil
+	}
+
+	text := fmt.Sprintf("@%s, %s, please ask an admin for an invite and send /start <code>", key.UserName, ErrAccessDenied)
+
+	return tgbotapi.NewMessage(int64(key.ChatID), text), ErrAccessDenied
+}
+
+// Checks subscriptions quota of the user, the current subscription of the member is not counted.
+func checkQuota(key id.Key, s getter, cfg *Config) (tgbotapi.Chattable, error) {
+	maxSubs := cfg.Access.Quota.MaxSubscriptions
+	if maxSubs <= 0 {
+		return nil, nil
+	}
+
+	cnt := s.countSubs()
+	if state, found := s.getState(); found && state == Subscribed {
+		cnt--
+	}
+
+	if cnt < maxSubs {
+		return nil, nil
+	}
+
+	text := fmt.Sprintf("@%s, %s, %d subscriptions at most, please /stop one of them", key.UserName, ErrQuotaExceeded, maxSubs)
+
+	return tgbotapi.NewMessage(int64(key.ChatID), text), ErrQuotaExceeded
+}
+
+// Creates n one-time invite codes valid for configured ttl.
+func (s *Service) Invite(ctx context.Context, n int) ([]string, error) {
+	ttl := s.config.Access.InviteTTL
+
+	codes := make([]string, 0, n)
+
+	for i := 0; i < n; i++ {
+		raw := make([]byte, inviteCodeLen/2)
+		if _, err := rand.Read(raw); err != nil {
+			return codes, errors.Wrap(err, "failed to generate invite code")
+		}
+		code := hex.EncodeToString(raw)
+
+		if err := s.rdb.Set(ctx, invitePrefix+code, 1, ttl).Err(); err != nil {
+			return codes, fmt.Errorf("failed redis:set %s%s, error %w", invitePrefix, code, err)
+		}
+		codes = append(codes, code)
+	}
+
+	logger.FromContext(ctx).Info("created invite codes", "codes", len(codes))
+
+	return codes, nil
+}
+
+// Redeems the invite code and grants access to the user.
+// Returns false if code is unknown or has been already redeemed.
+// Invoke under lock.
+func (w *wrapper) redeem(userID int64, code string) bool {
+	// deletion is atomic, the code can be redeemed once
+	deleted, err := w.s.rdb.Del(w.ctx, invitePrefix+code).Result()
+	if err != nil {
+		w.log().Error("failed redis:del", "key", invitePrefix+code, logger.Err, err)
+		return false
+	}
+	if deleted == 0 {
+		return false
+	}
+
+	w.s.granted[userID] = struct{}{}
+
+	if err = w.s.rdb.SAdd(w.ctx, grantedKey, userID).Err(); err != nil {
+		w.log().Error("failed redis:sadd", "key", grantedKey, "value", userID, logger.Err, err)
+	} else {
+		w.log().Debug("success redis:sadd", "key", grantedKey, "value", userID)
+	}
+
+	return true
+}
+
+// Loads users granted by invite codes.
+func (s *Service) loadGranted(ctx context.Context) {
+	members, err := s.rdb.SMembers(ctx, grantedKey).Result()
+	if err != nil {
+		logger.FromContext(ctx).Error("failed redis:smembers", "key", grantedKey, logger.Err, err)
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for _, v := range members {
+		userID, e := strconv.ParseInt(v, 10, 64)
+		if e != nil {
+			logger.FromContext(ctx).Warn("failed to parse granted user", "value", v, logger.Err, e)
+			continue
+		}
+		s.granted[userID] = struct{}{}
+	}
+}
@@ -0,0 +1,271 @@
+package bot
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+var ErrNotAuthorized = errors.New("not authorized")
+
+const maxInvites = 20
+
+// Help on admin commands.
+const (
+	AdminHelpText = `🛠 Admin commands
+	/admin stats - members and subscriptions
+	/admin subs - subscriptions with filters
+	/admin kick <chat> <user> - stop subscription of the user
+	/admin broadcast <text> - send text to all chats with subscriptions
+	/admin invite [n] - create one-time invite codes
+	/admin crawl-now <chat> <user> - crawl subscription immediately
+	/admin health - crawling status of subscriptions`
+)
+
+// Subscription state of a chat member.
+type Subscriber struct {
+	Key   id.Key
+	State State
+	URL   string
+}
+
+// Returns true if the user is configured as an admin.
+func (s *Service) IsAdmin(userID int64) bool {
+	for _, adminID := range s.config.Admins {
+		if adminID == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Authorizes /admin commands, passes other messages to the given handler.
+func withAdmin(s *Service, fnc func(update *tgbotapi.Update, key id.Key) (tgbotapi.Chattable, error)) HandlerFunc {
+	return func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+		if !isAdminCommand(update.Message.Text) {
+			return fnc(update, key)
+		}
+
+		if update.Message.From == nil || !s.IsAdmin(update.Message.From.ID) {
+			s.log(key).Warn("not authorized", "command", update.Message.Text)
+			text := fmt.Sprintf("@%s is %s", key.UserName, ErrNotAuthorized)
+			return tgbotapi.NewMessage(int64(key.ChatID), text), ErrNotAuthorized
+		}
+
+		s.log(key).Info("admin command requested", "command", update.Message.Text)
+
+		return s.servAdminCommand(update, key)
+	}
+}
+
+func isAdminCommand(text string) bool {
+	fields := strings.Fields(text)
+
+	return len(fields) > 0 && (fields[0] == "/admin" || strings.HasPrefix(fields[0], "/admin@"))
+}
+
+// Serves admin command, commands unknown to bot service are passed to OnAdmin handler.
+func (s *Service) servAdminCommand(update *tgbotapi.Update, key id.Key) (tgbotapi.Chattable, error) {
+	fields := strings.Fields(update.Message.Text)
+	if len(fields) < 2 {
+		return tgbotapi.NewMessage(int64(key.ChatID), AdminHelpText), nil
+	}
+
+	cmd, args := fields[1], fields[2:]
+	reply := func(text string) tgbotapi.Chattable {
+		return tgbotapi.NewMessage(int64(key.ChatID), text)
+	}
+
+	switch cmd {
+	case "stats":
+		return reply(s.stats()), nil
+
+	case "subs":
+		return reply(s.subs()), nil
+
+	case "kick":
+		target, err := ParseAdminKey(args)
+		if err != nil {
+			return reply(err.Error()), err
+		}
+		if err = s.Kick(target); err != nil {
+			return reply(err.Error()), err
+		}
+		return reply(fmt.Sprintf("@%s kicked in chat %d", target.UserName, target.ChatID)), nil
+
+	case "broadcast":
+		text := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(update.Message.Text), strings.Join(fields[:2], " ")))
+		if text == "" {
+			err := errors.New("empty broadcast text")
+			return reply(err.Error()), err
+		}
+		return reply(fmt.Sprintf("broadcast to %d chats", s.Broadcast(text))), nil
+
+	case "invite":
+		n := 1
+		if len(args) > 0 {
+			var err error
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 1 || n > maxInvites {
+				err = errors.Errorf("please specify number of invites from 1 to %d", maxInvites)
+				return reply(err.Error()), err
+			}
+		}
+		codes, err := s.Invite(s.ctx, n)
+		if err != nil {
+			s.log(key).Error("failed to create invites", logger.Err, err)
+		}
+		if len(codes) == 0 {
+			return reply("failed to create invites"), err
+		}
+		return reply(s.inviteLinks(codes)), nil
+
+	case "help":
+		return reply(AdminHelpText), nil
+	}
+
+	return s.config.OnAdmin(update, key, cmd, args)
+}
+
+// Parses subscription key of admin command arguments <chat> <user>.
+func ParseAdminKey(args []string) (id.Key, error) {
+	const argsNum = 2
+
+	if len(args) != argsNum {
+		return id.Key{}, errors.New("please specify <chat> <user>")
+	}
+
+	chatID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		return id.Key{}, errors.Errorf("invalid chat %s", args[0])
+	}
+
+	return id.Key{
+		ChatID:   id.ChatID(chatID),
+		UserName: strings.TrimPrefix(args[1], "@"),
+	}, nil
+}
+
+// Returns members of chats sorted by chat and user name.
+func (s *Service) Subscribers() []Subscriber {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	subs := make([]Subscriber, 0, len(s.states))
+	for key, state := range s.states {
+		subs = append(subs, Subscriber{
+			Key:   key,
+			State: state,
+			URL:   s.urls[key],
+		})
+	}
+
+	sort.Slice(subs, func(i, j int) bool {
+		if subs[i].Key.ChatID != subs[j].Key.ChatID {
+			return subs[i].Key.ChatID < subs[j].Key.ChatID
+		}
+		return subs[i].Key.UserName < subs[j].Key.UserName
+	})
+
+	return subs
+}
+
+// Stops subscription of the member as if one left the chat.
+func (s *Service) Kick(key id.Key) error {
+	s.mx.RLock()
+	state, found := s.states[key]
+	s.mx.RUnlock()
+
+	if !found || state != Subscribed {
+		return errors.Errorf("@%s not subscribed in chat %d", key.UserName, key.ChatID)
+	}
+
+	_, err := s.servMemberStatusChange(nil, key)
+
+	return err
+}
+
+// Subscribes the member on the filter as if one sent /url command, access and quotas are not checked.
+func (s *Service) Subscribe(key id.Key, filter url.URL) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, err := s.config.OnSubscribe(nil, key, filter, s.config.Access.Quota, s.settings[key]); err != nil {
+		return err
+	}
+
+	w := s.wrap(key)
+	w.setState(Subscribed)
+	w.setURL(filter.String())
+
+	return nil
+}
+
+// Sends text to all chats with subscriptions, returns number of chats.
+func (s *Service) Broadcast(text string) int {
+	s.mx.RLock()
+	chatIDs := make([]id.ChatID, 0, len(s.chats))
+	for chatID := range s.chats {
+		chatIDs = append(chatIDs, chatID)
+	}
+	s.mx.RUnlock()
+
+	sort.Slice(chatIDs, func(i, j int) bool { return chatIDs[i] < chatIDs[j] })
+
+	for _, chatID := range chatIDs {
+		if err := s.SendMessage(tgbotapi.NewMessage(int64(chatID), text)); err != nil {
+			logger.FromContext(s.ctx).Error("failed to broadcast", logger.ChatID, int64(chatID), logger.Err, err)
+		}
+	}
+
+	return len(chatIDs)
+}
+
+// Returns deep links redeeming invite codes.
+func (s *Service) inviteLinks(codes []string) string {
+	var b strings.Builder
+	for _, code := range codes {
+		fmt.Fprintf(&b, "https://t.me/%s?start=%s\n", s.api.Self.UserName, code)
+	}
+
+	return b.String()
+}
+
+func (s *Service) stats() string {
+	subs := s.Subscribers()
+
+	subscribed := 0
+	chats := make(map[id.ChatID]struct{})
+	for _, sub := range subs {
+		chats[sub.Key.ChatID] = struct{}{}
+		if sub.State == Subscribed {
+			subscribed++
+		}
+	}
+
+	return fmt.Sprintf("members %d, subscribed %d, chats %d", len(subs), subscribed, len(chats))
+}
+
+func (s *Service) subs() string {
+	var b strings.Builder
+	for _, sub := range s.Subscribers() {
+		if sub.State != Subscribed {
+			continue
+		}
+		fmt.Fprintf(&b, "%d @%s %s\n", sub.Key.ChatID, sub.Key.UserName, sub.URL)
+	}
+
+	if b.Len() == 0 {
+		return "no subscriptions"
+	}
+
+	return b.String()
+}
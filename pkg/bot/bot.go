@@ -2,21 +2,28 @@ package bot
 
 import (
 	"context"
-	"log"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"krisha_kz_bot/pkg/clock"
 	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/metrics"
+	"krisha_kz_bot/pkg/tracing"
 	"krisha_kz_bot/pkg/utils"
 
 	"github.com/go-redis/redis/v9"
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var ErrNilHandler = errors.New("nil handler")
 var ErrRedisUnmarshal = errors.New("unsupported data")
+var ErrNotFound = errors.New("not found")
 
 const (
 	initStatesSize = 10
@@ -25,56 +32,83 @@ const (
 	DefaultSendMsgBufSize = 10
 	DefaultSendMsgDelay   = "5s"
 	DefaultUpdateTimeout  = 60
+	DefaultAliveInterval  = 10 * time.Second
+	DefaultHistorySize    = 10 // notifications shown by /history
+	MaxHistorySize        = 50
 )
 
 type HandlersConfig struct {
 	OnWelcome   HandlerFunc // not mandatory
 	OnStart     HandlerFunc // not mandatory
-	OnSubscribe HandlerFunc // mandatory to process subsription outside of bot service, takes *url.URL as parameter
+	OnSubscribe HandlerFunc // mandatory to process subsription outside of bot service, takes url.URL, Quota and Settings as parameters
 	OnStop      HandlerFunc // mandatory to stop subscription outsite of bot service
 	OnKicked    HandlerFunc // mandatory to stop subscription outsite of bot service
 	OnMessage   HandlerFunc // not mandatory
+	OnFavorite  HandlerFunc // not mandatory, takes ad path and true to add or false to remove as parameters
+	OnAdmin     HandlerFunc // not mandatory, serves admin commands unknown to bot, takes command and []string args
+	OnHistory   HandlerFunc // not mandatory, takes number of recent notifications as parameter
+	OnSettings  HandlerFunc // not mandatory, takes url.URL and changed Settings of the subscription as parameters
 }
 
 type Config struct {
 	Token        string
+	APIEndpoint  string // tgbotapi.APIEndpoint if empty
 	botUserName  string
 	Debug        bool
 	UpdateConfig tgbotapi.UpdateConfig
 	SendMsgBuf   int
 	SendMsgDelay time.Duration
+	Clock        clock.Clock // system clock if nil
+	Admins       []int64     // telegram user ids authorized to run /admin commands
+	Access       Access      // open to everyone if zero
 	HandlersConfig
 }
 
 type Service struct {
-	config *Config                   // service config
-	api    *tgbotapi.BotAPI          // tg bot API
-	outCh  chan<- tgbotapi.Chattable // oubound messages channel
-	ctx    context.Context           // start context
-	stop   context.CancelFunc        // stops handling of inbound updates and ounbount messages
-
-	states map[id.Key]State       // state per each member
-	urls   map[id.Key]string      // url per each subscriber
-	chats  map[id.ChatID][]id.Key // subscribers per each chat id
-	mx     sync.RWMutex           // controls boths, states and chatIDs hashes
+	config *Config            // service config
+	api    *tgbotapi.BotAPI   // tg bot API
+	outCh  chan<- outbound    // oubound messages channel
+	ctx    context.Context    // start context
+	stop   context.CancelFunc // stops handling of inbound updates and ounbount messages
+
+	states   map[id.Key]State       // state per each member
+	urls     map[id.Key]string      // url per each subscriber
+	settings map[id.Key]Settings    // scanning settings per each subscriber
+	chats    map[id.ChatID][]id.Key // subscribers per each chat id
+	granted  map[int64]struct{}     // users granted by invite codes
+	mx       sync.RWMutex           // controls boths, states and chatIDs hashes
 
 	rdb *redis.Client
 
+	aliveAt   int64 // unix nano of the last iteration of the update loop
+	sendDelay int64 // nanoseconds of SendMsgDelay, changed by SetSendDelay
+
+	stopPoll sync.Once // updates receiver can be stopped once
+
 	handleStart HandlerFunc
 	handleStop  HandlerFunc
 	handleURL   HandlerFunc
+	handleFav   HandlerFunc
+	handleHist  HandlerFunc
+	handleSet   HandlerFunc
+	handleMsg   HandlerFunc
 }
 
 // Creates bot service by given config.
 func NewServiceFromConfig(config *Config) (*Service, error) {
-	bot, err := tgbotapi.NewBotAPI(config.Token)
+	cfg := &Config{}
+	*cfg = *config
+
+	if cfg.APIEndpoint == "" {
+		cfg.APIEndpoint = tgbotapi.APIEndpoint
+	}
+	cfg.Clock = clock.OrDefault(cfg.Clock)
+
+	bot, err := tgbotapi.NewBotAPIWithAPIEndpoint(cfg.Token, cfg.APIEndpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	cfg := &Config{}
-	*cfg = *config
-
 	emptyHandler := func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
 		return nil, nil
 	}
@@ -88,13 +122,19 @@ func NewServiceFromConfig(config *Config) (*Service, error) {
 
 	panicIfNil := func(fnc HandlerFunc) {
 		if fnc == nil {
-			log.Panicln(ErrNilHandler)
+			panic(ErrNilHandler)
 		}
 	}
 
 	cfg.OnWelcome = defOr(cfg.OnWelcome, emptyHandler)
 	cfg.OnStart = defOr(cfg.OnStart, emptyHandler)
 	cfg.OnMessage = defOr(cfg.OnMessage, emptyHandler)
+	cfg.OnFavorite = defOr(cfg.OnFavorite, emptyHandler)
+	cfg.OnHistory = defOr(cfg.OnHistory, emptyHandler)
+	cfg.OnSettings = defOr(cfg.OnSettings, emptyHandler)
+	cfg.OnAdmin = defOr(cfg.OnAdmin, func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+		return tgbotapi.NewMessage(int64(key.ChatID), AdminHelpText), nil
+	})
 	panicIfNil(cfg.OnSubscribe)
 	panicIfNil(cfg.OnStop)
 	panicIfNil(cfg.OnKicked)
@@ -103,22 +143,30 @@ func NewServiceFromConfig(config *Config) (*Service, error) {
 	cfg.SendMsgDelay = utils.GraterOrEqDefOr(cfg.SendMsgDelay, utils.ParseOrPanic[time.Duration](DefaultSendMsgDelay))
 
 	s := &Service{
-		config: cfg,
-		api:    bot,
-		states: make(map[id.Key]State, initStatesSize),
-		urls:   make(map[id.Key]string, initStatesSize),
-		chats:  make(map[id.ChatID][]id.Key, initStatesSize),
+		config:   cfg,
+		api:      bot,
+		states:   make(map[id.Key]State, initStatesSize),
+		urls:     make(map[id.Key]string, initStatesSize),
+		settings: make(map[id.Key]Settings, initStatesSize),
+		chats:    make(map[id.ChatID][]id.Key, initStatesSize),
+		granted:  make(map[int64]struct{}),
+
+		sendDelay: int64(cfg.SendMsgDelay),
 	}
 	s.handleStart = withLock(s, defaultHandleStart)
 	fncStop, fncPostStop := generatorDefaultHandleStop()
 	s.handleStop = withPostWLock(s, fncStop, fncPostStop)
 	fncURL, fncPostURL := generartorDefaultHandleURL()
 	s.handleURL = withPostWLock(s, fncURL, fncPostURL)
+	s.handleFav = withLock(s, defaultHandleFavorite)
+	s.handleHist = withLock(s, defaultHandleHistory)
+	s.handleSet = withLock(s, defaultHandleSettings)
+	s.handleMsg = withAdmin(s, s.servInboundMessage)
 
 	s.api.Debug = s.config.Debug
 
 	s.config.botUserName = s.api.Self.UserName
-	log.Printf("Authorized on account %s\n", s.api.Self.UserName)
+	logger.Default().Info("authorized on account", "account", s.api.Self.UserName)
 
 	return s, s.setupBotCmd()
 }
@@ -137,7 +185,12 @@ const (
 	🕹 Commands
 	/start - start bot
 	/stop - stop notifications
-	/url <filter> - url with query parameters, except page`
+	/url <filter> - url with query parameters, except page
+	/fav <link> - notify when the ad is removed or changed
+	/unfav <link> - stop tracking the ad
+	/history [n] - recent notifications
+	/interval <duration> - scan interval, e.g. 10m
+	/pages <n> - pages of the filter to scan`
 )
 
 // Setups avvailable bot commands.
@@ -151,6 +204,10 @@ func (s *Service) setupBotCmd() error {
 			Command:     "/stop",
 			Description: "stop notifications",
 		},
+		tgbotapi.BotCommand{
+			Command:     "/history",
+			Description: "recent notifications",
+		},
 		// Commands does not support input parameters
 		// tgbotapi.BotCommand{
 		// 	Command:     "/url",
@@ -159,7 +216,7 @@ func (s *Service) setupBotCmd() error {
 	)
 
 	if _, err := s.api.Request(cfg); err != nil {
-		log.Printf("failed to setup bot commands, error %v", err)
+		logger.Default().Error("failed to setup bot commands", logger.Err, err)
 		return err
 	}
 
@@ -168,39 +225,101 @@ func (s *Service) setupBotCmd() error {
 
 // Starts serving inbound and outbound channels and blocks execution.
 func (s *Service) Start(ctx context.Context) error {
-	u := tgbotapi.NewUpdate(0)
-	u.Timeout = s.config.UpdateConfig.Timeout
-	u.Offset = s.config.UpdateConfig.Offset
-	u.Limit = s.config.UpdateConfig.Limit
+	s.Serve(ctx)
+
+	return s.Poll(ctx)
+}
 
+// Starts serving outbound messages asynchronously, inbound updates are served by Poll.
+// Use alone by an instance which does not poll updates.
+func (s *Service) Serve(ctx context.Context) {
 	s.ctx, s.stop = context.WithCancel(ctx)
 
 	// outbound messages
 	s.outCh = s.getAndServOutboundChan(s.ctx, s.config)
+}
+
+// Polls and serves inbound updates, blocks execution until context is done.
+// Serve shall be invoked first. Polling can not be resumed after it is stopped,
+// because Telegram Bot API serves one poller per token.
+func (s *Service) Poll(ctx context.Context) error {
+	u := tgbotapi.NewUpdate(0)
+	u.Timeout = s.config.UpdateConfig.Timeout
+	u.Offset = s.config.UpdateConfig.Offset
+	u.Limit = s.config.UpdateConfig.Limit
+
 	// inbound messages
 	updates := s.api.GetUpdatesChan(u)
+	defer s.stopPolling()
+
+	// update loop is alive while it is not stuck in a handler
+	s.touch()
+	aliveTimer := s.config.Clock.NewTimer(DefaultAliveInterval)
+	defer aliveTimer.Stop()
 
 	// start serv inbound channel
 	for {
 		select {
 		case <-ctx.Done():
-			log.Println("stopping accepting inbound messages")
+			logger.FromContext(ctx).Info("stopping accepting inbound messages")
 			return nil
 
-		case update := <-updates:
+		case update, ok := <-updates:
+			if !ok {
+				logger.FromContext(ctx).Info("stopped receiving updates")
+				return nil
+			}
+
 			s.servInboundUpdate(&update)
+			s.touch()
 
-		default:
-			continue
+		case <-aliveTimer.C():
+			s.touch()
+			aliveTimer.Reset(DefaultAliveInterval)
 		}
 	}
 }
 
+func tracer() trace.Tracer {
+	return tracing.Tracer("pkg/bot")
+}
+
+// Returns logger of the service context with fields of the member.
+func (s *Service) log(key id.Key) *logger.Logger {
+	return logger.FromContext(s.ctx).WithKey(key)
+}
+
+func (s *Service) touch() {
+	atomic.StoreInt64(&s.aliveAt, s.config.Clock.Now().UnixNano())
+}
+
+// Returns time of the last iteration of the update loop, zero if not started.
+func (s *Service) AliveAt() time.Time {
+	if nsec := atomic.LoadInt64(&s.aliveAt); nsec != 0 {
+		return time.Unix(0, nsec)
+	}
+
+	return time.Time{}
+}
+
+// Stops polling of updates once.
+func (s *Service) stopPolling() {
+	s.stopPoll.Do(s.api.StopReceivingUpdates)
+}
+
+// Checks that Telegram Bot API is reachable with the token.
+func (s *Service) Ping() error {
+	_, err := s.api.GetMe()
+
+	return err
+}
+
 // Stops serving inbound and outbound channels.
 // Use for graceful shutdown.
 func (s *Service) Shutdown() error {
-	log.Println("shutting down bot service")
+	logger.FromContext(s.ctx).Info("shutting down bot service")
 	// _, done := context.WithCancel(ctx)
+	s.stopPolling()
 	s.stop()
 	// done()
 	return nil
@@ -227,7 +346,7 @@ func (s *Service) servInboundUpdate(update *tgbotapi.Update) {
 				ChatID:   id.ChatID(update.Message.Chat.ID),
 				UserName: update.Message.From.UserName,
 			}
-			resp, err := s.servInboundMessage(update, key)
+			resp, err := s.handleMsg(update, key)
 
 			return key, resp, err
 
@@ -243,11 +362,16 @@ func (s *Service) servInboundUpdate(update *tgbotapi.Update) {
 			return key, resp, err
 		}
 
-		// unhandled message
-		return id.Key{
-			ChatID:   id.ChatID(update.Message.Chat.ID),
-			UserName: update.Message.From.UserName,
-		}, nil, nil
+		// unhandled update, e.g. callback query
+		var key id.Key
+		if chat := update.FromChat(); chat != nil {
+			key.ChatID = id.ChatID(chat.ID)
+		}
+		if from := update.SentFrom(); from != nil {
+			key.UserName = from.UserName
+		}
+
+		return key, nil, nil
 	}()
 
 	if resp == nil && err != nil {
@@ -256,7 +380,7 @@ func (s *Service) servInboundUpdate(update *tgbotapi.Update) {
 
 	if resp != nil {
 		if e := s.SendMessage(resp); e != nil {
-			log.Printf("bot failed to send message, error %v\n", e)
+			s.log(key).Error("failed to send message", logger.Err, e)
 		}
 	}
 }
@@ -268,15 +392,22 @@ func (s *Service) servInboundMessage(update *tgbotapi.Update, key id.Key) (tgbot
 		err  error
 	)
 	switch update.Message.Text {
-	case "/start", "/start@" + s.api.Self.UserName:
-		resp, err = s.handleStart(update, key)
-
 	case "/stop", "/stop@" + s.api.Self.UserName:
 		resp, err = s.handleStop(update, key)
 	default:
-		if strings.HasPrefix(update.Message.Text, "/url") {
+		switch {
+		case isCommand(update.Message.Text, "/start", s.api.Self.UserName):
+			resp, err = s.handleStart(update, key)
+		case strings.HasPrefix(update.Message.Text, "/url"):
 			resp, err = s.handleURL(update, key)
-		} else {
+		case strings.HasPrefix(update.Message.Text, "/fav"), strings.HasPrefix(update.Message.Text, "/unfav"):
+			resp, err = s.handleFav(update, key)
+		case isCommand(update.Message.Text, "/history", s.api.Self.UserName):
+			resp, err = s.handleHist(update, key)
+		case isCommand(update.Message.Text, "/interval", s.api.Self.UserName),
+			isCommand(update.Message.Text, "/pages", s.api.Self.UserName):
+			resp, err = s.handleSet(update, key)
+		default:
 			resp, err = s.config.OnMessage(update, key)
 		}
 	}
@@ -284,12 +415,19 @@ func (s *Service) servInboundMessage(update *tgbotapi.Update, key id.Key) (tgbot
 	return resp, err
 }
 
+// Returns true if the text is the command with or without bot name and optional arguments.
+func isCommand(text, cmd, botUserName string) bool {
+	fields := strings.Fields(text)
+
+	return len(fields) > 0 && (fields[0] == cmd || fields[0] == cmd+"@"+botUserName)
+}
+
 // Serves bot status change.
 func (s *Service) servBotStatusChange(update *tgbotapi.Update, key id.Key) (tgbotapi.Chattable, error) {
 	memberUserName := update.MyChatMember.NewChatMember.User.UserName
 	memberStatus := update.MyChatMember.NewChatMember.Status
 
-	log.Printf("Chat member %s changed status to %s\n", memberUserName, memberStatus)
+	s.log(key).Info("chat member changed status", "member", memberUserName, "status", memberStatus)
 
 	var (
 		resp tgbotapi.Chattable
@@ -362,27 +500,37 @@ func (s *Service) servMemberStatusChange(update *tgbotapi.Update, key id.Key) (t
 	return resp, err
 }
 
+// Callback on result of sending the message.
+type SentFunc func(msg tgbotapi.Message, err error)
+
+// Outbound message with context of the sender.
+type outbound struct {
+	ctx    context.Context
+	msg    tgbotapi.Chattable
+	queued time.Time
+	onSent SentFunc // optional
+}
+
 // Returns channel for outbound messages.
-func (s *Service) getAndServOutboundChan(ctx context.Context, cfg *Config) chan<- tgbotapi.Chattable {
-	ch := make(chan tgbotapi.Chattable, cfg.SendMsgBuf)
+func (s *Service) getAndServOutboundChan(ctx context.Context, cfg *Config) chan<- outbound {
+	ch := make(chan outbound, cfg.SendMsgBuf)
 
 	// serv outbound channel
-	go func(ch chan tgbotapi.Chattable, api *tgbotapi.BotAPI) {
+	go func(ch chan outbound, api *tgbotapi.BotAPI) {
 		defer close(ch)
 
 		for {
 			select {
 			case <-ctx.Done():
-				log.Printf("stopping accepting outbound messages\n")
+				logger.FromContext(ctx).Info("stopping accepting outbound messages")
 				return
 
-			case msg := <-ch:
-				if _, err := api.Send(msg); err != nil {
-					log.Printf("failed to send message, error %v\n", err)
-				}
+			case out := <-ch:
+				metrics.OutboundQueue.Set(float64(len(ch)))
+				s.send(api, out)
 
 			default:
-				time.Sleep(cfg.SendMsgDelay)
+				_ = clock.Sleep(ctx, cfg.Clock, s.getSendDelay())
 			}
 		}
 	}(ch, s.api)
@@ -390,10 +538,57 @@ func (s *Service) getAndServOutboundChan(ctx context.Context, cfg *Config) chan<
 	return ch
 }
 
+// Changes delay of polling outbound queue, not less than DefaultSendMsgDelay.
+func (s *Service) SetSendDelay(delay time.Duration) {
+	delay = utils.GraterOrEqDefOr(delay, utils.ParseOrPanic[time.Duration](DefaultSendMsgDelay))
+	atomic.StoreInt64(&s.sendDelay, int64(delay))
+	logger.FromContext(s.ctx).Info("changed send delay", "delay", delay)
+}
+
+func (s *Service) getSendDelay() time.Duration {
+	return time.Duration(atomic.LoadInt64(&s.sendDelay))
+}
+
+// Sends the queued message, the span continues trace of the sender.
+func (s *Service) send(api *tgbotapi.BotAPI, out outbound) {
+	_, span := tracer().Start(out.ctx, "bot.send", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(
+		attribute.Int64("queue.wait_ms", clock.Since(s.config.Clock, out.queued).Milliseconds()),
+	))
+	defer span.End()
+
+	if c, ok := out.msg.(tgbotapi.MessageConfig); ok {
+		span.SetAttributes(tracing.ChatID.Int64(c.ChatID))
+	}
+
+	msg, err := api.Send(out.msg)
+	if out.onSent != nil {
+		out.onSent(msg, err)
+	}
+	if err != nil {
+		tracing.Fail(span, err)
+		metrics.Notifications.WithLabelValues(metrics.Failed).Inc()
+		logger.FromContext(out.ctx).Error("failed to send message", logger.Err, err)
+		return
+	}
+
+	span.SetAttributes(attribute.Int("telegram.message_id", msg.MessageID))
+	metrics.Notifications.WithLabelValues(metrics.Sent).Inc()
+}
+
 // Sends a message through a channel.
 func (s *Service) SendMessage(c tgbotapi.Chattable) error {
-	// _, err = s.api.Send(c)
-	s.outCh <- c
+	return s.SendMessageContext(context.Background(), c)
+}
+
+// Sends a message through a channel, the send is traced in the given context.
+func (s *Service) SendMessageContext(ctx context.Context, c tgbotapi.Chattable) error {
+	return s.SendMessageFunc(ctx, c, nil)
+}
+
+// Sends a message through a channel, onSent is invoked with result of the send by the sending goroutine.
+func (s *Service) SendMessageFunc(ctx context.Context, c tgbotapi.Chattable, onSent SentFunc) error {
+	s.outCh <- outbound{ctx: ctx, msg: c, queued: s.config.Clock.Now(), onSent: onSent}
+	metrics.OutboundQueue.Set(float64(len(s.outCh)))
 	return nil
 }
 
@@ -406,7 +601,7 @@ func (s *Service) Clean() {
 	// cleansing of bot cache
 	for key, state := range s.states {
 		if state == Default {
-			log.Printf("Cleansing bot cache: %v\n", key)
+			s.log(key).Debug("cleansing bot cache")
 
 			// delete from subscribers hash by key
 			s.wrap(key).delSub()
@@ -0,0 +1,573 @@
+package bot_test
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/bot"
+	"krisha_kz_bot/pkg/bot/fakeapi"
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/tracing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	testTimeout = 5 * time.Second
+	testURL     = "https://krisha.kz/arenda/kvartiry/almaty/?das[live.rooms]=2"
+	testAdmin   = "root"
+)
+
+type testBot struct {
+	api   *fakeapi.Server
+	clock *clock.Fake
+	serv  *bot.Service
+	rdb   *redis.Client
+	calls chan string // invoked handlers, e.g. subscribe @user
+	seen  int         // number of sent messages already expected
+	stop  context.CancelFunc
+}
+
+// Starts bot service against fake api and the given redis, options adjust the config.
+func newTestBot(t *testing.T, mr *miniredis.Miniredis, opts ...func(cfg *bot.Config)) *testBot {
+	t.Helper()
+
+	tb := &testBot{
+		api:   fakeapi.New(""),
+		clock: clock.NewFake(time.Now()),
+		rdb:   redis.NewClient(&redis.Options{Addr: mr.Addr()}),
+		calls: make(chan string, 10),
+	}
+
+	reply := func(key id.Key, text string) tgbotapi.Chattable {
+		return tgbotapi.NewMessage(int64(key.ChatID), text)
+	}
+
+	cfg := &bot.Config{
+		Token:        "test",
+		APIEndpoint:  tb.api.Endpoint(),
+		UpdateConfig: tgbotapi.UpdateConfig{Timeout: bot.DefaultUpdateTimeout},
+		Clock:        tb.clock,
+		Admins:       []int64{fakeapi.User(testAdmin).ID},
+		HandlersConfig: bot.HandlersConfig{
+			OnSubscribe: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				filter, _ := params[0].(url.URL)
+				tb.calls <- fmt.Sprintf("subscribe @%s %s", key.UserName, filter.String())
+				return reply(key, "subscribed"), nil
+			},
+			OnStop: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				tb.calls <- "stop @" + key.UserName
+				return reply(key, "stopped"), nil
+			},
+			OnAdmin: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				args, _ := params[1].([]string)
+				tb.calls <- fmt.Sprintf("admin %s %v", params[0], args)
+				return reply(key, "done"), nil
+			},
+			OnKicked: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				tb.calls <- "kicked @" + key.UserName
+				return nil, nil
+			},
+			OnHistory: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				tb.calls <- fmt.Sprintf("history @%s %v", key.UserName, params[0])
+				return reply(key, "history"), nil
+			},
+			OnSettings: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				settings, _ := params[1].(bot.Settings)
+				tb.calls <- fmt.Sprintf("settings @%s %s %d", key.UserName, settings.Interval, settings.Pages)
+				return reply(key, "settings"), nil
+			},
+		},
+	}
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	serv, err := bot.NewServiceFromConfig(cfg)
+	if err != nil {
+		t.Fatalf("failed to create bot service, got error %v", err)
+	}
+	tb.serv = serv.WithRedis(tb.rdb)
+
+	t.Cleanup(func() {
+		tb.api.Close()
+		tb.rdb.Close()
+	})
+
+	return tb
+}
+
+// Loads state from redis and starts serving updates.
+func (tb *testBot) start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	tb.stop = cancel
+
+	tb.serv.LoadFromRedis(ctx)
+	go func() {
+		_ = tb.serv.Start(ctx)
+	}()
+}
+
+func (tb *testBot) shutdown() {
+	_ = tb.serv.Shutdown()
+	tb.stop()
+}
+
+// Waits for the next message sent by the bot, flushes outbound queue by the fake clock.
+func (tb *testBot) expectSent(t *testing.T, chatID int64, text string) {
+	t.Helper()
+
+	want := tb.seen
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		tb.clock.Advance(time.Minute)
+
+		if sent := tb.api.Sent(); len(sent) > want {
+			if msg := sent[want]; msg.ChatID != chatID || !strings.Contains(msg.Text, text) {
+				t.Fatalf("want message %q to chat %d, got %q to chat %d", text, chatID, msg.Text, msg.ChatID)
+			}
+			tb.seen++
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("want message %q to chat %d, got nothing", text, chatID)
+}
+
+// Waits for the handler call.
+func (tb *testBot) expectCall(t *testing.T, want string) {
+	t.Helper()
+
+	select {
+	case got := <-tb.calls:
+		if got != want {
+			t.Fatalf("want call %q, got %q", want, got)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("want call %q, got nothing", want)
+	}
+}
+
+// Returns number of stored bot states.
+func (tb *testBot) storedStates(t *testing.T) int {
+	t.Helper()
+
+	keys, err := tb.rdb.Keys(context.Background(), "bot;*").Result()
+	if err != nil {
+		t.Fatalf("failed to get keys, got error %v", err)
+	}
+
+	return len(keys)
+}
+
+// Subscribes the user in the chat.
+func (tb *testBot) subscribe(t *testing.T, chatID int64, userName string) {
+	t.Helper()
+
+	tb.api.PushUpdate(fakeapi.Message(chatID, userName, "/url "+testURL))
+	tb.expectCall(t, fmt.Sprintf("subscribe @%s %s", userName, testURL))
+	tb.expectSent(t, chatID, "subscribed")
+}
+
+func TestStartURLStop(t *testing.T) {
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	if tb.api.Calls("setMyCommands") != 1 {
+		t.Errorf("want bot commands set up")
+	}
+	if err := tb.serv.Ping(); err != nil {
+		t.Errorf("want telegram reachable, got error %v", err)
+	}
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/start"))
+	tb.expectSent(t, 1, "Greeting")
+	if tb.serv.AliveAt().IsZero() {
+		t.Errorf("want update loop alive")
+	}
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/url https://example.com/"))
+	tb.expectSent(t, 1, "Please enter a filter from krisha.kz")
+
+	tb.subscribe(t, 1, "alice")
+	if got := tb.storedStates(t); got != 1 {
+		t.Errorf("want subscription stored, got %d states", got)
+	}
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/start"))
+	tb.expectSent(t, 1, "already registered")
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/stop"))
+	tb.expectCall(t, "stop @alice")
+	tb.expectSent(t, 1, "stopped")
+
+	if got := tb.storedStates(t); got != 0 {
+		t.Errorf("want subscription removed, got %d states", got)
+	}
+}
+
+func TestShutdown(t *testing.T) {
+	tb := newTestBot(t, miniredis.RunT(t))
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tb.serv.Start(context.Background())
+	}()
+
+	// polling is started
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/start"))
+	tb.expectSent(t, 1, "Greeting")
+
+	if err := tb.serv.Shutdown(); err != nil {
+		t.Fatalf("failed to shut down, got error %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("want polling stopped, got error %v", err)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("want polling stopped on shutdown")
+	}
+}
+
+func TestUnhandledUpdate(t *testing.T) {
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	// update without a message is skipped
+	tb.api.PushUpdate(fakeapi.CallbackQuery(1, "alice", "noop"))
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/start"))
+	tb.expectSent(t, 1, "Greeting")
+}
+
+func TestKicked(t *testing.T) {
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	tb.subscribe(t, 1, "alice")
+
+	tb.api.PushUpdate(fakeapi.BotStatus(tb.api.Bot(), 1, "alice", "kicked"))
+	tb.expectCall(t, "kicked @alice")
+
+	if got := tb.storedStates(t); got != 0 {
+		t.Errorf("want subscription removed, got %d states", got)
+	}
+
+	// unblocked bot asks to subscribe again
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/start"))
+	tb.expectSent(t, 1, "Please send a /url command")
+}
+
+func TestGroupLeave(t *testing.T) {
+	const group = -100
+
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	tb.api.PushUpdate(fakeapi.BotStatus(tb.api.Bot(), group, "alice", "member"))
+	tb.expectSent(t, group, "Greeting")
+
+	tb.subscribe(t, group, "alice")
+	tb.subscribe(t, group, "bob")
+
+	// member left the group
+	tb.api.PushUpdate(fakeapi.LeftMember(group, "bob"))
+	tb.expectCall(t, "kicked @bob")
+
+	// bot removed from the group unsubscribes the rest
+	tb.api.PushUpdate(fakeapi.BotStatus(tb.api.Bot(), group, "alice", "left"))
+	tb.expectCall(t, "kicked @alice")
+
+	// wait for the update served by the next one
+	tb.api.PushUpdate(fakeapi.Message(1, "carol", "/start"))
+	tb.expectSent(t, 1, "Greeting")
+
+	if got := tb.storedStates(t); got != 0 {
+		t.Errorf("want subscriptions of the group removed, got %d states", got)
+	}
+}
+
+func TestRestartReload(t *testing.T) {
+	mr := miniredis.RunT(t)
+
+	tb1 := newTestBot(t, mr)
+	tb1.start()
+	tb1.subscribe(t, 1, "alice")
+	tb1.shutdown()
+
+	// subscriptions are restored on start
+	tb2 := newTestBot(t, mr)
+	tb2.start()
+	defer tb2.shutdown()
+
+	tb2.expectCall(t, "subscribe @alice "+testURL)
+
+	tb2.api.PushUpdate(fakeapi.Message(1, "alice", "/start"))
+	tb2.expectSent(t, 1, "already registered")
+}
+
+func TestAdmin(t *testing.T) {
+	const group = -100
+
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	tb.subscribe(t, 1, "alice")
+	tb.subscribe(t, group, "bob")
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/admin stats"))
+	tb.expectSent(t, 1, "not authorized")
+
+	tb.api.PushUpdate(fakeapi.Message(2, testAdmin, "/admin stats"))
+	tb.expectSent(t, 2, "members 2, subscribed 2, chats 2")
+
+	tb.api.PushUpdate(fakeapi.Message(2, testAdmin, "/admin subs"))
+	tb.expectSent(t, 2, fmt.Sprintf("%d @bob %s\n1 @alice %s", group, testURL, testURL))
+
+	tb.api.PushUpdate(fakeapi.Message(2, testAdmin, "/admin broadcast maintenance at 10pm"))
+	tb.expectSent(t, group, "maintenance at 10pm")
+	tb.expectSent(t, 1, "maintenance at 10pm")
+	tb.expectSent(t, 2, "broadcast to 2 chats")
+
+	// commands unknown to bot are served by handler
+	tb.api.PushUpdate(fakeapi.Message(2, testAdmin, "/admin crawl-now 1 @alice"))
+	tb.expectCall(t, "admin crawl-now [1 @alice]")
+	tb.expectSent(t, 2, "done")
+
+	tb.api.PushUpdate(fakeapi.Message(2, testAdmin, fmt.Sprintf("/admin kick %d @bob", group)))
+	tb.expectCall(t, "kicked @bob")
+	tb.expectSent(t, 2, "@bob kicked")
+
+	tb.api.PushUpdate(fakeapi.Message(2, testAdmin, fmt.Sprintf("/admin kick %d @bob", group)))
+	tb.expectSent(t, 2, "not subscribed")
+}
+
+func TestAccess(t *testing.T) {
+	mr := miniredis.RunT(t)
+	withInvites := func(cfg *bot.Config) {
+		cfg.Access = bot.Access{
+			Invites: true,
+			Quota:   bot.Quota{MaxSubscriptions: 1},
+		}
+	}
+
+	tb1 := newTestBot(t, mr, withInvites)
+	tb1.start()
+
+	tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/start"))
+	tb1.expectSent(t, 1, "access denied")
+	tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/url "+testURL))
+	tb1.expectSent(t, 1, "access denied")
+
+	tb1.api.PushUpdate(fakeapi.Message(2, testAdmin, "/admin invite"))
+	tb1.expectSent(t, 2, "?start=")
+	link := tb1.api.Sent()[tb1.seen-1].Text
+	code := strings.TrimSpace(link[strings.Index(link, "=")+1:])
+
+	tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/start unknown"))
+	tb1.expectSent(t, 1, "invite code is invalid")
+
+	tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/start "+code))
+	tb1.expectSent(t, 1, "Greeting")
+
+	// invite code is redeemed once
+	tb1.api.PushUpdate(fakeapi.Message(3, "bob", "/start "+code))
+	tb1.expectSent(t, 3, "invite code is invalid")
+
+	tb1.subscribe(t, 1, "alice")
+	tb1.api.PushUpdate(fakeapi.Message(-100, "alice", "/url "+testURL))
+	tb1.expectSent(t, -100, "quota exceeded")
+
+	// subscription of the same chat is replaced
+	tb1.subscribe(t, 1, "alice")
+	tb1.shutdown()
+
+	// granted access is restored on start
+	tb2 := newTestBot(t, mr, withInvites)
+	tb2.start()
+	defer tb2.shutdown()
+
+	tb2.expectCall(t, "subscribe @alice "+testURL)
+
+	tb2.api.PushUpdate(fakeapi.Message(4, "alice", "/start"))
+	tb2.expectSent(t, 4, "Greeting")
+}
+
+func TestSendTraced(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(tracing.NewProvider(tracing.Config{}, sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	// outbound queue is served once update loop started
+	for tb.serv.AliveAt().IsZero() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	ctx, parent := otel.Tracer("test").Start(context.Background(), "scanner.visited")
+	if err := tb.serv.SendMessageContext(ctx, tgbotapi.NewMessage(1, "new ad")); err != nil {
+		t.Fatal(err)
+	}
+	parent.End()
+	tb.expectSent(t, 1, "new ad")
+
+	deadline := time.Now().Add(testTimeout)
+	for time.Now().Before(deadline) {
+		for _, span := range exporter.GetSpans() {
+			if span.Name != "bot.send" {
+				continue
+			}
+
+			if span.Parent.SpanID() != parent.SpanContext().SpanID() || span.SpanContext.TraceID() != parent.SpanContext().TraceID() {
+				t.Errorf("want send traced in the sender span, got parent %s", span.Parent.SpanID())
+			}
+
+			attrs := attribute.NewSet(span.Attributes...)
+			if v, _ := attrs.Value(tracing.ChatID); v.AsInt64() != 1 {
+				t.Errorf("want chat id attribute, got %v", span.Attributes)
+			}
+			if _, found := attrs.Value("telegram.message_id"); !found {
+				t.Errorf("want message id attribute, got %v", span.Attributes)
+			}
+			return
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	t.Fatalf("want bot.send span, got %v", exporter.GetSpans())
+}
+
+func TestHistory(t *testing.T) {
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/history"))
+	tb.expectCall(t, fmt.Sprintf("history @alice %d", bot.DefaultHistorySize))
+	tb.expectSent(t, 1, "history")
+
+	tb.api.PushUpdate(fakeapi.Message(1, "alice", "/history 3"))
+	tb.expectCall(t, "history @alice 3")
+	tb.expectSent(t, 1, "history")
+
+	for _, n := range []string{"0", "many", fmt.Sprint(bot.MaxHistorySize + 1)} {
+		tb.api.PushUpdate(fakeapi.Message(1, "alice", "/history "+n))
+		tb.expectSent(t, 1, "Please send a number of notifications")
+	}
+}
+
+func TestSettings(t *testing.T) {
+	mr := miniredis.RunT(t)
+	withQuota := func(cfg *bot.Config) {
+		cfg.Access.Quota = bot.Quota{MinInterval: 5 * time.Minute, MaxInterval: time.Hour, MaxPages: 2}
+	}
+
+	tb1 := newTestBot(t, mr, withQuota)
+	tb1.start()
+
+	tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/interval 10m"))
+	tb1.expectSent(t, 1, "Please subscribe with /url command first")
+
+	tb1.subscribe(t, 1, "alice")
+
+	for _, arg := range []string{"", "1m", "2h", "often"} {
+		tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/interval "+arg))
+		tb1.expectSent(t, 1, "Please send a scan interval from 5m0s to 1h0m0s")
+	}
+	for _, arg := range []string{"0", "3"} {
+		tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/pages "+arg))
+		tb1.expectSent(t, 1, "Please send a number of pages from 1 to 2")
+	}
+
+	tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/interval 10m"))
+	tb1.expectCall(t, "settings @alice 10m0s 0")
+	tb1.expectSent(t, 1, "settings")
+
+	tb1.api.PushUpdate(fakeapi.Message(1, "alice", "/pages 2"))
+	tb1.expectCall(t, "settings @alice 10m0s 2")
+	tb1.expectSent(t, 1, "settings")
+	tb1.shutdown()
+
+	// settings are restored with subscription
+	tb2 := newTestBot(t, mr, withQuota, func(cfg *bot.Config) {
+		onSubscribe := cfg.OnSubscribe
+		cfg.OnSubscribe = func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+			settings, _ := params[2].(bot.Settings)
+			if settings.Interval != 10*time.Minute || settings.Pages != 2 {
+				t.Errorf("want settings restored, got %+v", settings)
+			}
+			return onSubscribe(update, key, params...)
+		}
+	})
+	tb2.start()
+
+	tb2.expectCall(t, "subscribe @alice "+testURL)
+
+	// settings are removed with subscription
+	tb2.api.PushUpdate(fakeapi.Message(1, "alice", "/stop"))
+	tb2.expectCall(t, "stop @alice")
+	tb2.expectSent(t, 1, "stopped")
+	tb2.shutdown()
+
+	if got := tb2.storedStates(t); got != 0 {
+		t.Errorf("want settings removed, got %d states", got)
+	}
+}
+
+func TestSendFunc(t *testing.T) {
+	tb := newTestBot(t, miniredis.RunT(t))
+	tb.start()
+	defer tb.shutdown()
+
+	// outbound queue is served once update loop started
+	for tb.serv.AliveAt().IsZero() {
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	sent := make(chan tgbotapi.Message, 1)
+	err := tb.serv.SendMessageFunc(context.Background(), tgbotapi.NewMessage(1, "new ad"), func(msg tgbotapi.Message, err error) {
+		if err != nil {
+			t.Errorf("want message sent, got error %v", err)
+		}
+		sent <- msg
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tb.expectSent(t, 1, "new ad")
+
+	select {
+	case msg := <-sent:
+		if msg.MessageID == 0 || msg.Chat.ID != 1 {
+			t.Errorf("want sent message with id, got %+v", msg)
+		}
+	case <-time.After(testTimeout):
+		t.Fatal("want callback on sent message")
+	}
+}
@@ -3,8 +3,9 @@ package bot
 import (
 	"fmt"
 	"krisha_kz_bot/pkg/id"
-	"log"
+	"krisha_kz_bot/pkg/logger"
 	"net/url"
+	"strconv"
 	"strings"
 
 	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
@@ -23,6 +24,18 @@ type (
 
 // Default handler on /start command.
 func defaultHandleStart(update *tgbotapi.Update, key id.Key, s stater, cfg *Config) (tgbotapi.Chattable, error) {
+	// redeem invite code of deep link /start <code>
+	if fields := strings.Fields(update.Message.Text); len(fields) > 1 && update.Message.From != nil &&
+		!s.isAllowed(update.Message.From.ID) && !s.redeem(update.Message.From.ID, fields[1]) {
+		text := fmt.Sprintf("@%s, invite code is invalid or has been already used", key.UserName)
+
+		return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+	}
+
+	if resp, err := checkAccess(update, key, s); err != nil {
+		return resp, err
+	}
+
 	state, found := s.getState()
 
 	switch {
@@ -84,9 +97,16 @@ func generartorDefaultHandleURL() (
 			state = Default
 		}
 
+		if resp, err := checkAccess(update, key, s); err != nil {
+			return resp, err
+		}
+		if resp, err := checkQuota(key, s, cfg); err != nil {
+			return resp, err
+		}
+
 		if url, resp, err := parseURL(command, key); err != nil {
 			return resp, err
-		} else if resp, err = cfg.OnSubscribe(update, key, *url); err != nil {
+		} else if resp, err = cfg.OnSubscribe(update, key, *url, cfg.Access.Quota, s.getSettings()); err != nil {
 			return resp, err
 		} else {
 			state = Subscribed
@@ -104,6 +124,58 @@ func generartorDefaultHandleURL() (
 	return fncR, fncWPost
 }
 
+// Default handler on /fav and /unfav commands.
+func defaultHandleFavorite(update *tgbotapi.Update, key id.Key, s stater, cfg *Config) (tgbotapi.Chattable, error) {
+	if state, found := s.getState(); !found || state != Subscribed {
+		text := fmt.Sprintf("@%s, Please subscribe with /url command first", key.UserName)
+		return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+	}
+
+	fields := strings.Fields(update.Message.Text)
+	add := !strings.HasPrefix(fields[0], "/unfav")
+
+	if len(fields) < 2 {
+		text := fmt.Sprintf("@%s, Please send a link of krisha.kz ad", key.UserName)
+		return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+	}
+
+	href, resp, err := parseAdLink(fields[1], key)
+	if err != nil {
+		return resp, err
+	}
+
+	return cfg.OnFavorite(update, key, href, add)
+}
+
+// Default handler on /history [n] command.
+func defaultHandleHistory(update *tgbotapi.Update, key id.Key, s stater, cfg *Config) (tgbotapi.Chattable, error) {
+	if resp, err := checkAccess(update, key, s); err != nil {
+		return resp, err
+	}
+
+	n := DefaultHistorySize
+	if fields := strings.Fields(update.Message.Text); len(fields) > 1 {
+		var err error
+		if n, err = strconv.Atoi(fields[1]); err != nil || n < 1 || n > MaxHistorySize {
+			text := fmt.Sprintf("@%s, Please send a number of notifications from 1 to %d", key.UserName, MaxHistorySize)
+			return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+		}
+	}
+
+	return cfg.OnHistory(update, key, n)
+}
+
+// Parses link of the ad and returns its path.
+func parseAdLink(link string, key id.Key) (string, tgbotapi.Chattable, error) {
+	uri, err := url.Parse(link)
+	if err != nil || (uri.Hostname() != "" && uri.Hostname() != "krisha.kz") || !strings.HasPrefix(uri.Path, "/a/show/") {
+		text := fmt.Sprintf("@%s, Please enter a link of krisha.kz ad", key.UserName)
+		return "", tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+	}
+
+	return uri.Path, nil, nil
+}
+
 // Parses and validates given url value.
 func parseURL(command string, key id.Key) (*url.URL, tgbotapi.Chattable, error) {
 	uri, err := url.ParseRequestURI(command)
@@ -116,7 +188,7 @@ func parseURL(command string, key id.Key) (*url.URL, tgbotapi.Chattable, error)
 		return uri, tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
 	}
 
-	log.Printf("@%s requested scan of %s\n", key.UserName, uri.String())
+	logger.Default().WithKey(key).Info("requested scan", logger.URL, uri)
 
 	return uri, nil, nil
 }
@@ -0,0 +1,226 @@
+package fakeapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"krisha_kz_bot/pkg/logger"
+
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultBotUserName = "krisha_kz_test_bot"
+	DefaultBotID       = 1000
+
+	MaxPollTimeout = 100 * time.Millisecond // long polling of getUpdates is limited to keep tests fast
+)
+
+// Message sent by a bot.
+type Sent struct {
+	Method string // sendMessage or sendPhoto
+	ChatID int64
+	Text   string // text of a message or caption of a photo
+	Photo  string // url or file id of a photo
+}
+
+// In-process fake of Telegram Bot API recording sent messages and serving injected updates.
+type Server struct {
+	srv *httptest.Server
+	bot tgbotapi.User
+
+	updates      []tgbotapi.Update
+	nextUpdateID int
+	nextMsgID    int
+	sent         []Sent
+	calls        map[string]int
+	notify       chan struct{} // closed and replaced on each new update
+	mx           sync.Mutex
+}
+
+// Starts fake api of the bot with the given user name, DefaultBotUserName if empty.
+func New(botUserName string) *Server {
+	if botUserName == "" {
+		botUserName = DefaultBotUserName
+	}
+
+	s := &Server{
+		bot: tgbotapi.User{
+			ID:        DefaultBotID,
+			IsBot:     true,
+			FirstName: botUserName,
+			UserName:  botUserName,
+		},
+		nextUpdateID: 1,
+		nextMsgID:    1,
+		calls:        make(map[string]int),
+		notify:       make(chan struct{}),
+	}
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serve))
+
+	return s
+}
+
+// Returns endpoint for tgbotapi.NewBotAPIWithAPIEndpoint.
+func (s *Server) Endpoint() string {
+	return s.srv.URL + "/bot%s/%s"
+}
+
+// Returns the bot user.
+func (s *Server) Bot() tgbotapi.User {
+	return s.bot
+}
+
+// Stops the server.
+func (s *Server) Close() {
+	s.srv.Close()
+}
+
+// Queues the update for getUpdates, assigns update id.
+func (s *Server) PushUpdate(update tgbotapi.Update) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	update.UpdateID = s.nextUpdateID
+	s.nextUpdateID++
+	s.updates = append(s.updates, update)
+
+	close(s.notify)
+	s.notify = make(chan struct{})
+}
+
+// Returns messages sent by the bot.
+func (s *Server) Sent() []Sent {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	sent := make([]Sent, len(s.sent))
+	copy(sent, s.sent)
+
+	return sent
+}
+
+// Returns number of calls of the api method.
+func (s *Server) Calls(method string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.calls[method]
+}
+
+func (s *Server) serve(w http.ResponseWriter, r *http.Request) {
+	// path is /bot<token>/<method>
+	method := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil && err != http.ErrNotMultipart {
+		reply(w, nil, err)
+		return
+	}
+
+	s.mx.Lock()
+	s.calls[method]++
+	s.mx.Unlock()
+
+	switch method {
+	case "getMe":
+		reply(w, s.bot, nil)
+	case "getUpdates":
+		reply(w, s.getUpdates(r), nil)
+	case "sendMessage":
+		reply(w, s.record(method, r, r.FormValue("text")), nil)
+	case "sendPhoto":
+		reply(w, s.record(method, r, r.FormValue("caption")), nil)
+	case "setMyCommands", "answerCallbackQuery", "deleteWebhook":
+		reply(w, true, nil)
+	default:
+		reply(w, nil, errors.Errorf("unsupported method %s", method))
+	}
+}
+
+// Returns updates starting from offset, waits for an update up to the poll timeout.
+func (s *Server) getUpdates(r *http.Request) []tgbotapi.Update {
+	offset, _ := strconv.Atoi(r.FormValue("offset"))
+	timeout, _ := strconv.Atoi(r.FormValue("timeout"))
+
+	wait := time.Duration(timeout) * time.Second
+	if wait > MaxPollTimeout {
+		wait = MaxPollTimeout
+	}
+	deadline := time.NewTimer(wait)
+	defer deadline.Stop()
+
+	for {
+		s.mx.Lock()
+		var updates []tgbotapi.Update
+		for _, update := range s.updates {
+			if update.UpdateID >= offset {
+				updates = append(updates, update)
+			}
+		}
+		notify := s.notify
+		s.mx.Unlock()
+
+		if len(updates) > 0 {
+			return updates
+		}
+
+		select {
+		case <-notify:
+		case <-deadline.C:
+			return []tgbotapi.Update{}
+		case <-r.Context().Done():
+			return []tgbotapi.Update{}
+		}
+	}
+}
+
+// Records sent message and returns it as the api does.
+func (s *Server) record(method string, r *http.Request, text string) tgbotapi.Message {
+	chatID, _ := strconv.ParseInt(r.FormValue("chat_id"), 10, 64)
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.sent = append(s.sent, Sent{
+		Method: method,
+		ChatID: chatID,
+		Text:   text,
+		Photo:  r.FormValue("photo"),
+	})
+
+	msg := tgbotapi.Message{
+		MessageID: s.nextMsgID,
+		From:      &s.bot,
+		Date:      int(time.Now().Unix()),
+		Chat:      &tgbotapi.Chat{ID: chatID},
+		Text:      text,
+	}
+	s.nextMsgID++
+
+	return msg
+}
+
+// Writes api response with the result or error.
+func reply(w http.ResponseWriter, result any, err error) {
+	resp := tgbotapi.APIResponse{Ok: err == nil}
+	if err != nil {
+		resp.ErrorCode = http.StatusBadRequest
+		resp.Description = err.Error()
+	} else if raw, e := json.Marshal(result); e == nil {
+		resp.Result = raw
+	} else {
+		resp.Ok = false
+		resp.Description = e.Error()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if e := json.NewEncoder(w).Encode(resp); e != nil {
+		logger.Default().Error("fake bot api failed to reply", logger.Err, e)
+	}
+}
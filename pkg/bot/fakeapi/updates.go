@@ -0,0 +1,85 @@
+package fakeapi
+
+import (
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+// Returns chat of the given id, private for positive ids and group otherwise.
+func Chat(chatID int64) *tgbotapi.Chat {
+	if chatID > 0 {
+		return &tgbotapi.Chat{ID: chatID, Type: "private"}
+	}
+
+	return &tgbotapi.Chat{ID: chatID, Type: "group", Title: "test group"}
+}
+
+// Returns user with id derived from the user name.
+func User(userName string) *tgbotapi.User {
+	var userID int64
+	for _, r := range userName {
+		userID = userID*31 + int64(r)
+	}
+
+	return &tgbotapi.User{ID: userID, FirstName: userName, UserName: userName}
+}
+
+// Returns update with a text message of the user in the chat.
+func Message(chatID int64, userName, text string) tgbotapi.Update {
+	msg := &tgbotapi.Message{
+		From: User(userName),
+		Chat: Chat(chatID),
+		Text: text,
+	}
+
+	// commands are recognized by entities
+	if len(text) > 0 && text[0] == '/' {
+		length := len(text)
+		for i, r := range text {
+			if r == ' ' {
+				length = i
+				break
+			}
+		}
+		msg.Entities = []tgbotapi.MessageEntity{{Type: "bot_command", Offset: 0, Length: length}}
+	}
+
+	return tgbotapi.Update{Message: msg}
+}
+
+// Returns update with the user left the group chat.
+func LeftMember(chatID int64, userName string) tgbotapi.Update {
+	return tgbotapi.Update{
+		Message: &tgbotapi.Message{
+			From:           User(userName),
+			Chat:           Chat(chatID),
+			LeftChatMember: User(userName),
+		},
+	}
+}
+
+// Returns update with changed status of the bot in the chat made by the user,
+// e.g. kicked when user blocks the bot, left when the bot is removed from a group.
+func BotStatus(bot tgbotapi.User, chatID int64, userName, status string) tgbotapi.Update {
+	return tgbotapi.Update{
+		MyChatMember: &tgbotapi.ChatMemberUpdated{
+			Chat: *Chat(chatID),
+			From: *User(userName),
+			NewChatMember: tgbotapi.ChatMember{
+				User:   &bot,
+				Status: status,
+			},
+		},
+	}
+}
+
+// Returns update with the user pressed an inline button in the chat.
+func CallbackQuery(chatID int64, userName, data string) tgbotapi.Update {
+	return tgbotapi.Update{
+		CallbackQuery: &tgbotapi.CallbackQuery{
+			ID:      userName + ":" + data,
+			From:    User(userName),
+			Message: &tgbotapi.Message{Chat: Chat(chatID)},
+			Data:    data,
+		},
+	}
+}
@@ -0,0 +1,166 @@
+package bot
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+
+	"github.com/go-redis/redis/v9"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+	"github.com/pkg/errors"
+)
+
+const (
+	intervalField = "interval"
+	pagesField    = "pages"
+)
+
+// Scanning settings of a subscription, zero values are defaults of the scanner.
+type Settings struct {
+	Interval time.Duration // scan interval
+	Pages    int           // pages to scan of a filter
+}
+
+// Default handler on /interval <duration> and /pages <n> commands.
+func defaultHandleSettings(update *tgbotapi.Update, key id.Key, s stater, cfg *Config) (tgbotapi.Chattable, error) {
+	if resp, err := checkAccess(update, key, s); err != nil {
+		return resp, err
+	}
+
+	strURL, found := s.getURL()
+	if state, ok := s.getState(); !ok || state != Subscribed || !found {
+		text := fmt.Sprintf("@%s, Please subscribe with /url command first", key.UserName)
+		return tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+	}
+
+	filter, err := url.Parse(strURL)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed parse url %s", strURL)
+	}
+
+	fields := strings.Fields(update.Message.Text)
+	arg := ""
+	if len(fields) > 1 {
+		arg = fields[1]
+	}
+
+	settings := s.getSettings()
+	quota := cfg.Access.Quota
+
+	var resp tgbotapi.Chattable
+	if strings.HasPrefix(fields[0], "/pages") {
+		settings.Pages, resp, err = parsePages(arg, key, quota)
+	} else {
+		settings.Interval, resp, err = parseInterval(arg, key, quota)
+	}
+	if err != nil {
+		return resp, err
+	}
+
+	if resp, err = cfg.OnSettings(update, key, *filter, settings); err != nil {
+		return resp, err
+	}
+
+	s.setSettings(settings)
+
+	return resp, nil
+}
+
+// Parses scan interval and validates it against the quota.
+func parseInterval(arg string, key id.Key, quota Quota) (time.Duration, tgbotapi.Chattable, error) {
+	interval, err := time.ParseDuration(arg)
+	if err != nil || interval <= 0 ||
+		(quota.MinInterval > 0 && interval < quota.MinInterval) ||
+		(quota.MaxInterval > 0 && interval > quota.MaxInterval) {
+		text := fmt.Sprintf("@%s, Please send a scan interval", key.UserName)
+		switch {
+		case quota.MinInterval > 0 && quota.MaxInterval > 0:
+			text += fmt.Sprintf(" from %s to %s", quota.MinInterval, quota.MaxInterval)
+		case quota.MinInterval > 0:
+			text += fmt.Sprintf(" of %s at least", quota.MinInterval)
+		case quota.MaxInterval > 0:
+			text += fmt.Sprintf(" of %s at most", quota.MaxInterval)
+		}
+		text += ", e.g. /interval 10m"
+
+		return 0, tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+	}
+
+	return interval, nil, nil
+}
+
+// Parses pages to scan and validates them against the quota.
+func parsePages(arg string, key id.Key, quota Quota) (int, tgbotapi.Chattable, error) {
+	pages, err := strconv.Atoi(arg)
+	if err != nil || pages < 1 || (quota.MaxPages > 0 && pages > quota.MaxPages) {
+		text := fmt.Sprintf("@%s, Please send a number of pages", key.UserName)
+		if quota.MaxPages > 0 {
+			text += fmt.Sprintf(" from 1 to %d", quota.MaxPages)
+		}
+		text += ", e.g. /pages 2"
+
+		return 0, tgbotapi.NewMessage(int64(key.ChatID), text), errors.New(text)
+	}
+
+	return pages, nil, nil
+}
+
+func (w *wrapper) getSettings() Settings {
+	return w.s.settings[w.key]
+}
+
+func (w *wrapper) getURL() (string, bool) {
+	strURL, found := w.s.urls[w.key]
+	return strURL, found
+}
+
+func (w *wrapper) setSettings(settings Settings) {
+	botKey := botID(w.key)
+
+	// store settings in hash
+	w.s.settings[w.key] = settings
+
+	// store settings in permanent storage along with state and url
+	if status := w.s.rdb.HSet(w.ctx, botKey.String(),
+		intervalField, settings.Interval.String(),
+		pagesField, settings.Pages,
+	); status.Err() != nil {
+		w.log().Error("failed redis:hset", "key", botKey, "settings", settings, logger.Err, status.Err())
+	} else {
+		w.log().Debug("success redis:hset", "key", botKey, "settings", settings)
+	}
+}
+
+func (w *wrapper) loadSettings() (Settings, error) {
+	return LoadSettings(w.ctx, w.s.rdb, w.key)
+}
+
+// Loads stored settings of the member, missing settings are zero.
+func LoadSettings(ctx context.Context, rdb *redis.Client, key id.Key) (Settings, error) {
+	var settings Settings
+
+	botKey := botID(key)
+	values, err := rdb.HMGet(ctx, botKey.String(), intervalField, pagesField).Result()
+	if err != nil {
+		return settings, fmt.Errorf("failed redis:hmget %s, error %w", botKey, err)
+	}
+
+	if raw, ok := values[0].(string); ok {
+		if settings.Interval, err = time.ParseDuration(raw); err != nil {
+			return settings, fmt.Errorf("failed parse key %s interval %s, error %w", botKey, raw, err)
+		}
+	}
+	if raw, ok := values[1].(string); ok {
+		if settings.Pages, err = strconv.Atoi(raw); err != nil {
+			return settings, fmt.Errorf("failed parse key %s pages %s, error %w", botKey, raw, err)
+		}
+	}
+
+	return settings, nil
+}
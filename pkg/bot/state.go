@@ -4,7 +4,7 @@ import (
 	"context"
 	"fmt"
 	"krisha_kz_bot/pkg/id"
-	"log"
+	"krisha_kz_bot/pkg/logger"
 	"net/url"
 	"strings"
 
@@ -44,12 +44,18 @@ func (state *State) UnmarshalBinary(data []byte) error {
 type getter interface {
 	getState() (State, bool)
 	getSubsInChat() ([]id.Key, bool)
+	getURL() (string, bool)
+	getSettings() Settings
+	isAllowed(userID int64) bool
+	countSubs() int
 }
 
 type setter interface {
 	setState(State)
 	// addSubscriber()
 	setURL(string)
+	setSettings(Settings)
+	redeem(userID int64, code string) bool
 }
 
 type deleter interface {
@@ -84,6 +90,11 @@ func (s *Service) wrapCtx(ctx context.Context, key id.Key) *wrapper {
 	}
 }
 
+// Returns logger of the wrapper context with fields of the member.
+func (w *wrapper) log() *logger.Logger {
+	return logger.FromContext(w.ctx).WithKey(w.key)
+}
+
 type botID id.Key
 
 func (bid botID) String() string {
@@ -125,20 +136,22 @@ func (w *wrapper) setState(state State) {
 	case Subscribed:
 		// store state in permanent storage
 		if status := w.s.rdb.HSet(w.ctx, botKey.String(), "state", state); status.Err() != nil {
-			log.Printf("failed redis:hset %s state %s, error %v\n", botKey, state, status.Err())
+			w.log().Error("failed redis:hset", "key", botKey, "state", state, logger.Err, status.Err())
 		} else {
-			log.Printf("success redis:hset %s %s\n", botKey, state)
+			w.log().Debug("success redis:hset", "key", botKey, "state", state)
 		}
 
 		// store subscriber in chats hash
 		w.addSub()
 
 	case Default:
-		// remove subscriber state and url from permanent storage
+		delete(w.s.settings, w.key)
+
+		// remove subscriber state, url and settings from permanent storage
 		if status := w.s.rdb.Del(w.ctx, botKey.String()); status.Err() != nil {
-			log.Printf("failed redis:del %s, error %v\n", botKey, status.Err())
+			w.log().Error("failed redis:del", "key", botKey, logger.Err, status.Err())
 		} else {
-			log.Printf("success redis:del %s\n", botKey)
+			w.log().Debug("success redis:del", "key", botKey)
 		}
 
 		// remove subscriber from chats hash
@@ -163,9 +176,9 @@ func (w *wrapper) setURL(url string) {
 
 	// store url in permanent storage
 	if status := w.s.rdb.HSet(w.ctx, botKey.String(), "url", url); status.Err() != nil {
-		log.Printf("failed redis:hset %s url %s, error %v\n", botKey, url, status.Err())
+		w.log().Error("failed redis:hset", "key", botKey, logger.URL, url, logger.Err, status.Err())
 	} else {
-		log.Printf("success redis:hset %s %s\n", botKey, url)
+		w.log().Debug("success redis:hset", "key", botKey, logger.URL, url)
 	}
 }
 
@@ -192,12 +205,13 @@ func (w *wrapper) delState() {
 	botKey := botID(w.key)
 
 	delete(w.s.states, w.key)
+	delete(w.s.settings, w.key)
 
-	// remove subscriber state and url from permanent storage
+	// remove subscriber state, url and settings from permanent storage
 	if status := w.s.rdb.Del(w.ctx, botKey.String()); status.Err() != nil {
-		log.Printf("failed redis:del %s, error %v\n", botKey, status.Err())
+		w.log().Error("failed redis:del", "key", botKey, logger.Err, status.Err())
 	} else {
-		log.Printf("success redis:del %s\n", botKey)
+		w.log().Debug("success redis:del", "key", botKey)
 	}
 
 	// remove subscriber from chats hash
@@ -212,21 +226,28 @@ func (w *wrapper) getSubsInChat() ([]id.Key, bool) {
 // Loads cache from redis in the wraper context.
 // Invoke only before bot service start.
 func (s *Service) load(ctx context.Context) {
+	s.loadGranted(ctx)
+
 	keysCh := loadKeys(ctx, s.rdb)
 
 	for key := range keysCh {
 		var (
-			state State
-			url   *url.URL
-			err   error
+			state    State
+			url      *url.URL
+			settings Settings
+			err      error
 		)
-		if state, url, err = s.wrapCtx(ctx, key).loadState(); err != nil {
-			log.Printf("failed to load data, error %v\n", err)
+		w := s.wrapCtx(ctx, key)
+		if state, url, err = w.loadState(); err != nil {
+			w.log().Error("failed to load subscription", logger.Err, err)
 			continue
 		}
+		if settings, err = w.loadSettings(); err != nil {
+			w.log().Error("failed to load settings", logger.Err, err)
+		}
 
-		if _, err = s.config.OnSubscribe(nil, key, *url); err != nil {
-			log.Printf("failed to load data, error %v\n", err)
+		if _, err = s.config.OnSubscribe(nil, key, *url, s.config.Access.Quota, settings); err != nil {
+			w.log().Error("failed to restore subscription", logger.URL, url, logger.Err, err)
 			continue
 		}
 
@@ -234,6 +255,7 @@ func (s *Service) load(ctx context.Context) {
 
 		s.states[key] = state
 		s.urls[key] = url.String()
+		s.settings[key] = settings
 
 		// add subscribed member in subscribers hash
 		if subscribers, found := s.chats[key.ChatID]; found {
@@ -263,20 +285,20 @@ func loadKeys(ctx context.Context, rdb *redis.Client) <-chan id.Key {
 			var keys []string
 			status := rdb.ScanType(ctx, cursor, match, count, typ)
 			if err := status.Err(); err != nil {
-				log.Printf("failed to redis:scan %d match %s count %d type %s, error %v\n", cursor, match, count, typ, err)
+				logger.FromContext(ctx).Error("failed redis:scan", "cursor", cursor, "match", match, logger.Err, err)
 				break
 			}
 
 			var err error
 			if keys, cursor, err = status.Result(); err != nil {
-				log.Printf("failed to redis:scan %d match %s count %d type %s, error %v\n", cursor, match, count, typ, err)
+				logger.FromContext(ctx).Error("failed redis:scan", "cursor", cursor, "match", match, logger.Err, err)
 				break
 			}
 
 			for _, keyRaw := range keys {
 				var bid botID
 				if err = bid.UnmarshalBinary([]byte(keyRaw)); err != nil {
-					log.Printf("failed to parse %s, error %v\n", keyRaw, err)
+					logger.FromContext(ctx).Warn("failed to parse key", "key", keyRaw, logger.Err, err)
 					continue
 				}
 
@@ -293,14 +315,24 @@ func loadKeys(ctx context.Context, rdb *redis.Client) <-chan id.Key {
 }
 
 func (w *wrapper) loadState() (State, *url.URL, error) {
+	return LoadSubscription(w.ctx, w.s.rdb, w.key)
+}
+
+// Returns keys of stored members.
+func LoadKeys(ctx context.Context, rdb *redis.Client) <-chan id.Key {
+	return loadKeys(ctx, rdb)
+}
+
+// Loads stored state and filter of the member.
+func LoadSubscription(ctx context.Context, rdb *redis.Client, key id.Key) (State, *url.URL, error) {
 	var (
 		state State
 		url   *url.URL
 		err   error
 	)
 
-	botKey := botID(w.key)
-	status := w.s.rdb.HGetAll(w.ctx, botKey.String())
+	botKey := botID(key)
+	status := rdb.HGetAll(ctx, botKey.String())
 
 	if err = status.Err(); err != nil {
 		return state, nil, fmt.Errorf("failed redis:hgetall %s, error %w", botKey, err)
@@ -311,6 +343,10 @@ func (w *wrapper) loadState() (State, *url.URL, error) {
 		return state, nil, fmt.Errorf("failed redis:hgetall %s, error %w", botKey, err)
 	}
 
+	if len(values) == 0 {
+		return state, nil, errors.WithMessagef(ErrNotFound, "key %s", botKey)
+	}
+
 	stateRaw, found1 := values["state"]
 	urlRaw, found2 := values["url"]
 	if !found1 || !found2 {
@@ -327,3 +363,23 @@ func (w *wrapper) loadState() (State, *url.URL, error) {
 
 	return state, url, nil
 }
+
+// Stores the member subscribed on the filter, stored settings are kept.
+func StoreSubscription(ctx context.Context, rdb *redis.Client, key id.Key, filter url.URL) error {
+	botKey := botID(key)
+	if err := rdb.HSet(ctx, botKey.String(), "state", Subscribed, "url", filter.String()).Err(); err != nil {
+		return fmt.Errorf("failed redis:hset %s, error %w", botKey, err)
+	}
+
+	return nil
+}
+
+// Removes stored state, filter and settings of the member.
+func DeleteSubscription(ctx context.Context, rdb *redis.Client, key id.Key) error {
+	botKey := botID(key)
+	if err := rdb.Del(ctx, botKey.String()).Err(); err != nil {
+		return fmt.Errorf("failed redis:del %s, error %w", botKey, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package clock
+
+import (
+	"context"
+	"time"
+)
+
+// Source of time and timers, replaced by Fake in tests.
+type Clock interface {
+	Now() time.Time
+	NewTimer(d time.Duration) Timer
+}
+
+// Timer created by a clock, see time.Timer.
+type Timer interface {
+	C() <-chan time.Time
+	Stop() bool
+	Reset(d time.Duration) bool
+}
+
+// Returns clock of the system time.
+func New() Clock {
+	return realClock{}
+}
+
+// Returns the given clock or system one if nil.
+func OrDefault(c Clock) Clock {
+	if c == nil {
+		return New()
+	}
+
+	return c
+}
+
+// Waits for the given duration or until context is done.
+func Sleep(ctx context.Context, c Clock, d time.Duration) error {
+	timer := c.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C():
+		return nil
+	}
+}
+
+// Returns time elapsed since t by the given clock.
+func Since(c Clock, t time.Time) time.Duration {
+	return c.Now().Sub(t)
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time {
+	return time.Now()
+}
+
+func (realClock) NewTimer(d time.Duration) Timer {
+	return realTimer{time.NewTimer(d)}
+}
+
+type realTimer struct {
+	*time.Timer
+}
+
+func (t realTimer) C() <-chan time.Time {
+	return t.Timer.C
+}
@@ -0,0 +1,114 @@
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock with manually advanced time, timers fire when time passes their deadline.
+type Fake struct {
+	now    time.Time
+	timers map[*fakeTimer]struct{} // active timers
+	mx     sync.Mutex
+	cond   *sync.Cond
+}
+
+// Creates fake clock set to the given time.
+func NewFake(now time.Time) *Fake {
+	f := &Fake{
+		now:    now,
+		timers: make(map[*fakeTimer]struct{}),
+	}
+	f.cond = sync.NewCond(&f.mx)
+
+	return f
+}
+
+func (f *Fake) Now() time.Time {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	return f.now
+}
+
+func (f *Fake) NewTimer(d time.Duration) Timer {
+	t := &fakeTimer{
+		fake: f,
+		c:    make(chan time.Time, 1),
+	}
+	t.Reset(d)
+
+	return t
+}
+
+// Moves time forward and fires expired timers.
+func (f *Fake) Advance(d time.Duration) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	f.now = f.now.Add(d)
+	for t := range f.timers {
+		if !t.deadline.After(f.now) {
+			t.fire(f.now)
+		}
+	}
+}
+
+// Blocks until at least n timers are active, so time is advanced after a component started waiting.
+func (f *Fake) BlockUntil(n int) {
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	for len(f.timers) < n {
+		f.cond.Wait()
+	}
+}
+
+type fakeTimer struct {
+	fake     *Fake
+	c        chan time.Time
+	deadline time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.fake.mx.Lock()
+	defer t.fake.mx.Unlock()
+
+	_, active := t.fake.timers[t]
+	delete(t.fake.timers, t)
+
+	return active
+}
+
+func (t *fakeTimer) Reset(d time.Duration) bool {
+	f := t.fake
+	f.mx.Lock()
+	defer f.mx.Unlock()
+
+	_, active := f.timers[t]
+	t.deadline = f.now.Add(d)
+
+	if d <= 0 {
+		t.fire(f.now)
+		return active
+	}
+
+	f.timers[t] = struct{}{}
+	f.cond.Broadcast()
+
+	return active
+}
+
+// Sends time to the channel and deactivates the timer, shall be called under lock.
+func (t *fakeTimer) fire(now time.Time) {
+	delete(t.fake.timers, t)
+
+	select {
+	case t.c <- now:
+	default:
+	}
+}
@@ -0,0 +1,58 @@
+package clock_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+)
+
+func TestFakeTimer(t *testing.T) {
+	start := time.Date(2022, time.November, 1, 0, 0, 0, 0, time.UTC)
+	fake := clock.NewFake(start)
+
+	timer := fake.NewTimer(time.Minute)
+
+	fake.Advance(59 * time.Second)
+	select {
+	case <-timer.C():
+		t.Fatalf("want timer not fired before deadline")
+	default:
+	}
+
+	fake.Advance(time.Second)
+	if got := <-timer.C(); !got.Equal(start.Add(time.Minute)) {
+		t.Errorf("want timer fired at %s, got %s", start.Add(time.Minute), got)
+	}
+
+	if timer.Reset(time.Minute) {
+		t.Errorf("want fired timer inactive")
+	}
+	if !timer.Stop() {
+		t.Errorf("want reset timer active")
+	}
+
+	fake.Advance(time.Hour)
+	select {
+	case <-timer.C():
+		t.Errorf("want stopped timer not fired")
+	default:
+	}
+}
+
+func TestFakeSleep(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+
+	done := make(chan error)
+	go func() {
+		done <- clock.Sleep(context.Background(), fake, time.Hour)
+	}()
+
+	fake.BlockUntil(1)
+	fake.Advance(time.Hour)
+
+	if err := <-done; err != nil {
+		t.Errorf("want sleep finished, got error %v", err)
+	}
+}
@@ -2,14 +2,63 @@ package crawler
 
 import (
 	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
 )
 
 // Crawler daemon to scan, parse and notify about results.
 type Crawler[Result any] interface {
-	Start(ctx context.Context) <-chan Result
+	Start(ctx context.Context) <-chan CrawlResult[Result]
 	Stop()
 }
 
+// Results of a crawl cycle over all pages.
+type CrawlResult[Result any] struct {
+	Items    []Result          // results of all pages in page order
+	Pages    int               // pages crawled successfully
+	Errors   []error           // errors of failed pages
+	Duration time.Duration     // duration of the cycle
+	At       time.Time         // start of the cycle
+	Trace    trace.SpanContext // span of the cycle, invalid if not traced
+}
+
+// Returns true if all pages were crawled successfully.
+func (res *CrawlResult[Result]) IsComplete() bool {
+	return len(res.Errors) == 0
+}
+
 type Countable interface {
 	GetCount() uint64
 }
+
+// Crawler statistics.
+type Stats struct {
+	Crawls      uint64 // crawl cycles by timer
+	Requests    uint64 // pages loaded
+	NotModified uint64 // pages revalidated by http cache
+	CacheHits   uint64 // pages with unchanged content, parsing skipped
+	CacheMisses uint64 // pages parsed
+}
+
+type StatsProvider interface {
+	GetStats() Stats
+}
+
+// Crawler able to crawl out of schedule.
+type Triggerable interface {
+	// Requests crawl cycle, returns false if one is already requested.
+	CrawlNow() bool
+}
+
+// Crawler able to change interval of periodic crawls.
+type Reschedulable interface {
+	// Changes interval, the pending crawl is rescheduled by the new interval.
+	SetInterval(interval time.Duration)
+}
+
+// Crawler able to change crawled pages.
+type Retargetable interface {
+	// Changes pages crawled from the next crawl cycle.
+	SetURLs(urls []string)
+}
@@ -0,0 +1,155 @@
+package fixture
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sync"
+
+	"krisha_kz_bot/pkg/logger"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	Ext = ".http" // extension of fixture files
+
+	maxNameLen = 100
+)
+
+var ErrNoFixture = errors.New("no fixture")
+
+//nolint:gochecknoglobals // compiled once
+var reUnsafe = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// Returns fixture file name of the url, e.g. krisha.kz_arenda_kvartiry_almaty_page_2-1a2b3c4d.http.
+func FileName(u *url.URL) string {
+	name := reUnsafe.ReplaceAllString(u.Host+u.Path+"_"+u.RawQuery, "_")
+	if len(name) > maxNameLen {
+		name = name[:maxNameLen]
+	}
+
+	sum := sha256.Sum256([]byte(u.String()))
+
+	return name + "-" + hex.EncodeToString(sum[:4]) + Ext
+}
+
+// Http transport saving responses with headers into the fixture directory.
+type Recorder struct {
+	Base http.RoundTripper // http.DefaultTransport if nil
+	Dir  string
+
+	mx sync.Mutex
+}
+
+// Creates recorder over the given transport.
+func NewRecorder(base http.RoundTripper, dir string) *Recorder {
+	return &Recorder{
+		Base: base,
+		Dir:  dir,
+	}
+}
+
+// Implements http.RoundTripper.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := r.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+
+	resp, err := base.RoundTrip(req)
+	if err != nil || req.Method != http.MethodGet {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	if e := r.save(req.URL, resp, body); e != nil {
+		logger.FromContext(req.Context()).Error("failed to record fixture", logger.URL, req.URL, logger.Err, e)
+	}
+
+	return resp, nil
+}
+
+func (r *Recorder) save(u *url.URL, resp *http.Response, body []byte) error {
+	// dump consumes body of the response
+	dumped := *resp
+	dumped.Body = io.NopCloser(bytes.NewReader(body))
+	dumped.ContentLength = int64(len(body))
+	dumped.TransferEncoding = nil
+	dumped.Header = resp.Header.Clone()
+	dumped.Header.Del("Content-Encoding")
+	dumped.Header.Del("Transfer-Encoding")
+
+	data, err := httputil.DumpResponse(&dumped, true)
+	if err != nil {
+		return err
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if err = os.MkdirAll(r.Dir, 0o755); err != nil {
+		return err
+	}
+
+	path := filepath.Join(r.Dir, FileName(u))
+	//nolint:gosec // fixtures are committed
+	if err = os.WriteFile(path, data, 0o644); err != nil {
+		return err
+	}
+
+	logger.Default().Info("recorded fixture", logger.URL, u, "path", path)
+
+	return nil
+}
+
+// Http transport serving responses from the fixture directory.
+type Replayer struct {
+	Dir string
+}
+
+// Creates replayer of the fixture directory.
+func NewReplayer(dir string) *Replayer {
+	return &Replayer{Dir: dir}
+}
+
+// Implements http.RoundTripper.
+func (r *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	path := filepath.Join(r.Dir, FileName(req.URL))
+
+	resp, err := Load(path, req)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.WithMessagef(ErrNoFixture, "%s %s", req.URL, path)
+	}
+
+	return resp, err
+}
+
+// Loads recorded response from the fixture file.
+func Load(path string, req *http.Request) (*http.Response, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := http.ReadResponse(bufio.NewReader(bytes.NewReader(data)), req)
+	if err != nil {
+		return nil, errors.WithMessagef(err, "fixture %s", path)
+	}
+
+	return resp, nil
+}
@@ -0,0 +1,59 @@
+package fixture_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"krisha_kz_bot/pkg/crawler/fixture"
+)
+
+func TestRecordReplay(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		if _, err := w.Write([]byte("page " + r.URL.Query().Get("page"))); err != nil {
+			t.Errorf("failed to write response, got error %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	recorder := &http.Client{Transport: fixture.NewRecorder(srv.Client().Transport, dir)}
+	replayer := &http.Client{Transport: fixture.NewReplayer(dir)}
+
+	get := func(client *http.Client, url string) (string, http.Header, error) {
+		resp, err := client.Get(url)
+		if err != nil {
+			return "", nil, err
+		}
+		defer resp.Body.Close()
+
+		body, err := io.ReadAll(resp.Body)
+		return string(body), resp.Header, err
+	}
+
+	for _, page := range []string{"1", "2"} {
+		if _, _, err := get(recorder, srv.URL+"/?page="+page); err != nil {
+			t.Fatalf("failed to record, got error %v", err)
+		}
+	}
+
+	// replays recorded responses without a host
+	srv.Close()
+
+	for _, page := range []string{"1", "2"} {
+		body, header, err := get(replayer, srv.URL+"/?page="+page)
+		if err != nil {
+			t.Fatalf("failed to replay, got error %v", err)
+		}
+		if body != "page "+page || header.Get("ETag") != `"v1"` {
+			t.Errorf("want recorded page %s with headers, got %s %v", page, body, header)
+		}
+	}
+
+	if _, _, err := get(replayer, srv.URL+"/?page=3"); !errors.Is(err, fixture.ErrNoFixture) {
+		t.Errorf("want no fixture error, got %v", err)
+	}
+}
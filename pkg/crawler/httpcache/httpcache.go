@@ -0,0 +1,226 @@
+package httpcache
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+
+	"github.com/andybalholm/brotli"
+)
+
+const (
+	DefaultMaxEntries = 1000
+
+	HeaderCache      = "X-Cache"     // set on responses served from cache
+	CacheRevalidated = "REVALIDATED" // value of HeaderCache when host replied 304 Not Modified
+)
+
+// Cached response.
+type entry struct {
+	etag         string
+	lastModified string
+	header       http.Header
+	body         []byte
+	stored       time.Time
+}
+
+// Http cache statistics.
+type Stats struct {
+	Requests    uint64 // requests sent to a host
+	Revalidated uint64 // responses served from cache after 304 Not Modified
+	Misses      uint64 // responses loaded from a host
+}
+
+// Http transport with cache revalidated by ETag and Last-Modified,
+// decodes gzip and brotli encoded responses.
+type Transport struct {
+	Base       http.RoundTripper // http.DefaultTransport if nil
+	MaxEntries int               // DefaultMaxEntries if not positive
+	Clock      clock.Clock       // system clock if nil
+
+	entries map[string]*entry
+	mx      sync.Mutex
+
+	requests    uint64
+	revalidated uint64
+	misses      uint64
+}
+
+// Creates cache transport over the given one.
+func NewTransport(base http.RoundTripper) *Transport {
+	return &Transport{
+		Base:    base,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method != http.MethodGet {
+		return t.base().RoundTrip(req)
+	}
+
+	key := req.URL.String()
+	cached := t.get(key)
+
+	req = req.Clone(req.Context())
+	req.Header.Set("Accept-Encoding", "gzip, br")
+	if cached != nil {
+		if cached.etag != "" {
+			req.Header.Set("If-None-Match", cached.etag)
+		}
+		if cached.lastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.lastModified)
+		}
+	}
+
+	resp, err := t.base().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&t.requests, 1)
+
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		atomic.AddUint64(&t.revalidated, 1)
+
+		return cached.response(req), nil
+	}
+	atomic.AddUint64(&t.misses, 1)
+
+	if err = decode(resp); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+	if resp.StatusCode != http.StatusOK || (etag == "" && lastModified == "") {
+		return resp, nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	t.put(key, &entry{
+		etag:         etag,
+		lastModified: lastModified,
+		header:       resp.Header.Clone(),
+		body:         body,
+		stored:       clock.OrDefault(t.Clock).Now(),
+	})
+
+	return resp, nil
+}
+
+// Returns statistics of the cache.
+func (t *Transport) GetStats() Stats {
+	return Stats{
+		Requests:    atomic.LoadUint64(&t.requests),
+		Revalidated: atomic.LoadUint64(&t.revalidated),
+		Misses:      atomic.LoadUint64(&t.misses),
+	}
+}
+
+func (t *Transport) base() http.RoundTripper {
+	if t.Base == nil {
+		return http.DefaultTransport
+	}
+
+	return t.Base
+}
+
+func (t *Transport) get(key string) *entry {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	return t.entries[key]
+}
+
+// Stores entry and evicts the oldest one when cache is full.
+func (t *Transport) put(key string, e *entry) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if t.entries == nil {
+		t.entries = make(map[string]*entry)
+	}
+
+	maxEntries := t.MaxEntries
+	if maxEntries <= 0 {
+		maxEntries = DefaultMaxEntries
+	}
+
+	if _, found := t.entries[key]; !found && len(t.entries) >= maxEntries {
+		var oldest string
+		for k, v := range t.entries {
+			if oldest == "" || v.stored.Before(t.entries[oldest].stored) {
+				oldest = k
+			}
+		}
+		delete(t.entries, oldest)
+	}
+
+	t.entries[key] = e
+}
+
+// Builds 200 OK response from the cached one.
+func (e *entry) response(req *http.Request) *http.Response {
+	header := e.header.Clone()
+	header.Set(HeaderCache, CacheRevalidated)
+
+	return &http.Response{
+		Status:        "200 OK",
+		StatusCode:    http.StatusOK,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(e.body)),
+		ContentLength: int64(len(e.body)),
+		Request:       req,
+	}
+}
+
+// Decodes response body by Content-Encoding.
+func decode(resp *http.Response) error {
+	var body io.Reader
+
+	switch strings.ToLower(resp.Header.Get("Content-Encoding")) {
+	case "gzip":
+		gz, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			return err
+		}
+		body = gz
+	case "br":
+		body = brotli.NewReader(resp.Body)
+	default:
+		return nil
+	}
+
+	resp.Body = &decodedBody{Reader: body, Closer: resp.Body}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return nil
+}
+
+// Decoded body closing the original one.
+type decodedBody struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,87 @@
+package httpcache_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"krisha_kz_bot/pkg/crawler/httpcache"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestTransport(t *testing.T) {
+	const payload = "<html>krisha.kz</html>"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Encoding", "gzip")
+
+		gz := gzip.NewWriter(w)
+		defer gz.Close()
+
+		if _, err := gz.Write([]byte(payload)); err != nil {
+			t.Errorf("failed to write response, got error %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	transport := httpcache.NewTransport(srv.Client().Transport)
+	client := &http.Client{Transport: transport}
+
+	for i, wantCache := range []string{"", httpcache.CacheRevalidated} {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			t.Fatalf("request %d failed, got error %v", i, err)
+		}
+
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil || string(body) != payload {
+			t.Errorf("request %d, want %s, got %s, error %v", i, payload, body, err)
+		}
+		if got := resp.Header.Get(httpcache.HeaderCache); got != wantCache {
+			t.Errorf("request %d, want cache header %q, got %q", i, wantCache, got)
+		}
+	}
+
+	if stats := transport.GetStats(); stats.Requests != 2 || stats.Revalidated != 1 || stats.Misses != 1 {
+		t.Errorf("want 2 requests, 1 revalidated and 1 miss, got %+v", stats)
+	}
+}
+
+func TestTransportBrotli(t *testing.T) {
+	const payload = "<html>krisha.kz</html>"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+
+		br := brotli.NewWriter(w)
+		defer br.Close()
+
+		if _, err := br.Write([]byte(payload)); err != nil {
+			t.Errorf("failed to write response, got error %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: httpcache.NewTransport(srv.Client().Transport)}
+
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatalf("request failed, got error %v", err)
+	}
+	defer resp.Body.Close()
+
+	if body, err := io.ReadAll(resp.Body); err != nil || string(body) != payload {
+		t.Errorf("want %s, got %s, error %v", payload, body, err)
+	}
+}
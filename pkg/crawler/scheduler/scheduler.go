@@ -0,0 +1,200 @@
+package scheduler
+
+import (
+	"container/heap"
+	"context"
+	"math"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/logger"
+)
+
+const (
+	DefaultRequestsPerMinute = 20
+
+	goldenRatio = 0.6180339887498949 // spreads phases of any number of subscriptions evenly
+	phaseJitter = 0.05               // max jitter of a phase as a fraction of interval
+)
+
+// Scheduler config.
+type Config struct {
+	RequestsPerMinute int         // budget of requests per host
+	Clock             clock.Clock // system clock if nil
+}
+
+// Request waiting for a slot.
+type waiter struct {
+	priority int
+	seq      uint64
+	ready    chan struct{}
+	index    int       // index in queue, -1 when dispatched or canceled
+	granted  time.Time // time of the granted slot
+}
+
+// Priority queue of waiters, implements heap.Interface.
+type queue []*waiter
+
+func (q queue) Len() int { return len(q) }
+
+func (q queue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority < q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+
+func (q queue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].index = i
+	q[j].index = j
+}
+
+func (q *queue) Push(x any) {
+	w, _ := x.(*waiter)
+	w.index = len(*q)
+	*q = append(*q, w)
+}
+
+func (q *queue) Pop() any {
+	old := *q
+	n := len(old)
+	w := old[n-1]
+	old[n-1] = nil
+	w.index = -1
+	*q = old[:n-1]
+	return w
+}
+
+type host struct {
+	name    string
+	queue   queue
+	next    time.Time // time of the next free slot
+	running bool      // dispatcher is running
+}
+
+// Global scheduler of requests shared between crawlers.
+// Grants requests to a host not more often than the budget allows,
+// requests with lower priority value are granted first.
+type Scheduler struct {
+	period time.Duration // interval between requests to a host
+	clock  clock.Clock
+	hosts  map[string]*host
+	seq    uint64
+	phases uint64
+	mx     sync.Mutex
+}
+
+// Creates scheduler from the given config.
+func New(config Config) *Scheduler {
+	rpm := config.RequestsPerMinute
+	if rpm <= 0 {
+		rpm = DefaultRequestsPerMinute
+	}
+
+	return &Scheduler{
+		period: time.Minute / time.Duration(rpm),
+		clock:  clock.OrDefault(config.Clock),
+		hosts:  make(map[string]*host),
+	}
+}
+
+// Blocks until a request to the host is granted or context is done.
+// Priority is usually a page index, so first pages are fetched before deep ones.
+func (s *Scheduler) Wait(ctx context.Context, hostName string, priority int) error {
+	s.mx.Lock()
+	h, found := s.hosts[hostName]
+	if !found {
+		h = &host{name: hostName}
+		s.hosts[hostName] = h
+	}
+
+	s.seq++
+	w := &waiter{
+		priority: priority,
+		seq:      s.seq,
+		ready:    make(chan struct{}),
+	}
+	heap.Push(&h.queue, w)
+
+	if !h.running {
+		h.running = true
+		go s.dispatch(h)
+	}
+	s.mx.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mx.Lock()
+		defer s.mx.Unlock()
+
+		switch {
+		case w.index >= 0:
+			heap.Remove(&h.queue, w.index)
+		case h.next.Equal(w.granted.Add(s.period)):
+			// slot granted along with cancel is given back, unless the next one has been granted
+			h.next = w.granted
+		}
+
+		return ctx.Err()
+	}
+}
+
+// Grants queued requests to the host one per period, exits when queue is empty.
+func (s *Scheduler) dispatch(h *host) {
+	for {
+		s.mx.Lock()
+		if h.queue.Len() == 0 {
+			h.running = false
+			s.mx.Unlock()
+			return
+		}
+
+		now := s.clock.Now()
+		if wait := h.next.Sub(now); wait > 0 {
+			s.mx.Unlock()
+			_ = clock.Sleep(context.Background(), s.clock, wait)
+			continue
+		}
+
+		w, _ := heap.Pop(&h.queue).(*waiter)
+		w.granted = now
+		close(w.ready)
+		h.next = now.Add(s.period)
+		s.mx.Unlock()
+	}
+}
+
+// Returns number of requests to the host waiting for a slot.
+func (s *Scheduler) Pending(hostName string) int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if h, found := s.hosts[hostName]; found {
+		return h.queue.Len()
+	}
+
+	return 0
+}
+
+// Returns offset of the first periodic crawl, so subscriptions are spread over the interval.
+func (s *Scheduler) Phase(interval time.Duration) time.Duration {
+	if interval <= 0 {
+		return 0
+	}
+
+	n := atomic.AddUint64(&s.phases, 1)
+	//nolint:gosec // jitter does not need crypto rand
+	frac := math.Mod(float64(n)*goldenRatio, 1) + (rand.Float64()*2-1)*phaseJitter
+	frac = math.Max(0, math.Min(frac, 1))
+
+	phase := time.Duration(frac * float64(interval))
+	logger.Default().Debug("scheduler phase", "phase", phase, "interval", interval)
+
+	return phase
+}
@@ -0,0 +1,137 @@
+package scheduler_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/crawler/scheduler"
+)
+
+func TestWaitPriority(t *testing.T) {
+	const rpm = 600 // 100ms between requests
+
+	fake := clock.NewFake(time.Now())
+	s := scheduler.New(scheduler.Config{RequestsPerMinute: rpm, Clock: fake})
+
+	// occupy the first slot
+	if err := s.Wait(context.Background(), "krisha.kz", 0); err != nil {
+		t.Fatalf("failed to wait, got error %v", err)
+	}
+
+	granted := make(chan int)
+	for _, priority := range []int{2, 0, 1} {
+		go func(priority int) {
+			if err := s.Wait(context.Background(), "krisha.kz", priority); err != nil {
+				t.Errorf("failed to wait, got error %v", err)
+			}
+
+			granted <- priority
+		}(priority)
+	}
+
+	// all requests are queued while dispatcher waits for the next slot
+	fake.BlockUntil(1)
+	for s.Pending("krisha.kz") != 3 {
+		time.Sleep(time.Millisecond)
+	}
+
+	got := make([]int, 0, 3)
+	for i := 0; i < 3; i++ {
+		fake.BlockUntil(1)
+
+		select {
+		case priority := <-granted:
+			t.Fatalf("want requests spread by budget, got %d granted before the slot", priority)
+		default:
+		}
+
+		fake.Advance(100 * time.Millisecond)
+		got = append(got, <-granted)
+	}
+
+	if got[0] != 0 || got[1] != 1 || got[2] != 2 {
+		t.Errorf("want requests granted by priority [0 1 2], got %v", got)
+	}
+}
+
+func TestWaitCanceled(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+	s := scheduler.New(scheduler.Config{RequestsPerMinute: 1, Clock: fake})
+
+	if err := s.Wait(context.Background(), "krisha.kz", 0); err != nil {
+		t.Fatalf("failed to wait, got error %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		// cancel while dispatcher waits for the next slot
+		fake.BlockUntil(1)
+		cancel()
+	}()
+
+	if err := s.Wait(ctx, "krisha.kz", 0); err == nil {
+		t.Errorf("want canceled wait, got granted")
+	}
+
+	// other hosts have own budget
+	if err := s.Wait(context.Background(), "example.com", 0); err != nil {
+		t.Errorf("want granted request to other host, got error %v", err)
+	}
+}
+
+// Context canceled once the request to the host has been granted, so cancel races with the grant.
+type grantedContext struct {
+	context.Context
+	s *scheduler.Scheduler
+}
+
+func (ctx grantedContext) Done() <-chan struct{} {
+	for ctx.s.Pending("krisha.kz") != 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	done := make(chan struct{})
+	close(done)
+	return done
+}
+
+func (ctx grantedContext) Err() error {
+	return context.Canceled
+}
+
+func TestWaitCanceledGranted(t *testing.T) {
+	fake := clock.NewFake(time.Now())
+
+	for i := 0; i < 100; i++ {
+		s := scheduler.New(scheduler.Config{RequestsPerMinute: 1, Clock: fake})
+
+		if err := s.Wait(grantedContext{context.Background(), s}, "krisha.kz", 0); err == nil {
+			continue
+		}
+
+		// slot of canceled wait is not wasted
+		granted := make(chan error, 1)
+		go func() { granted <- s.Wait(context.Background(), "krisha.kz", 0) }()
+		select {
+		case err := <-granted:
+			if err != nil {
+				t.Fatalf("failed to wait, got error %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("want slot of canceled wait granted to the next one")
+		}
+	}
+}
+
+func TestPhase(t *testing.T) {
+	s := scheduler.New(scheduler.Config{})
+
+	interval := 10 * time.Minute
+	for i := 0; i < 100; i++ {
+		if phase := s.Phase(interval); phase < 0 || phase > interval {
+			t.Errorf("want phase within interval %s, got %s", interval, phase)
+		}
+	}
+}
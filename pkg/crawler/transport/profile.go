@@ -0,0 +1,66 @@
+package transport
+
+import (
+	"net/http"
+)
+
+// Headers sent by a browser.
+type Profile map[string]string
+
+// Sets profile headers missing in the given ones, headers set by a caller are kept.
+func (p Profile) apply(header http.Header) {
+	for k, v := range p {
+		if header.Get(k) == "" {
+			header.Set(k, v)
+		}
+	}
+}
+
+// Returns headers of popular desktop browsers.
+func DefaultProfiles() []Profile {
+	const (
+		acceptHTML = "text/html,application/xhtml+xml,application/xml;q=0.9,image/avif,image/webp,*/*;q=0.8"
+		acceptLang = "ru-RU,ru;q=0.9,en-US;q=0.8,en;q=0.7"
+	)
+
+	return []Profile{
+		{
+			"User-Agent":      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:119.0) Gecko/20100101 Firefox/119.0",
+			"Accept":          acceptHTML,
+			"Accept-Language": acceptLang,
+			"Sec-Fetch-Dest":  "document",
+			"Sec-Fetch-Mode":  "navigate",
+			"Sec-Fetch-Site":  "none",
+		},
+		{
+			"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) " +
+				"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36",
+			"Accept":             acceptHTML,
+			"Accept-Language":    acceptLang,
+			"Sec-Ch-Ua":          `"Google Chrome";v="119", "Chromium";v="119", "Not?A_Brand";v="24"`,
+			"Sec-Ch-Ua-Mobile":   "?0",
+			"Sec-Ch-Ua-Platform": `"Windows"`,
+			"Sec-Fetch-Dest":     "document",
+			"Sec-Fetch-Mode":     "navigate",
+			"Sec-Fetch-Site":     "none",
+		},
+		{
+			"User-Agent": "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) " +
+				"AppleWebKit/605.1.15 (KHTML, like Gecko) Version/17.1 Safari/605.1.15",
+			"Accept":          "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8",
+			"Accept-Language": "ru-RU,ru;q=0.9",
+		},
+		{
+			"User-Agent": "Mozilla/5.0 (Windows NT 10.0; Win64; x64) " +
+				"AppleWebKit/537.36 (KHTML, like Gecko) Chrome/119.0.0.0 Safari/537.36 Edg/119.0.0.0",
+			"Accept":             acceptHTML,
+			"Accept-Language":    acceptLang,
+			"Sec-Ch-Ua":          `"Microsoft Edge";v="119", "Chromium";v="119", "Not?A_Brand";v="24"`,
+			"Sec-Ch-Ua-Mobile":   "?0",
+			"Sec-Ch-Ua-Platform": `"Windows"`,
+			"Sec-Fetch-Dest":     "document",
+			"Sec-Fetch-Mode":     "navigate",
+			"Sec-Fetch-Site":     "none",
+		},
+	}
+}
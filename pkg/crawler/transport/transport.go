@@ -0,0 +1,272 @@
+package transport
+
+import (
+	"context"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/logger"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMaxFailures         = 3
+	DefaultHealthCheckURL      = "https://krisha.kz/"
+	DefaultHealthCheckInterval = 5 * time.Minute
+	DefaultHealthCheckTimeout  = 15 * time.Second
+)
+
+var ErrNoProxy = errors.New("no healthy proxy")
+
+// Outbound transport config.
+type Config struct {
+	Proxies             []string      // http, https or socks5 proxy urls, direct connection if empty
+	MaxFailures         int           // consecutive failures to evict a proxy
+	HealthCheckURL      string        // url to check evicted proxies
+	HealthCheckInterval time.Duration // interval of health checks
+	Profiles            []Profile     // browser headers, DefaultProfiles if empty
+	Clock               clock.Clock   // system clock if nil
+}
+
+// Outbound proxy, nil url for direct connection.
+type proxy struct {
+	url       *url.URL
+	transport *http.Transport
+	failures  int
+	healthy   bool
+}
+
+func (p *proxy) String() string {
+	if p.url == nil {
+		return "direct"
+	}
+
+	return p.url.Redacted()
+}
+
+// Http transport sending requests through a pool of proxies with rotating browser headers.
+// Proxies failing MaxFailures times in a row are evicted until a health check succeeds.
+type Transport struct {
+	config   Config
+	proxies  []*proxy
+	next     uint64
+	profiles uint64
+	mx       sync.Mutex
+}
+
+// Creates transport by the given config.
+func New(config Config) (*Transport, error) {
+	if config.MaxFailures <= 0 {
+		config.MaxFailures = DefaultMaxFailures
+	}
+	if config.HealthCheckURL == "" {
+		config.HealthCheckURL = DefaultHealthCheckURL
+	}
+	if config.HealthCheckInterval <= 0 {
+		config.HealthCheckInterval = DefaultHealthCheckInterval
+	}
+	if len(config.Profiles) == 0 {
+		config.Profiles = DefaultProfiles()
+	}
+	config.Clock = clock.OrDefault(config.Clock)
+
+	t := &Transport{config: config}
+
+	for _, raw := range config.Proxies {
+		raw = strings.TrimSpace(raw)
+		if raw == "" {
+			continue
+		}
+
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, errors.WithMessagef(err, "proxy %s", raw)
+		}
+
+		switch u.Scheme {
+		case "http", "https", "socks5":
+		default:
+			return nil, errors.Errorf("unsupported proxy scheme %s", u.Scheme)
+		}
+
+		t.proxies = append(t.proxies, newProxy(u))
+	}
+
+	if len(t.proxies) == 0 {
+		t.proxies = append(t.proxies, newProxy(nil))
+	}
+
+	return t, nil
+}
+
+func newProxy(u *url.URL) *proxy {
+	transport := &http.Transport{Proxy: http.ProxyFromEnvironment}
+	if base, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport = base.Clone()
+	}
+	if u != nil {
+		transport.Proxy = http.ProxyURL(u)
+	}
+
+	return &proxy{
+		url:       u,
+		transport: transport,
+		healthy:   true,
+	}
+}
+
+// Implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	p, err := t.pick()
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.Clone(req.Context())
+	profile := t.config.Profiles[atomic.AddUint64(&t.profiles, 1)%uint64(len(t.config.Profiles))]
+	profile.apply(req.Header)
+
+	resp, err := p.transport.RoundTrip(req)
+	t.report(p, resp, err)
+
+	return resp, err
+}
+
+// Returns the next healthy proxy in round robin order.
+func (t *Transport) pick() (*proxy, error) {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	for i := 0; i < len(t.proxies); i++ {
+		t.next++
+		p := t.proxies[t.next%uint64(len(t.proxies))]
+		if p.healthy {
+			return p, nil
+		}
+	}
+
+	return nil, ErrNoProxy
+}
+
+// Counts failures of the proxy and evicts it on threshold.
+func (t *Transport) report(p *proxy, resp *http.Response, err error) {
+	failed := err != nil
+	if resp != nil {
+		switch resp.StatusCode {
+		case http.StatusForbidden, http.StatusProxyAuthRequired, http.StatusTooManyRequests, http.StatusBadGateway:
+			failed = true
+		}
+	}
+
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	if !failed {
+		p.failures = 0
+		return
+	}
+
+	p.failures++
+	// direct connection is never evicted
+	if p.url != nil && p.healthy && p.failures >= t.config.MaxFailures {
+		p.healthy = false
+		logger.Default().Warn("proxy evicted", "proxy", p, "failures", p.failures, logger.Err, err)
+	}
+}
+
+// Returns number of healthy proxies.
+func (t *Transport) Healthy() int {
+	t.mx.Lock()
+	defer t.mx.Unlock()
+
+	cnt := 0
+	for _, p := range t.proxies {
+		if p.healthy {
+			cnt++
+		}
+	}
+
+	return cnt
+}
+
+// Asynchronously checks evicted proxies by interval until context is done.
+func (t *Transport) StartHealthCheck(ctx context.Context) {
+	go func() {
+		for {
+			if err := clock.Sleep(ctx, t.config.Clock, t.config.HealthCheckInterval); err != nil {
+				logger.FromContext(ctx).Info("stopping proxy health check")
+				return
+			}
+
+			t.CheckHealth(ctx)
+		}
+	}()
+}
+
+// Checks evicted proxies and returns healthy ones back to the pool.
+func (t *Transport) CheckHealth(ctx context.Context) {
+	t.mx.Lock()
+	var evicted []*proxy
+	for _, p := range t.proxies {
+		if !p.healthy {
+			evicted = append(evicted, p)
+		}
+	}
+	t.mx.Unlock()
+
+	for _, p := range evicted {
+		err := t.check(ctx, p)
+
+		t.mx.Lock()
+		if err == nil {
+			p.healthy = true
+			p.failures = 0
+			logger.FromContext(ctx).Info("proxy is healthy", "proxy", p)
+		} else {
+			logger.FromContext(ctx).Warn("proxy is unhealthy", "proxy", p, logger.Err, err)
+		}
+		t.mx.Unlock()
+	}
+}
+
+func (t *Transport) check(ctx context.Context, p *proxy) error {
+	ctx, cancel := context.WithTimeout(ctx, DefaultHealthCheckTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, t.config.HealthCheckURL, http.NoBody)
+	if err != nil {
+		return err
+	}
+	t.config.Profiles[0].apply(req.Header)
+
+	resp, err := p.transport.RoundTrip(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError ||
+		resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusProxyAuthRequired {
+		return errors.Errorf("Error: %d - %s", resp.StatusCode, resp.Status)
+	}
+
+	return nil
+}
+
+// Returns http client with the given transport and cookie jar.
+func NewClient(rt http.RoundTripper) *http.Client {
+	// cookiejar.New never fails without options
+	jar, _ := cookiejar.New(nil)
+
+	return &http.Client{
+		Transport: rt,
+		Jar:       jar,
+	}
+}
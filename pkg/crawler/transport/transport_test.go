@@ -0,0 +1,146 @@
+package transport_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/crawler/transport"
+)
+
+func TestTransport(t *testing.T) {
+	// proxy replies on behalf of the target with the user agent it received
+	good := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.WriteString(w, r.Header.Get("User-Agent")); err != nil {
+			t.Errorf("failed to write response, got error %v", err)
+		}
+	}))
+	defer good.Close()
+
+	bad := httptest.NewServer(http.NotFoundHandler())
+	bad.Close()
+
+	profiles := []transport.Profile{{"User-Agent": "browser-1"}, {"User-Agent": "browser-2"}}
+	rt, err := transport.New(transport.Config{
+		Proxies:        []string{good.URL, bad.URL},
+		MaxFailures:    2,
+		HealthCheckURL: "http://krisha.kz/",
+		Profiles:       profiles,
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport, got error %v", err)
+	}
+
+	client := transport.NewClient(rt)
+	agents := map[string]bool{}
+	failures := 0
+
+	for i := 0; i < 6; i++ {
+		resp, err := client.Get("http://krisha.kz/arenda/kvartiry/almaty/")
+		if err != nil {
+			failures++
+			continue
+		}
+
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		agents[string(body)] = true
+	}
+
+	if failures != 2 || rt.Healthy() != 1 {
+		t.Errorf("want failing proxy evicted after 2 failures, got %d failures, %d healthy", failures, rt.Healthy())
+	}
+
+	if !agents["browser-1"] || !agents["browser-2"] {
+		t.Errorf("want rotated user agents, got %v", agents)
+	}
+
+	// evicted proxy stays evicted while unreachable
+	rt.CheckHealth(context.Background())
+	if rt.Healthy() != 1 {
+		t.Errorf("want unreachable proxy evicted, got %d healthy", rt.Healthy())
+	}
+}
+
+func TestTransportKeepsCallerHeaders(t *testing.T) {
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.WriteString(w, r.Header.Get("User-Agent")+";"+r.Header.Get("Accept-Language")); err != nil {
+			t.Errorf("failed to write response, got error %v", err)
+		}
+	}))
+	defer proxy.Close()
+
+	rt, err := transport.New(transport.Config{
+		Proxies:  []string{proxy.URL},
+		Profiles: []transport.Profile{{"User-Agent": "browser", "Accept-Language": "ru-RU"}},
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport, got error %v", err)
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, "http://krisha.kz/arenda/kvartiry/almaty/", http.NoBody)
+	req.Header.Set("User-Agent", "caller")
+	resp, err := transport.NewClient(rt).Do(req)
+	if err != nil {
+		t.Fatalf("failed to send request, got error %v", err)
+	}
+	defer resp.Body.Close()
+
+	if body, _ := io.ReadAll(resp.Body); string(body) != "caller;ru-RU" {
+		t.Errorf("want caller user agent and profile language, got %s", body)
+	}
+}
+
+func TestStartHealthCheck(t *testing.T) {
+	var status int32 = http.StatusForbidden
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer proxy.Close()
+
+	fake := clock.NewFake(time.Now())
+	rt, err := transport.New(transport.Config{
+		Proxies:             []string{proxy.URL},
+		MaxFailures:         1,
+		HealthCheckURL:      "http://krisha.kz/",
+		HealthCheckInterval: time.Minute,
+		Clock:               fake,
+	})
+	if err != nil {
+		t.Fatalf("failed to create transport, got error %v", err)
+	}
+
+	resp, err := transport.NewClient(rt).Get("http://krisha.kz/arenda/kvartiry/almaty/")
+	if err != nil {
+		t.Fatalf("failed to send request, got error %v", err)
+	}
+	resp.Body.Close()
+	if rt.Healthy() != 0 {
+		t.Fatalf("want blocked proxy evicted, got %d healthy", rt.Healthy())
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	rt.StartHealthCheck(ctx)
+
+	// proxy is restored by the check on interval of the clock
+	atomic.StoreInt32(&status, http.StatusOK)
+	fake.BlockUntil(1)
+	fake.Advance(time.Minute)
+	for deadline := time.Now().Add(5 * time.Second); rt.Healthy() != 1; time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("want proxy restored by health check")
+		}
+	}
+}
+
+func TestTransportUnsupportedProxy(t *testing.T) {
+	if _, err := transport.New(transport.Config{Proxies: []string{"ftp://proxy:21"}}); err == nil {
+		t.Errorf("want error on unsupported proxy scheme")
+	}
+}
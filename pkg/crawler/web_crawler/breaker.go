@@ -0,0 +1,187 @@
+package webcrawler
+
+import (
+	"sync"
+	"time"
+
+	"krisha_kz_bot/pkg/logger"
+)
+
+const (
+	DefaultBreakerThreshold  = 5
+	DefaultBreakerOpenPeriod = time.Minute
+	DefaultBreakerMaxPeriod  = 30 * time.Minute
+)
+
+type breakerState int
+
+const (
+	closed breakerState = iota
+	open
+	halfOpen
+)
+
+func (state breakerState) String() string {
+	return [...]string{"closed", "open", "half-open"}[state]
+}
+
+// Circuit breaker config.
+type BreakerConfig struct {
+	Threshold  int           // consecutive failures to open the breaker
+	OpenPeriod time.Duration // period of the first opening, doubled on each failed probe
+	MaxPeriod  time.Duration // max period of opening
+}
+
+// Circuit breaker of a host.
+// Opens after consecutive failures and rejects requests for a period,
+// then lets a single probe request through to close it again.
+// The probe shall end with Success, Failure or Release, another probe is let through after OpenPeriod anyway.
+type Breaker struct {
+	host     string
+	config   BreakerConfig
+	state    breakerState
+	failures int
+	period   time.Duration
+	until    time.Time // end of opening, deadline of the probe while half-open
+	mx       sync.Mutex
+}
+
+// Returns true if a request may be sent now, probe is true if the request is the single probe of the breaker.
+func (b *Breaker) Allow(now time.Time) (ok, probe bool) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	switch b.state {
+	case closed:
+		return true, false
+	case open, halfOpen:
+		// probe is in flight
+		if now.Before(b.until) {
+			return false, false
+		}
+		// let a single probe through, e.g. after the previous one has been lost
+		b.state = halfOpen
+		b.until = now.Add(b.config.OpenPeriod)
+		logger.Default().Info("circuit breaker state changed", "host", b.host, "state", b.state, "until", b.until)
+		return true, true
+	}
+
+	return true, false
+}
+
+// Releases the probe ended without outcome, e.g. on cancel, so another probe is let through.
+func (b *Breaker) Release(now time.Time) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state == halfOpen {
+		b.state = open
+		b.until = now
+	}
+}
+
+// Closes the breaker after a successful request.
+func (b *Breaker) Success() {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state != closed {
+		logger.Default().Info("circuit breaker state changed", "host", b.host, "state", closed)
+	}
+
+	b.state = closed
+	b.failures = 0
+	b.period = 0
+}
+
+// Counts a failed request and opens the breaker on threshold or failed probe.
+func (b *Breaker) Failure(now time.Time) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.failures++
+	if b.state == halfOpen || b.failures >= b.config.Threshold {
+		b.open(now, 0)
+	}
+}
+
+// Opens the breaker for at least the given period, e.g. requested by Retry-After.
+func (b *Breaker) Trip(now time.Time, period time.Duration) {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	b.open(now, period)
+}
+
+// Returns time till the breaker rejects requests, till the probe deadline while half-open.
+func (b *Breaker) OpenUntil() time.Time {
+	b.mx.Lock()
+	defer b.mx.Unlock()
+
+	if b.state == closed {
+		return time.Time{}
+	}
+
+	return b.until
+}
+
+func (b *Breaker) open(now time.Time, atLeast time.Duration) {
+	switch {
+	case b.period == 0:
+		b.period = b.config.OpenPeriod
+	case b.state != closed:
+		b.period *= 2
+	}
+	if b.period > b.config.MaxPeriod {
+		b.period = b.config.MaxPeriod
+	}
+	if atLeast > b.period {
+		b.period = atLeast
+	}
+
+	b.state = open
+	b.until = now.Add(b.period)
+
+	logger.Default().Warn("circuit breaker state changed", "host", b.host, "state", b.state, "until", b.until)
+}
+
+// Circuit breakers per host shared between crawlers.
+type Breakers struct {
+	config   BreakerConfig
+	breakers map[string]*Breaker
+	mx       sync.Mutex
+}
+
+func NewBreakers(config BreakerConfig) *Breakers {
+	if config.Threshold <= 0 {
+		config.Threshold = DefaultBreakerThreshold
+	}
+	if config.OpenPeriod <= 0 {
+		config.OpenPeriod = DefaultBreakerOpenPeriod
+	}
+	if config.MaxPeriod <= 0 {
+		config.MaxPeriod = DefaultBreakerMaxPeriod
+	}
+	if config.MaxPeriod < config.OpenPeriod {
+		config.MaxPeriod = config.OpenPeriod
+	}
+
+	return &Breakers{
+		config:   config,
+		breakers: make(map[string]*Breaker),
+	}
+}
+
+// Returns circuit breaker of the host.
+func (bs *Breakers) Get(host string) *Breaker {
+	bs.mx.Lock()
+	defer bs.mx.Unlock()
+
+	b, found := bs.breakers[host]
+	if !found {
+		b = &Breaker{host: host, config: bs.config}
+		bs.breakers[host] = b
+	}
+
+	return b
+}
@@ -0,0 +1,74 @@
+package webcrawler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	ErrBlocked     = errors.New("blocked by host")
+	ErrCircuitOpen = errors.New("circuit breaker is open")
+)
+
+// Unexpected http status of a response.
+type StatusError struct {
+	Code       int
+	Status     string
+	RetryAfter time.Duration // value of Retry-After header, 0 if absent
+}
+
+func newStatusError(resp *http.Response, now time.Time) *StatusError {
+	return &StatusError{
+		Code:       resp.StatusCode,
+		Status:     resp.Status,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"), now),
+	}
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("Error: %d - %s", e.Code, e.Status)
+}
+
+// Allows errors.Is(err, ErrBlocked) when host refuses to serve requests.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrBlocked && e.IsBlocked()
+}
+
+// Returns true if host refuses to serve requests, e.g. rate limited.
+func (e *StatusError) IsBlocked() bool {
+	switch e.Code {
+	case http.StatusForbidden, http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	}
+
+	return false
+}
+
+// Returns true if request might succeed later.
+func (e *StatusError) IsTemporary() bool {
+	return e.Code == http.StatusTooManyRequests || e.Code >= http.StatusInternalServerError
+}
+
+// Parses Retry-After header given either in seconds or as http date.
+func parseRetryAfter(value string, now time.Time) time.Duration {
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil {
+		if seconds < 0 {
+			return 0
+		}
+		return time.Duration(seconds) * time.Second
+	}
+
+	if dt, err := http.ParseTime(value); err == nil && dt.After(now) {
+		return dt.Sub(now)
+	}
+
+	return 0
+}
@@ -0,0 +1,80 @@
+package webcrawler
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"time"
+
+	"krisha_kz_bot/pkg/crawler/transport"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultMaxRetries    = 3
+	DefaultBaseDelay     = 2 * time.Second
+	DefaultMaxDelay      = time.Minute
+	DefaultMaxRetryAfter = 5 * time.Minute
+)
+
+// Retry policy of a failed request.
+// Zero policy is replaced by the default one.
+type RetryPolicy struct {
+	MaxRetries    int           // retries after the first attempt
+	BaseDelay     time.Duration // delay before the first retry, doubled on each next one
+	MaxDelay      time.Duration // max delay between retries
+	MaxRetryAfter time.Duration // Retry-After greater than it trips the circuit breaker instead of waiting
+}
+
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxRetries:    DefaultMaxRetries,
+		BaseDelay:     DefaultBaseDelay,
+		MaxDelay:      DefaultMaxDelay,
+		MaxRetryAfter: DefaultMaxRetryAfter,
+	}
+}
+
+func (p RetryPolicy) orDefault() RetryPolicy {
+	if p == (RetryPolicy{}) {
+		return DefaultRetryPolicy()
+	}
+
+	return p
+}
+
+// Returns exponential delay with full jitter before the given retry, starting from 0.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.MaxDelay
+	if retry < 32 && p.BaseDelay<<retry > 0 && p.BaseDelay<<retry < p.MaxDelay {
+		delay = p.BaseDelay << retry
+	}
+
+	if delay <= 0 {
+		return 0
+	}
+
+	//nolint:gosec // jitter does not need crypto rand
+	return time.Duration(rand.Int63n(int64(delay)) + 1)
+}
+
+// Returns true if request failed with the given error might succeed later.
+func isRetryable(err error) bool {
+	// proxies are out of the host control, it is not worth retrying till health check restores them
+	if errors.Is(err, transport.ErrNoProxy) {
+		return false
+	}
+
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.IsTemporary()
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, ErrCircuitOpen) {
+		return false
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
@@ -1,34 +1,93 @@
 package webcrawler
 
 import (
+	"bytes"
 	"context"
-	"log"
+	"crypto/sha256"
+	"io"
 	"net/http"
+	"net/url"
+	"sync"
 	"sync/atomic"
 	"time"
 
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/crawler"
+	"krisha_kz_bot/pkg/crawler/httpcache"
+	"krisha_kz_bot/pkg/crawler/transport"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/metrics"
 	"krisha_kz_bot/pkg/parser"
+	"krisha_kz_bot/pkg/tracing"
 
 	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel/attribute"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
-	scannerPagesDelay = 30 * time.Second
+	DefaultPageDelay   = 30 * time.Second
+	DefaultContentTTL  = 30 * time.Minute
+	DefaultParallelism = 1
 )
 
+// Handler of crawled page with number of parsed results or error.
+type CrawledFunc func(url string, cnt int, err error)
+
+// Scheduler of requests shared between crawlers.
+type Scheduler interface {
+	// Blocks until a request to the host is granted, lower priority value is granted first.
+	Wait(ctx context.Context, host string, priority int) error
+	// Returns offset of the first periodic crawl for the given interval.
+	Phase(interval time.Duration) time.Duration
+}
+
 // Web crawler config with parser.
 type Config[Result any] struct {
-	Interval time.Duration
-	Parser   parser.Parser[Result]
+	Interval    time.Duration
+	Parser      parser.Parser[Result]
+	Parallelism int            // pages crawled concurrently, DefaultParallelism if not positive
+	PageDelay   time.Duration  // delay between pages crawled by the same worker
+	Retry       RetryPolicy    // default policy if zero
+	Breakers    *Breakers      // not mandatory, shared between crawlers to back off blocking hosts
+	Scheduler   Scheduler      // not mandatory, shared between crawlers to limit requests per host
+	ContentTTL  time.Duration  // results of unchanged page are reused within ttl and the same day, 0 to parse each page
+	Location    *time.Location // time zone of the day of parsed results, e.g. dates relative to today, UTC if nil
+	OnCrawled   CrawledFunc    // not mandatory
+	Clock       clock.Clock    // system clock if nil
+}
+
+// Parsed page cached by content hash.
+type page[Result any] struct {
+	hash    [sha256.Size]byte
+	results []Result
+	parsed  time.Time
 }
 
 // Web site crawler daemon.
 type WebCrawler[Result any] struct {
 	config  *Config[Result]
+	retry   RetryPolicy
+	clock   clock.Clock
 	urls    []string
+	urlsMx  sync.RWMutex
 	client  *http.Client
 	stop    context.CancelFunc
+	trigger chan struct{}
 	counter uint64
+	cycles  uint64
+
+	interval   int64 // nanoseconds between periodic crawls, changed by SetInterval
+	reschedule chan struct{}
+
+	pages   map[string]*page[Result]
+	pagesMx sync.Mutex
+
+	requests    uint64
+	notModified uint64
+	cacheHits   uint64
+	cacheMisses uint64
 }
 
 // Returns new Crawler daemon with given interval for crawling.
@@ -37,56 +96,81 @@ func NewCrawler[Result any](config *Config[Result], urls []string, client *http.
 		client = http.DefaultClient
 	}
 	return &WebCrawler[Result]{
-		config: config,
-		urls:   urls,
-		client: client,
+		config:  config,
+		retry:   config.Retry.orDefault(),
+		clock:   clock.OrDefault(config.Clock),
+		urls:    urls,
+		client:  client,
+		pages:   make(map[string]*page[Result]),
+		trigger: make(chan struct{}, 1),
+
+		interval:   int64(config.Interval),
+		reschedule: make(chan struct{}, 1),
 	}
 }
 
-// Starts Crawler daemon asynchronously and returns result channel.
-func (c *WebCrawler[Result]) Start(ctx context.Context) <-chan Result {
-	result := make(chan Result)
+// Starts Crawler daemon asynchronously and returns channel of crawl cycle results.
+func (c *WebCrawler[Result]) Start(ctx context.Context) <-chan crawler.CrawlResult[Result] {
+	result := make(chan crawler.CrawlResult[Result])
 	ctx, cancel := context.WithCancel(ctx)
 	c.stop = cancel
 
-	go func(ctx context.Context, result chan<- Result) {
+	go func(ctx context.Context, result chan<- crawler.CrawlResult[Result]) {
 		// close result channel after crawler interupped from upstream
 		defer close(result)
 
-		doCrawl := func(ctx context.Context, urls []string, result chan<- Result) {
-			// send results immediately without waiting of timer
-			for _, url := range urls {
-				if err := c.DoCrawl(ctx, url, result); err != nil {
-					log.Printf("failed to crawl resource %s, error %+v\n", url, err)
-				}
+		// send results immediately without waiting of timer
+		c.crawlPages(ctx, result)
 
-				// sleep before next call
-				if len(urls) > 1 {
-					time.Sleep(scannerPagesDelay)
-				}
-			}
+		// spread periodic crawls of crawlers sharing scheduler
+		interval := c.getInterval()
+		firstInterval := interval
+		if c.config.Scheduler != nil {
+			firstInterval += c.config.Scheduler.Phase(interval)
 		}
-		// send results immediately without waiting of timer
-		doCrawl(ctx, c.urls, result)
 
-		retryTimer := time.NewTimer(c.config.Interval)
+		retryTimer := c.clock.NewTimer(firstInterval)
+		// time the pending crawl is due
+		due := c.clock.Now().Add(firstInterval)
 		// stop retry timer after crawler interupped from upstream
 		defer retryTimer.Stop()
 		for {
 			select {
 			case <-ctx.Done():
 				// operation interuppted from upstream
-				log.Printf("stopping crawler\n")
+				logger.FromContext(ctx).Info("stopping crawler")
 				return
 
-			case <-retryTimer.C:
-				doCrawl(ctx, c.urls, result)
+			case <-retryTimer.C():
+				c.crawlPages(ctx, result)
 
 				// increase counter
 				atomic.AddUint64(&c.counter, 1)
 
 				// reset timer
-				retryTimer.Reset(c.config.Interval)
+				interval = c.getInterval()
+				retryTimer.Reset(interval)
+				due = c.clock.Now().Add(interval)
+
+			case <-c.trigger:
+				c.crawlPages(ctx, result)
+
+			case <-c.reschedule:
+				// the pending crawl is moved by difference of intervals, overdue one starts immediately
+				next := c.getInterval()
+				if next == interval {
+					continue
+				}
+
+				if !retryTimer.Stop() {
+					select {
+					case <-retryTimer.C():
+					default:
+					}
+				}
+				due = due.Add(next - interval)
+				interval = next
+				retryTimer.Reset(due.Sub(c.clock.Now()))
 			}
 		}
 	}(ctx, result)
@@ -94,37 +178,424 @@ func (c *WebCrawler[Result]) Start(ctx context.Context) <-chan Result {
 	return result
 }
 
+// Implements crawler.Triggerable.
+func (c *WebCrawler[Result]) CrawlNow() bool {
+	select {
+	case c.trigger <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+// Implements crawler.Reschedulable.
+func (c *WebCrawler[Result]) SetInterval(interval time.Duration) {
+	atomic.StoreInt64(&c.interval, int64(interval))
+
+	select {
+	case c.reschedule <- struct{}{}:
+	default:
+	}
+}
+
+func (c *WebCrawler[Result]) getInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&c.interval))
+}
+
+// Implements crawler.Retargetable.
+func (c *WebCrawler[Result]) SetURLs(urls []string) {
+	c.urlsMx.Lock()
+	c.urls = urls
+	c.urlsMx.Unlock()
+
+	// forget cached pages no longer crawled
+	keep := make(map[string]struct{}, len(urls))
+	for _, u := range urls {
+		keep[u] = struct{}{}
+	}
+
+	c.pagesMx.Lock()
+	defer c.pagesMx.Unlock()
+
+	for u := range c.pages {
+		if _, found := keep[u]; !found {
+			delete(c.pages, u)
+		}
+	}
+}
+
+func (c *WebCrawler[Result]) getURLs() []string {
+	c.urlsMx.RLock()
+	defer c.urlsMx.RUnlock()
+
+	return c.urls
+}
+
 // Stops Crawler daemon.
 func (c *WebCrawler[Result]) Stop() {
 	c.stop()
 }
 
+// Crawls pages by a bounded pool of workers and sends results of the cycle in page order.
+// Remaining pages are skipped when host refuses to serve.
+func (c *WebCrawler[Result]) crawlPages(ctx context.Context, result chan<- crawler.CrawlResult[Result]) {
+	start := c.clock.Now()
+	urls := c.getURLs()
+	cycle := atomic.AddUint64(&c.cycles, 1)
+	log := logger.FromContext(ctx).With(logger.Cycle, cycle)
+	ctx = logger.WithContext(ctx, log)
+
+	ctx, span := tracer().Start(ctx, "crawler.cycle", trace.WithAttributes(
+		tracing.Cycle.Int64(int64(cycle)),
+		attribute.Int("crawl.pages", len(urls)),
+	))
+	pages := make([][]Result, len(urls))
+	errs := make([]error, len(urls))
+	crawled := make([]bool, len(urls))
+
+	cycleCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	workers := c.config.Parallelism
+	if workers <= 0 {
+		workers = DefaultParallelism
+	}
+	if workers > len(urls) {
+		workers = len(urls)
+	}
+
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	wg.Add(workers)
+
+	for w := 0; w < workers; w++ {
+		go func() {
+			defer wg.Done()
+
+			first := true
+			for i := range indexes {
+				// sleep before next call of the worker
+				if !first && c.config.PageDelay > 0 {
+					if err := clock.Sleep(cycleCtx, c.clock, c.config.PageDelay); err != nil {
+						return
+					}
+				}
+				first = false
+
+				pages[i], errs[i] = c.crawlPage(cycleCtx, urls[i])
+				crawled[i] = true
+
+				if errs[i] != nil {
+					log.Error("failed to crawl resource", logger.URL, urls[i], logger.Err, errs[i])
+
+					// no reason to crawl next pages when host refuses to serve
+					if errors.Is(errs[i], ErrBlocked) || errors.Is(errs[i], ErrCircuitOpen) {
+						cancel()
+					}
+				}
+			}
+		}()
+	}
+
+feed:
+	for i := range urls {
+		select {
+		case indexes <- i:
+		case <-cycleCtx.Done():
+			break feed
+		}
+	}
+	close(indexes)
+	wg.Wait()
+
+	res := crawler.CrawlResult[Result]{
+		At:       start,
+		Duration: clock.Since(c.clock, start),
+		Trace:    span.SpanContext(),
+	}
+	for i, results := range pages {
+		switch {
+		case errs[i] != nil:
+			res.Errors = append(res.Errors, errs[i])
+		case !crawled[i]:
+			res.Errors = append(res.Errors, errors.Errorf("skipped resource %s", urls[i]))
+		default:
+			res.Pages++
+			res.Items = append(res.Items, results...)
+		}
+	}
+
+	span.SetAttributes(attribute.Int("crawl.results", len(res.Items)), attribute.Int("crawl.errors", len(res.Errors)))
+	span.End()
+
+	select {
+	case result <- res:
+	case <-ctx.Done():
+	}
+}
+
 // Loads resource payload, parses and sends result to the given channel.
+// Temporary failures are retried with exponential backoff,
+// the host circuit breaker rejects requests while host is blocking.
 func (c *WebCrawler[Result]) DoCrawl(ctx context.Context, url string, resultCh chan<- Result) error {
+	results, err := c.crawlPage(ctx, url)
+	for _, val := range results {
+		resultCh <- val
+	}
+
+	return err
+}
+
+// Loads and parses the page, returns parsed results.
+func (c *WebCrawler[Result]) crawlPage(ctx context.Context, url string) ([]Result, error) {
+	ctx, span := tracer().Start(ctx, "crawler.page", trace.WithAttributes(semconv.HTTPURLKey.String(url)))
+	defer span.End()
+
+	var results []Result
+	err := c.doCrawl(ctx, url, func(val Result) {
+		results = append(results, val)
+	})
+
+	span.SetAttributes(attribute.Int("crawl.results", len(results)))
+	tracing.Fail(span, err)
+
+	if err == nil {
+		metrics.CardsParsed.Observe(float64(len(results)))
+	}
+
+	if c.config.OnCrawled != nil {
+		c.config.OnCrawled(url, len(results), err)
+	}
+
+	return results, err
+}
+
+func (c *WebCrawler[Result]) doCrawl(ctx context.Context, rawURL string, handler parser.HandlerFunc[Result]) error {
+	u, errURL := url.Parse(rawURL)
+	if errURL != nil {
+		return errURL
+	}
+
+	var breaker *Breaker
+	if c.config.Breakers != nil {
+		breaker = c.config.Breakers.Get(u.Host)
+	}
+
+	var (
+		probe  bool // the page holds the probe of half-open breaker until its outcome is recorded
+		failed bool // failures of the page are counted by breaker once
+	)
+	// probe ended without outcome is released, e.g. on cancel
+	defer func() {
+		if probe {
+			breaker.Release(c.clock.Now())
+		}
+	}()
+
+	for retry := 0; ; retry++ {
+		if c.config.Scheduler != nil {
+			if e := c.config.Scheduler.Wait(ctx, u.Host, c.priority(rawURL)); e != nil {
+				return e
+			}
+		}
+
+		if breaker != nil && !probe {
+			var ok bool
+			if ok, probe = breaker.Allow(c.clock.Now()); !ok {
+				return errors.WithMessagef(ErrCircuitOpen, "till %s", breaker.OpenUntil())
+			}
+		}
+
+		resp, err := c.do(ctx, rawURL)
+		if err == nil {
+			if breaker != nil {
+				breaker.Success()
+				probe = false
+			}
+
+			err = c.parse(ctx, rawURL, resp, handler)
+			resp.Body.Close()
+
+			return err
+		}
+
+		var statusErr *StatusError
+		if breaker != nil {
+			switch {
+			case errors.Is(err, transport.ErrNoProxy):
+				// request has not reached the host
+			case isRetryable(err) || errors.Is(err, ErrBlocked):
+				// failed probe opens breaker again
+				if probe || !failed {
+					breaker.Failure(c.clock.Now())
+				}
+				probe, failed = false, true
+			case errors.As(err, &statusErr):
+				// host has responded, e.g. with 404 Not Found
+				breaker.Success()
+				probe = false
+			}
+		}
+
+		delay := c.retry.backoff(retry)
+
+		if errors.As(err, &statusErr) && statusErr.RetryAfter > 0 {
+			// host asks to wait longer than worth waiting, back off all crawlers
+			if statusErr.RetryAfter > c.retry.MaxRetryAfter {
+				if breaker != nil {
+					breaker.Trip(c.clock.Now(), statusErr.RetryAfter)
+				}
+				return err
+			}
+
+			delay = statusErr.RetryAfter
+		}
+
+		if retry >= c.retry.MaxRetries || !isRetryable(err) {
+			return err
+		}
+
+		logger.FromContext(ctx).Warn("failed to crawl resource", logger.URL, rawURL, "retry", retry+1, "delay", delay, logger.Err, err)
+
+		if e := clock.Sleep(ctx, c.clock, delay); e != nil {
+			return err
+		}
+	}
+}
+
+// Parses response body, results of unchanged content are reused within ttl.
+func (c *WebCrawler[Result]) parse(ctx context.Context, url string, resp *http.Response, handler parser.HandlerFunc[Result]) (err error) {
+	_, span := tracer().Start(ctx, "crawler.parse")
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+
+	atomic.AddUint64(&c.requests, 1)
+	if resp.Header.Get(httpcache.HeaderCache) == httpcache.CacheRevalidated {
+		atomic.AddUint64(&c.notModified, 1)
+	}
+
+	if c.config.ContentTTL <= 0 {
+		atomic.AddUint64(&c.cacheMisses, 1)
+		return c.config.Parser.Parse(resp.Body, handler)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	hash := sha256.Sum256(body)
+	now := c.clock.Now()
+
+	c.pagesMx.Lock()
+	cached, found := c.pages[url]
+	c.pagesMx.Unlock()
+
+	if found && cached.hash == hash && now.Sub(cached.parsed) < c.config.ContentTTL && c.sameDay(cached.parsed, now) {
+		span.SetAttributes(attribute.Bool("crawl.cache_hit", true))
+		atomic.AddUint64(&c.cacheHits, 1)
+		for _, val := range cached.results {
+			handler(val)
+		}
+		return nil
+	}
+	atomic.AddUint64(&c.cacheMisses, 1)
+
+	var results []Result
+	if err = c.config.Parser.Parse(bytes.NewReader(body), func(val Result) {
+		results = append(results, val)
+		handler(val)
+	}); err != nil {
+		return err
+	}
+
+	c.pagesMx.Lock()
+	c.pages[url] = &page[Result]{hash: hash, results: results, parsed: now}
+	c.pagesMx.Unlock()
+
+	return nil
+}
+
+// Returns true if both times are within the same day, parsed results depend on the day.
+func (c *WebCrawler[Result]) sameDay(a, b time.Time) bool {
+	loc := c.config.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+
+	y1, m1, d1 := a.In(loc).Date()
+	y2, m2, d2 := b.In(loc).Date()
+
+	return y1 == y2 && m1 == m2 && d1 == d2
+}
+
+// Returns priority of the page, the first page has the highest one.
+func (c *WebCrawler[Result]) priority(url string) int {
+	urls := c.getURLs()
+	for i, u := range urls {
+		if u == url {
+			return i
+		}
+	}
+
+	return len(urls)
+}
+
+// Sends request and returns response with status 200 OK or error.
+func (c *WebCrawler[Result]) do(ctx context.Context, url string) (_ *http.Response, err error) {
+	ctx, span := tracer().Start(ctx, "crawler.fetch", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPMethodKey.String(http.MethodGet),
+		semconv.HTTPURLKey.String(url),
+	))
+	defer func() {
+		tracing.Fail(span, err)
+		span.End()
+	}()
+
 	// build request
 	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
 	if err != nil {
-		return err
+		return nil, err
 	}
+	// default headers, overridden by client transport with rotating browser headers
 	req.Header.Add("User-Agent", "Mozilla/5.0 (Macintosh; Intel Mac OS X 10.15; rv:106.0) Gecko/20100101 Firefox/106.0")
 	req.Header.Add("Accept", "text/html")
 
 	// do request
+	start := c.clock.Now()
 	resp, err := c.client.Do(req)
+	metrics.CrawlLatency.WithLabelValues(req.URL.Host).Observe(clock.Since(c.clock, start).Seconds())
 	if err != nil {
-		return err
+		metrics.CrawlRequests.WithLabelValues(req.URL.Host, metrics.CodeError).Inc()
+		return nil, err
 	}
-	defer resp.Body.Close()
+	metrics.CrawlRequests.WithLabelValues(req.URL.Host, metrics.Code(resp.StatusCode)).Inc()
+	span.SetAttributes(semconv.HTTPStatusCodeKey.Int(resp.StatusCode))
 
 	if resp.StatusCode != http.StatusOK {
-		return errors.Errorf("Error: %d - %s", resp.StatusCode, resp.Status)
+		resp.Body.Close()
+		return nil, newStatusError(resp, c.clock.Now())
 	}
 
-	return c.config.Parser.Parse(resp.Body, func(val Result) {
-		resultCh <- val
-	})
+	return resp, nil
+}
+
+func tracer() trace.Tracer {
+	return tracing.Tracer("pkg/crawler/web_crawler")
 }
 
 func (c *WebCrawler[Result]) GetCount() uint64 {
 	return atomic.LoadUint64(&c.counter)
 }
+
+// Implements crawler.StatsProvider.
+func (c *WebCrawler[Result]) GetStats() crawler.Stats {
+	return crawler.Stats{
+		Crawls:      atomic.LoadUint64(&c.counter),
+		Requests:    atomic.LoadUint64(&c.requests),
+		NotModified: atomic.LoadUint64(&c.notModified),
+		CacheHits:   atomic.LoadUint64(&c.cacheHits),
+		CacheMisses: atomic.LoadUint64(&c.cacheMisses),
+	}
+}
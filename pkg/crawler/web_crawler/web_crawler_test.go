@@ -2,17 +2,25 @@ package webcrawler_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/crawler/transport"
 	webcrawler "krisha_kz_bot/pkg/crawler/web_crawler"
+	"krisha_kz_bot/pkg/metrics"
 	"krisha_kz_bot/pkg/parser"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"os"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"gopkg.in/yaml.v3"
 )
 
@@ -43,6 +51,7 @@ func newTestUnit(t *testing.T, payload string) *testUnit {
 
 type TestCase struct {
 	testUnit
+	clock    *clock.Fake
 	Payload  string        `yaml:"Payload"`
 	Want1    []string      `yaml:"Want1"`
 	Interval time.Duration `yaml:"Interval"`
@@ -74,11 +83,13 @@ func TestWebCrawler(t *testing.T) {
 
 func mockSrcAndCrawler(c *TestCase) (*httptest.Server, *webcrawler.WebCrawler[string]) {
 	srv := httptest.NewServer(c.respHandler)
+	c.clock = clock.NewFake(time.Now())
 
 	webCraler := webcrawler.NewCrawler(
 		&webcrawler.Config[string]{
 			Interval: c.Interval,
 			Parser:   c.parser,
+			Clock:    c.clock,
 		},
 		[]string{srv.URL},
 		srv.Client(),
@@ -133,16 +144,500 @@ func testStartStop(t *testing.T, caseNum int, c *TestCase) {
 	mockSrv, webCrawler := mockSrcAndCrawler(c)
 	defer mockSrv.Close()
 
-	resCh := webCrawler.Start(context.Background())
+	cycleCh := webCrawler.Start(context.Background())
 
+	// flatten results of crawl cycles
+	resCh := make(chan string)
+	go func() {
+		defer close(resCh)
+
+		for res := range cycleCh {
+			if !res.IsComplete() || res.Pages != 1 {
+				t.Errorf("want complete cycle of 1 page, got %d pages, errors %v", res.Pages, res.Errors)
+			}
+			for _, val := range res.Items {
+				resCh <- val
+			}
+		}
+	}()
+
+	// crawls by interval until timeout
 	go func() {
 		defer webCrawler.Stop()
 
-		stopTimer := time.NewTimer(c.Timeout)
-		defer stopTimer.Stop()
+		for elapsed := c.Interval; elapsed < c.Timeout; elapsed += c.Interval {
+			c.clock.BlockUntil(1)
+			c.clock.Advance(c.Interval)
+		}
 
-		<-stopTimer.C
+		// wait for the last cycle
+		c.clock.BlockUntil(1)
 	}()
 
 	checkResult(t, caseNum, c.Want2, resCh)
 }
+
+func TestDoCrawlRetry(t *testing.T) {
+	c := &TestCase{Payload: "foo"}
+	c.testUnit = *newTestUnit(t, c.Payload)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.WriteHeader(http.StatusInternalServerError)
+		case 2:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		default:
+			c.respHandler(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	crawler := webcrawler.NewCrawler(
+		&webcrawler.Config[string]{
+			Parser: c.parser,
+			Retry:  webcrawler.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		},
+		[]string{srv.URL},
+		srv.Client(),
+	)
+
+	resCh := make(chan string, 1)
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); err != nil {
+		t.Fatalf("want success after retries, got error %v", err)
+	}
+
+	if got := <-resCh; got != "foo" || atomic.LoadInt32(&calls) != 3 {
+		t.Errorf("want foo after 3 calls, got %s after %d calls", got, calls)
+	}
+
+	u, _ := url.Parse(srv.URL)
+	for code, want := range map[int]float64{http.StatusInternalServerError: 1, http.StatusTooManyRequests: 1, http.StatusOK: 1} {
+		if got := testutil.ToFloat64(metrics.CrawlRequests.WithLabelValues(u.Host, metrics.Code(code))); got != want {
+			t.Errorf("want %v requests with code %d, got %v", want, code, got)
+		}
+	}
+}
+
+func TestDoCrawlBreaker(t *testing.T) {
+	c := &TestCase{}
+	c.testUnit = *newTestUnit(t, c.Payload)
+
+	var calls int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	breakers := webcrawler.NewBreakers(webcrawler.BreakerConfig{Threshold: 2, OpenPeriod: time.Hour})
+	config := &webcrawler.Config[string]{
+		Parser:   c.parser,
+		Retry:    webcrawler.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		Breakers: breakers,
+	}
+
+	// crawlers share breaker of the same host
+	crawler1 := webcrawler.NewCrawler(config, []string{srv.URL}, srv.Client())
+	crawler2 := webcrawler.NewCrawler(config, []string{srv.URL}, srv.Client())
+
+	resCh := make(chan string, 1)
+	for i := 0; i < 2; i++ {
+		err := crawler1.DoCrawl(context.Background(), srv.URL, resCh)
+
+		var statusErr *webcrawler.StatusError
+		if !errors.Is(err, webcrawler.ErrBlocked) || !errors.As(err, &statusErr) || statusErr.Code != http.StatusForbidden {
+			t.Fatalf("want blocked error, got %v", err)
+		}
+	}
+
+	if err := crawler2.DoCrawl(context.Background(), srv.URL, resCh); !errors.Is(err, webcrawler.ErrCircuitOpen) {
+		t.Errorf("want open circuit, got %v", err)
+	}
+
+	if got := atomic.LoadInt32(&calls); got != 2 {
+		t.Errorf("want 2 calls without retries of blocked requests, got %d", got)
+	}
+}
+
+func TestBreakerProbe(t *testing.T) {
+	now := time.Now()
+	b := webcrawler.NewBreakers(webcrawler.BreakerConfig{Threshold: 1, OpenPeriod: time.Minute}).Get("krisha.kz")
+
+	b.Failure(now)
+	if ok, _ := b.Allow(now); ok {
+		t.Fatal("want open breaker rejecting requests")
+	}
+
+	now = now.Add(2 * time.Minute)
+	if ok, probe := b.Allow(now); !ok || !probe {
+		t.Fatalf("want probe let through, got %v %v", ok, probe)
+	}
+	if ok, _ := b.Allow(now); ok {
+		t.Errorf("want requests rejected while probe is in flight")
+	}
+	if until := b.OpenUntil(); !until.After(now) {
+		t.Errorf("want probe deadline after %s, got %s", now, until)
+	}
+
+	// lost probe is replaced after deadline
+	now = now.Add(time.Minute)
+	if ok, probe := b.Allow(now); !ok || !probe {
+		t.Errorf("want another probe after deadline, got %v %v", ok, probe)
+	}
+
+	// released probe is replaced immediately
+	b.Release(now)
+	if ok, probe := b.Allow(now); !ok || !probe {
+		t.Errorf("want another probe after release, got %v %v", ok, probe)
+	}
+
+	b.Success()
+	if ok, probe := b.Allow(now); !ok || probe || !b.OpenUntil().IsZero() {
+		t.Errorf("want closed breaker, got %v %v till %s", ok, probe, b.OpenUntil())
+	}
+}
+
+func TestDoCrawlBreakerOutcome(t *testing.T) {
+	c := &TestCase{}
+	c.testUnit = *newTestUnit(t, c.Payload)
+
+	var (
+		calls  int32
+		status int32 = http.StatusInternalServerError
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	config := &webcrawler.Config[string]{
+		Parser:   c.parser,
+		Retry:    webcrawler.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		Breakers: webcrawler.NewBreakers(webcrawler.BreakerConfig{Threshold: 2, OpenPeriod: 50 * time.Millisecond}),
+	}
+	crawler := webcrawler.NewCrawler(config, []string{srv.URL}, srv.Client())
+	resCh := make(chan string, 1)
+
+	// retries of the page are counted as a single failure
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); errors.Is(err, webcrawler.ErrCircuitOpen) {
+		t.Fatalf("want breaker closed after the first page, got %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("want 3 attempts of the first page, got %d", got)
+	}
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); !errors.Is(err, webcrawler.ErrCircuitOpen) {
+		t.Fatalf("want breaker opened by the second page, got %v", err)
+	}
+
+	// probe answered with not retryable status closes breaker
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&status, http.StatusNotFound)
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); errors.Is(err, webcrawler.ErrCircuitOpen) {
+		t.Fatalf("want probe let through, got %v", err)
+	}
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); errors.Is(err, webcrawler.ErrCircuitOpen) {
+		t.Errorf("want breaker closed by probe, got %v", err)
+	}
+
+	// canceled probe is released
+	time.Sleep(10 * time.Millisecond)
+	atomic.StoreInt32(&status, http.StatusInternalServerError)
+	for i := 0; i < 2; i++ {
+		_ = crawler.DoCrawl(context.Background(), srv.URL, resCh)
+	}
+	time.Sleep(60 * time.Millisecond)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_ = crawler.DoCrawl(ctx, srv.URL, resCh)
+	atomic.StoreInt32(&status, http.StatusOK)
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); err != nil {
+		t.Errorf("want another probe after canceled one, got %v", err)
+	}
+}
+
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestDoCrawlNoProxy(t *testing.T) {
+	c := &TestCase{}
+	c.testUnit = *newTestUnit(t, c.Payload)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+
+	var (
+		attempts int32
+		noProxy  int32 = 1
+	)
+	client := srv.Client()
+	rt := client.Transport
+	client.Transport = roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&attempts, 1)
+		if atomic.LoadInt32(&noProxy) == 1 {
+			return nil, transport.ErrNoProxy
+		}
+		return rt.RoundTrip(req)
+	})
+
+	config := &webcrawler.Config[string]{
+		Parser:   c.parser,
+		Retry:    webcrawler.RetryPolicy{MaxRetries: 2, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		Breakers: webcrawler.NewBreakers(webcrawler.BreakerConfig{Threshold: 2, OpenPeriod: 50 * time.Millisecond}),
+	}
+	crawler := webcrawler.NewCrawler(config, []string{srv.URL}, client)
+	resCh := make(chan string, 1)
+
+	// missing proxies are neither retried nor counted as failures of the host
+	for i := 0; i < 2; i++ {
+		if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); !errors.Is(err, transport.ErrNoProxy) {
+			t.Fatalf("want no proxy, got %v", err)
+		}
+	}
+	if got := atomic.LoadInt32(&attempts); got != 2 {
+		t.Errorf("want 2 attempts without retries, got %d", got)
+	}
+
+	atomic.StoreInt32(&noProxy, 0)
+	for i := 0; i < 2; i++ {
+		_ = crawler.DoCrawl(context.Background(), srv.URL, resCh)
+	}
+
+	// probe without proxy does not close breaker
+	time.Sleep(60 * time.Millisecond)
+	atomic.StoreInt32(&noProxy, 1)
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); !errors.Is(err, transport.ErrNoProxy) {
+		t.Fatalf("want probe without proxy, got %v", err)
+	}
+	atomic.StoreInt32(&noProxy, 0)
+	if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); !errors.Is(err, webcrawler.ErrCircuitOpen) {
+		t.Errorf("want breaker opened by failed probe, got %v", err)
+	}
+}
+
+func TestDoCrawlContentCache(t *testing.T) {
+	c := &TestCase{Payload: "foo\nbar"}
+	c.testUnit = *newTestUnit(t, c.Payload)
+
+	var parsed int32
+	parser := parser.Func[string](func(payload io.Reader, handler parser.HandlerFunc[string]) error {
+		atomic.AddInt32(&parsed, 1)
+		return c.parser(payload, handler)
+	})
+
+	srv := httptest.NewServer(c.respHandler)
+	defer srv.Close()
+
+	crawler := webcrawler.NewCrawler(
+		&webcrawler.Config[string]{Parser: parser, ContentTTL: time.Minute},
+		[]string{srv.URL},
+		srv.Client(),
+	)
+
+	for i := 0; i < 2; i++ {
+		resCh := make(chan string, 2)
+		if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); err != nil {
+			t.Fatalf("failed to crawl, got error %v", err)
+		}
+		close(resCh)
+
+		checkResult(t, i, []string{"foo", "bar"}, resCh)
+	}
+
+	stats := crawler.GetStats()
+	if got := atomic.LoadInt32(&parsed); got != 1 || stats.CacheHits != 1 || stats.CacheMisses != 1 {
+		t.Errorf("want unchanged page parsed once, got %d parses, stats %+v", got, stats)
+	}
+}
+
+func TestDoCrawlContentCacheNextDay(t *testing.T) {
+	c := &TestCase{Payload: "foo\nbar"}
+	c.testUnit = *newTestUnit(t, c.Payload)
+
+	var parsed int32
+	parser := parser.Func[string](func(payload io.Reader, handler parser.HandlerFunc[string]) error {
+		atomic.AddInt32(&parsed, 1)
+		return c.parser(payload, handler)
+	})
+
+	srv := httptest.NewServer(c.respHandler)
+	defer srv.Close()
+
+	loc := time.FixedZone("ALMT", 6*60*60)
+	fake := clock.NewFake(time.Date(2022, time.November, 12, 23, 50, 0, 0, loc))
+	crawler := webcrawler.NewCrawler(
+		&webcrawler.Config[string]{Parser: parser, ContentTTL: time.Hour, Location: loc, Clock: fake},
+		[]string{srv.URL},
+		srv.Client(),
+	)
+
+	for i := 0; i < 2; i++ {
+		resCh := make(chan string, 2)
+		if err := crawler.DoCrawl(context.Background(), srv.URL, resCh); err != nil {
+			t.Fatalf("failed to crawl, got error %v", err)
+		}
+
+		// results parsed yesterday are not reused after midnight
+		fake.Advance(20 * time.Minute)
+	}
+
+	if got := atomic.LoadInt32(&parsed); got != 2 {
+		t.Errorf("want page parsed again on the next day, got %d parses", got)
+	}
+}
+
+func TestStartParallelPages(t *testing.T) {
+	const pages = 3
+
+	// pages respond only when all of them are requested concurrently
+	var wg sync.WaitGroup
+	wg.Add(pages)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		wg.Done()
+		wg.Wait()
+
+		page := r.URL.Query().Get("page")
+		if _, err := w.Write([]byte(page)); err != nil {
+			t.Errorf("failed to write response, got error %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	urls := make([]string, pages)
+	for i := range urls {
+		urls[i] = fmt.Sprintf("%s/?page=%d", srv.URL, i+1)
+	}
+
+	fake := clock.NewFake(time.Now())
+	crawler := webcrawler.NewCrawler(
+		&webcrawler.Config[string]{
+			Interval:    time.Hour,
+			Parser:      newTestUnit(t, "").parser,
+			Parallelism: pages,
+			PageDelay:   time.Hour,
+			Clock:       fake,
+		},
+		urls,
+		srv.Client(),
+	)
+
+	resCh := crawler.Start(context.Background())
+
+	res := <-resCh
+	if got := strings.Join(res.Items, ","); got != "1,2,3" || res.Pages != pages || !res.IsComplete() {
+		t.Errorf("want complete cycle with results in page order, got %s, %d pages, errors %v", got, res.Pages, res.Errors)
+	}
+
+	// stop does not wait for page delay or interval
+	fake.BlockUntil(1)
+	crawler.Stop()
+
+	if _, ok := <-resCh; ok {
+		t.Errorf("want no results after stop")
+	}
+}
+
+func TestSetInterval(t *testing.T) {
+	tu := newTestUnit(t, "1")
+	srv := httptest.NewServer(tu.respHandler)
+	defer srv.Close()
+
+	start := time.Now()
+	fake := clock.NewFake(start)
+	crawler := webcrawler.NewCrawler(
+		&webcrawler.Config[string]{
+			Interval: time.Hour,
+			Parser:   tu.parser,
+			Clock:    fake,
+		},
+		[]string{srv.URL},
+		srv.Client(),
+	)
+
+	resCh := crawler.Start(context.Background())
+	defer crawler.Stop()
+	<-resCh
+
+	// advances the clock by minutes until the next crawl cycle
+	next := func() time.Duration {
+		t.Helper()
+
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			select {
+			case <-resCh:
+				return fake.Now().Sub(start)
+			case <-time.After(5 * time.Millisecond):
+				fake.Advance(time.Minute)
+			}
+		}
+
+		t.Fatal("want crawl cycle")
+		return 0
+	}
+
+	fake.BlockUntil(1)
+	fake.Advance(30 * time.Minute)
+
+	// the pending crawl is moved from 60m to 40m
+	crawler.SetInterval(40 * time.Minute)
+	if elapsed := next(); elapsed < 40*time.Minute || elapsed >= time.Hour {
+		t.Errorf("want crawl rescheduled by 40m, got crawl at %s", elapsed)
+	}
+
+	// overdue crawl starts immediately
+	fake.BlockUntil(1)
+	start = fake.Now()
+	fake.Advance(20 * time.Minute)
+	crawler.SetInterval(10 * time.Minute)
+	if elapsed := next(); elapsed > 21*time.Minute {
+		t.Errorf("want overdue crawl started immediately, got crawl at %s", elapsed)
+	}
+}
+
+func TestSetURLs(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := w.Write([]byte(r.URL.Query().Get("page"))); err != nil {
+			t.Errorf("failed to write response, got error %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	page := func(i int) string {
+		return fmt.Sprintf("%s/?page=%d", srv.URL, i)
+	}
+
+	fake := clock.NewFake(time.Now())
+	crawler := webcrawler.NewCrawler(
+		&webcrawler.Config[string]{
+			Interval: time.Hour,
+			Parser:   newTestUnit(t, "").parser,
+			Clock:    fake,
+		},
+		[]string{page(1)},
+		srv.Client(),
+	)
+
+	resCh := crawler.Start(context.Background())
+	defer crawler.Stop()
+
+	if res := <-resCh; strings.Join(res.Items, ",") != "1" {
+		t.Errorf("want the first page crawled, got %v", res.Items)
+	}
+
+	// pages are changed from the next cycle
+	crawler.SetURLs([]string{page(1), page(2)})
+	crawler.CrawlNow()
+	if res := <-resCh; strings.Join(res.Items, ",") != "1,2" || res.Pages != 2 {
+		t.Errorf("want two pages crawled, got %v of %d pages", res.Items, res.Pages)
+	}
+}
@@ -0,0 +1,221 @@
+package dashboard
+
+import (
+	"crypto/sha256"
+	"html/template"
+	"net/http"
+	"sort"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/webapi"
+
+	"github.com/go-redis/redis/v9"
+)
+
+const (
+	Prefix = "/dashboard/"
+
+	DefaultNotifications = 12
+	DefaultSessionTTL    = 24 * time.Hour
+	DefaultLoginMaxAge   = 24 * time.Hour
+
+	sessionCookie = "session"
+)
+
+type Config struct {
+	BotToken      string        // verifies Telegram Login Widget data and signs sessions
+	BotUserName   string        // bot of Telegram Login Widget
+	Notifications int           // recent ads shown per subscription, DefaultNotifications if not positive
+	SessionTTL    time.Duration // DefaultSessionTTL if not positive
+	Clock         clock.Clock   // system clock if nil
+}
+
+// Server rendered dashboard of subscriptions of the logged in Telegram user.
+//
+//	GET /dashboard/        - subscriptions or login page
+//	GET /dashboard/auth    - callback of Telegram Login Widget
+//	GET /dashboard/logout  - drops session
+type Dashboard struct {
+	config     Config
+	rdb        *redis.Client
+	store      *ops.Store
+	sessionKey []byte
+}
+
+func New(rdb *redis.Client, cfg Config) *Dashboard {
+	if cfg.Notifications <= 0 {
+		cfg.Notifications = DefaultNotifications
+	}
+	if cfg.SessionTTL <= 0 {
+		cfg.SessionTTL = DefaultSessionTTL
+	}
+	cfg.Clock = clock.OrDefault(cfg.Clock)
+
+	// sessions are invalidated with the token
+	sessionKey := sha256.Sum256([]byte("dashboard session;" + cfg.BotToken))
+
+	return &Dashboard{
+		config:     cfg,
+		rdb:        rdb,
+		store:      ops.NewStore(rdb),
+		sessionKey: sessionKey[:],
+	}
+}
+
+// Implements http.Handler.
+func (d *Dashboard) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	switch r.URL.Path {
+	case Prefix:
+		d.serveDashboard(w, r)
+	case Prefix + "auth":
+		d.serveAuth(w, r)
+	case Prefix + "logout":
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Path: Prefix, MaxAge: -1})
+		http.Redirect(w, r, Prefix, http.StatusFound)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (d *Dashboard) serveAuth(w http.ResponseWriter, r *http.Request) {
+	user, err := VerifyLogin(d.config.BotToken, r.URL.Query(), d.config.Clock.Now(), DefaultLoginMaxAge)
+	if err == nil && user.UserName == "" {
+		err = ErrInvalidSession
+	}
+	if err != nil {
+		logger.FromContext(r.Context()).Warn("failed to verify login", logger.Err, err)
+		w.WriteHeader(http.StatusUnauthorized)
+		d.render(w, loginTemplate, d.loginData("login failed, please try again"))
+		return
+	}
+
+	expires := d.config.Clock.Now().Add(d.config.SessionTTL)
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    signSession(d.sessionKey, user, expires),
+		Path:     Prefix,
+		MaxAge:   int(d.config.SessionTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https",
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	logger.FromContext(r.Context()).Info("logged in to dashboard", logger.User, user.UserName)
+	http.Redirect(w, r, Prefix, http.StatusFound)
+}
+
+type subView struct {
+	webapi.Subscription
+	Health        string
+	Notifications []ops.Notification
+	Histogram     []Bucket
+}
+
+type chatView struct {
+	ChatID int64
+	Subs   []subView
+}
+
+func (d *Dashboard) serveDashboard(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		d.render(w, loginTemplate, d.loginData(""))
+		return
+	}
+
+	user, err := parseSession(d.sessionKey, cookie.Value, d.config.Clock.Now())
+	if err != nil {
+		d.render(w, loginTemplate, d.loginData("session expired, please log in again"))
+		return
+	}
+
+	chats := make(map[int64]*chatView)
+	for _, sub := range webapi.LoadAll(r.Context(), d.rdb) {
+		// users see their own subscriptions only
+		if sub.User != user.UserName {
+			continue
+		}
+
+		view := subView{Subscription: sub, Health: health(sub.Status)}
+
+		// histogram over all kept notifications, recent ones are shown
+		notifications, e := d.store.Notifications(r.Context(), sub.Key(), ops.DefaultNotificationsCap)
+		if e != nil {
+			logger.FromContext(r.Context()).WithKey(sub.Key()).Error("failed to load notifications", logger.Err, e)
+		}
+		prices := make([]int64, len(notifications))
+		for i, n := range notifications {
+			prices[i] = n.Price
+		}
+		view.Histogram = Histogram(prices, DefaultBuckets)
+		if len(notifications) > d.config.Notifications {
+			notifications = notifications[:d.config.Notifications]
+		}
+		view.Notifications = notifications
+
+		chat, found := chats[sub.ChatID]
+		if !found {
+			chat = &chatView{ChatID: sub.ChatID}
+			chats[sub.ChatID] = chat
+		}
+		chat.Subs = append(chat.Subs, view)
+	}
+
+	views := make([]chatView, 0, len(chats))
+	for _, chat := range chats {
+		views = append(views, *chat)
+	}
+	sort.Slice(views, func(i, j int) bool { return views[i].ChatID < views[j].ChatID })
+
+	d.render(w, dashboardTemplate, struct {
+		User      User
+		Chats     []chatView
+		LogoutURL string
+	}{
+		User:      user,
+		Chats:     views,
+		LogoutURL: Prefix + "logout",
+	})
+}
+
+// Returns crawl health of the subscription.
+func health(status *ops.Status) string {
+	switch {
+	case status == nil || status.At.IsZero():
+		return "pending"
+	case status.Blocked:
+		return "blocked"
+	case status.Error != "":
+		return "failed"
+	}
+
+	return "ok"
+}
+
+func (d *Dashboard) loginData(errText string) any {
+	return struct {
+		BotUserName string
+		AuthURL     string
+		Error       string
+	}{
+		BotUserName: d.config.BotUserName,
+		AuthURL:     Prefix + "auth",
+		Error:       errText,
+	}
+}
+
+func (d *Dashboard) render(w http.ResponseWriter, tmpl *template.Template, data any) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	if err := tmpl.Execute(w, data); err != nil {
+		logger.Default().Error("failed to render", "template", tmpl.Name(), logger.Err, err)
+	}
+}
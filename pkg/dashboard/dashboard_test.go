@@ -0,0 +1,190 @@
+package dashboard_test
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/dashboard"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/ops"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+)
+
+const (
+	testToken = "123:secret"
+	testURL   = "https://krisha.kz/arenda/kvartiry/almaty/?das[live.rooms]=2"
+)
+
+// Returns login data signed as Telegram Login Widget does.
+func login(token string, userID int64, userName string, authDate time.Time) url.Values {
+	values := url.Values{
+		"id":         {strconv.FormatInt(userID, 10)},
+		"first_name": {userName},
+		"username":   {userName},
+		"auth_date":  {strconv.FormatInt(authDate.Unix(), 10)},
+	}
+
+	fields := make([]string, 0, len(values))
+	for k := range values {
+		fields = append(fields, k+"="+values.Get(k))
+	}
+	sort.Strings(fields)
+
+	secret := sha256.Sum256([]byte(token))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(fields, "\n")))
+	values.Set("hash", hex.EncodeToString(mac.Sum(nil)))
+
+	return values
+}
+
+func TestVerifyLogin(t *testing.T) {
+	now := time.Date(2022, 10, 24, 12, 0, 0, 0, time.UTC)
+
+	user, err := dashboard.VerifyLogin(testToken, login(testToken, 42, "alice", now.Add(-time.Hour)), now, 24*time.Hour)
+	if err != nil || user.ID != 42 || user.UserName != "alice" {
+		t.Errorf("want alice verified, got %+v, error %v", user, err)
+	}
+
+	tampered := login(testToken, 42, "alice", now)
+	tampered.Set("username", "bob")
+	if _, err = dashboard.VerifyLogin(testToken, tampered, now, time.Hour); err != dashboard.ErrInvalidHash {
+		t.Errorf("want %v for tampered data, got %v", dashboard.ErrInvalidHash, err)
+	}
+
+	if _, err = dashboard.VerifyLogin(testToken, login("456:other", 42, "alice", now), now, time.Hour); err != dashboard.ErrInvalidHash {
+		t.Errorf("want %v for another bot, got %v", dashboard.ErrInvalidHash, err)
+	}
+
+	if _, err = dashboard.VerifyLogin(testToken, login(testToken, 42, "alice", now.Add(-2*time.Hour)), now, time.Hour); err != dashboard.ErrExpiredLogin {
+		t.Errorf("want %v for old login, got %v", dashboard.ErrExpiredLogin, err)
+	}
+
+	if _, err = dashboard.VerifyLogin(testToken, login(testToken, 42, "alice", now.Add(time.Hour)), now, time.Hour); err != dashboard.ErrExpiredLogin {
+		t.Errorf("want %v for login from the future, got %v", dashboard.ErrExpiredLogin, err)
+	}
+
+	if _, err = dashboard.VerifyLogin(testToken, login(testToken, 42, "alice", now.Add(time.Second)), now, time.Hour); err != nil {
+		t.Errorf("want login verified within clock skew, got %v", err)
+	}
+}
+
+func TestHistogram(t *testing.T) {
+	if got := dashboard.Histogram([]int64{0, 0}, 4); got != nil {
+		t.Errorf("want no histogram without prices, got %+v", got)
+	}
+
+	// buckets of width 76 from 100
+	got := dashboard.Histogram([]int64{100, 150, 176, 200, 200, 400, 0}, 4)
+	want := []dashboard.Bucket{
+		{From: 100, To: 176, Count: 2, Percent: 66},
+		{From: 176, To: 252, Count: 3, Percent: 100},
+		{From: 252, To: 328, Count: 0, Percent: 0},
+		{From: 328, To: 404, Count: 1, Percent: 33},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("want %d buckets, got %+v", len(want), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("want bucket %+v, got %+v", want[i], got[i])
+		}
+	}
+
+	if got = dashboard.Histogram([]int64{300, 300}, 4); len(got) != 1 || got[0].Count != 2 {
+		t.Errorf("want single bucket of the same prices, got %+v", got)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	now := time.Date(2022, 10, 24, 12, 0, 0, 0, time.UTC)
+
+	// subscriptions as stored by worker
+	mr.HSet("bot;usr:alice;chat:1", "state", "\x01", "url", testURL)
+	mr.HSet("bot;usr:alice;chat:-100", "state", "\x01", "url", testURL+"&das[price][to]=300000")
+	mr.HSet("bot;usr:bob;chat:2", "state", "\x01", "url", testURL+"&bob")
+
+	store := ops.NewStore(rdb)
+	alice := id.Key{ChatID: 1, UserName: "alice"}
+	for i, price := range []int64{250000, 300000} {
+		n := ops.Notification{
+			Href:  "/a/show/" + strconv.Itoa(i),
+			Title: "2-комнатная квартира",
+			Price: price,
+			Photo: "https://photos.krisha.kz/" + strconv.Itoa(i) + ".jpg",
+			At:    now,
+		}
+		if err := store.AddNotification(context.Background(), alice, n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv := httptest.NewServer(dashboard.New(rdb, dashboard.Config{
+		BotToken:    testToken,
+		BotUserName: "krisha_kz_test_bot",
+		Clock:       clock.NewFake(now),
+	}))
+	defer srv.Close()
+
+	jar, _ := cookiejar.New(nil)
+	client := &http.Client{Jar: jar}
+
+	get := func(path string) (int, string) {
+		t.Helper()
+
+		resp, err := client.Get(srv.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		body, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	if _, body := get(dashboard.Prefix); !strings.Contains(body, `data-telegram-login="krisha_kz_test_bot"`) {
+		t.Errorf("want login widget without session, got %s", body)
+	}
+
+	forged := login("456:other", 2, "bob", now)
+	if code, _ := get(dashboard.Prefix + "auth?" + forged.Encode()); code != http.StatusUnauthorized {
+		t.Errorf("want %d for forged login, got %d", http.StatusUnauthorized, code)
+	}
+
+	code, body := get(dashboard.Prefix + "auth?" + login(testToken, 1, "alice", now).Encode())
+	if code != http.StatusOK {
+		t.Fatalf("want dashboard after login, got %d", code)
+	}
+
+	for _, want := range []string{"chat -100", "chat 1", "250 000 ₸", "https://photos.krisha.kz/0.jpg", "prices of recent ads"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("want %q in dashboard, got %s", want, body)
+		}
+	}
+	if strings.Contains(body, "&amp;bob") {
+		t.Errorf("want subscriptions of other users hidden")
+	}
+
+	if _, body = get(dashboard.Prefix + "logout"); !strings.Contains(body, "data-telegram-login") {
+		t.Errorf("want login widget after logout, got %s", body)
+	}
+}
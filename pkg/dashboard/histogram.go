@@ -0,0 +1,70 @@
+package dashboard
+
+const DefaultBuckets = 8
+
+// Bucket of prices within [From, To).
+type Bucket struct {
+	From    int64
+	To      int64
+	Count   int
+	Percent int // height of the bar relative to the highest bucket
+}
+
+// Returns histogram of known prices split into buckets of equal width, nil if there are no prices.
+func Histogram(prices []int64, buckets int) []Bucket {
+	if buckets <= 0 {
+		buckets = DefaultBuckets
+	}
+
+	var known []int64
+	for _, p := range prices {
+		if p > 0 {
+			known = append(known, p)
+		}
+	}
+	if len(known) == 0 {
+		return nil
+	}
+
+	lo, hi := known[0], known[0]
+	for _, p := range known {
+		if p < lo {
+			lo = p
+		}
+		if p > hi {
+			hi = p
+		}
+	}
+
+	// the same prices fall into a single bucket
+	width := (hi - lo + int64(buckets)) / int64(buckets)
+	if hi == lo {
+		buckets = 1
+	}
+
+	res := make([]Bucket, buckets)
+	for i := range res {
+		res[i].From = lo + int64(i)*width
+		res[i].To = res[i].From + width
+	}
+
+	for _, p := range known {
+		i := int((p - lo) / width)
+		if i >= buckets {
+			i = buckets - 1
+		}
+		res[i].Count++
+	}
+
+	highest := 0
+	for _, b := range res {
+		if b.Count > highest {
+			highest = b.Count
+		}
+	}
+	for i := range res {
+		res[i].Percent = res[i].Count * 100 / highest
+	}
+
+	return res
+}
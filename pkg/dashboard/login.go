@@ -0,0 +1,109 @@
+package dashboard
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Allowed difference between clocks of Telegram and the dashboard.
+const loginClockSkew = time.Minute
+
+var (
+	ErrInvalidHash    = errors.New("invalid login hash")
+	ErrExpiredLogin   = errors.New("login expired")
+	ErrInvalidSession = errors.New("invalid session")
+)
+
+// Telegram user authenticated by Login Widget.
+type User struct {
+	ID       int64
+	UserName string
+}
+
+// Verifies data of Telegram Login Widget signed by the bot token.
+// See https://core.telegram.org/widgets/login#checking-authorization
+func VerifyLogin(botToken string, values url.Values, now time.Time, maxAge time.Duration) (User, error) {
+	hash := values.Get("hash")
+
+	fields := make([]string, 0, len(values))
+	for k := range values {
+		if k != "hash" {
+			fields = append(fields, k+"="+values.Get(k))
+		}
+	}
+	sort.Strings(fields)
+
+	secret := sha256.Sum256([]byte(botToken))
+	mac := hmac.New(sha256.New, secret[:])
+	mac.Write([]byte(strings.Join(fields, "\n")))
+
+	if !hmac.Equal([]byte(hex.EncodeToString(mac.Sum(nil))), []byte(hash)) {
+		return User{}, ErrInvalidHash
+	}
+
+	// login from the future would never expire
+	authDate, err := strconv.ParseInt(values.Get("auth_date"), 10, 64)
+	if age := now.Sub(time.Unix(authDate, 0)); err != nil || age > maxAge || age < -loginClockSkew {
+		return User{}, ErrExpiredLogin
+	}
+
+	userID, err := strconv.ParseInt(values.Get("id"), 10, 64)
+	if err != nil {
+		return User{}, errors.Wrap(err, "invalid user id")
+	}
+
+	return User{ID: userID, UserName: values.Get("username")}, nil
+}
+
+// Signs session of the user valid till expiration.
+func signSession(key []byte, user User, expires time.Time) string {
+	payload := base64.RawURLEncoding.EncodeToString(
+		[]byte(fmt.Sprintf("%d:%d:%s", expires.Unix(), user.ID, user.UserName)))
+
+	return payload + "." + sign(key, payload)
+}
+
+// Parses session signed by signSession.
+func parseSession(key []byte, value string, now time.Time) (User, error) {
+	const fieldsNum = 3
+
+	payload, signature, found := strings.Cut(value, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(sign(key, payload))) {
+		return User{}, ErrInvalidSession
+	}
+
+	raw, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return User{}, ErrInvalidSession
+	}
+
+	fields := strings.SplitN(string(raw), ":", fieldsNum)
+	if len(fields) != fieldsNum {
+		return User{}, ErrInvalidSession
+	}
+
+	expires, err1 := strconv.ParseInt(fields[0], 10, 64)
+	userID, err2 := strconv.ParseInt(fields[1], 10, 64)
+	if err1 != nil || err2 != nil || now.After(time.Unix(expires, 0)) {
+		return User{}, ErrInvalidSession
+	}
+
+	return User{ID: userID, UserName: fields[2]}, nil
+}
+
+func sign(key []byte, payload string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,100 @@
+package dashboard
+
+import (
+	"html/template"
+	"strconv"
+	"strings"
+)
+
+//nolint:gochecknoglobals // parsed once, immutable
+var funcs = template.FuncMap{
+	"price": formatPrice,
+}
+
+const layout = `<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<title>krisha.kz bot</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #222; }
+.sub { border: 1px solid #ddd; border-radius: 6px; padding: 1em; margin-bottom: 1.5em; }
+.ok { color: #2a7; } .pending { color: #888; } .blocked, .failed { color: #c33; }
+.ads { display: flex; flex-wrap: wrap; gap: 1em; }
+.ad { width: 180px; font-size: 0.9em; }
+.ad img { width: 180px; height: 120px; object-fit: cover; background: #eee; }
+.hist { display: flex; align-items: flex-end; gap: 4px; height: 100px; }
+.bar { flex: 1; background: #4a8; min-height: 1px; }
+.axis { display: flex; gap: 4px; font-size: 0.7em; color: #666; }
+.axis span { flex: 1; text-align: center; }
+</style>
+</head>
+<body>
+{{template "content" .}}
+</body>
+</html>`
+
+//nolint:gochecknoglobals // parsed once, immutable
+var loginTemplate = template.Must(template.New("login").Funcs(funcs).Parse(layout + `
+{{define "content"}}
+<h1>krisha.kz bot</h1>
+{{if .Error}}<p class="failed">{{.Error}}</p>{{end}}
+<p>Please log in with Telegram to see your subscriptions.</p>
+<script async src="https://telegram.org/js/telegram-widget.js?21"
+	data-telegram-login="{{.BotUserName}}" data-size="large" data-auth-url="{{.AuthURL}}"></script>
+{{end}}`))
+
+//nolint:gochecknoglobals // parsed once, immutable
+var dashboardTemplate = template.Must(template.New("dashboard").Funcs(funcs).Parse(layout + `
+{{define "content"}}
+<h1>@{{.User.UserName}} subscriptions</h1>
+<p><a href="{{.LogoutURL}}">log out</a></p>
+{{range .Chats}}
+<h2>chat {{.ChatID}}</h2>
+{{range .Subs}}
+<div class="sub">
+	<p><a href="{{.URL}}">{{.URL}}</a></p>
+	<p class="{{.Health}}">
+		{{.Health}}{{with .Status}}{{if not .At.IsZero}}, crawled at {{.At.Format "02.01.2006 15:04"}}{{end}},
+		crawls {{.Crawls}}, requests {{.Requests}}{{if .Error}}, {{.Error}}{{end}}{{end}}
+		, notified {{.Visited}} ads
+	</p>
+	{{if .Histogram}}
+	<h3>prices of recent ads</h3>
+	<div class="hist">{{range .Histogram}}<div class="bar" style="height: {{.Percent}}%" title="{{.Count}} ads"></div>{{end}}</div>
+	<div class="axis">{{range .Histogram}}<span>{{price .From}}</span>{{end}}</div>
+	{{end}}
+	<h3>recent ads</h3>
+	<div class="ads">
+	{{range .Notifications}}
+		<div class="ad">
+			<a href="https://krisha.kz{{.Href}}">{{if .Photo}}<img src="{{.Photo}}" alt="">{{else}}<img alt="">{{end}}</a>
+			<div>{{if .Price}}<b>{{price .Price}} ₸</b>{{end}}</div>
+			<div>{{.Title}}</div>
+			<div>{{.At.Format "02.01.2006 15:04"}}</div>
+		</div>
+	{{else}}
+		<p>no ads notified yet</p>
+	{{end}}
+	</div>
+</div>
+{{end}}
+{{else}}
+<p>no subscriptions, please send /url command to the bot</p>
+{{end}}
+{{end}}`))
+
+// Formats price with thousands separated by spaces, e.g. 600 000.
+func formatPrice(price int64) string {
+	digits := strconv.FormatInt(price, 10)
+
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(d)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,222 @@
+package dedup
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/bits"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"krisha_kz_bot/pkg/listing"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	AreaTolerance    = 0.03 // relative difference of areas treated as the same flat
+	PriceTolerance   = 0.10 // relative difference of prices treated as the same price band
+	MaxPhotoDistance = 10   // max hamming distance of photo hashes treated as the same photo
+)
+
+var ErrUnknownMode = errors.New("unknown dedup mode")
+
+// Deduplication mode of near-duplicate listings.
+type Mode int
+
+const (
+	Off      Mode = iota // notify about every listing
+	Suppress             // do not notify about near-duplicates
+	Group                // notify about near-duplicates with a link to the original
+)
+
+func (mode Mode) String() string {
+	names := [...]string{"off", "suppress", "group"}
+	if mode < 0 || int(mode) >= len(names) {
+		return fmt.Sprintf("Mode(%d)", int(mode))
+	}
+
+	return names[mode]
+}
+
+// Parses mode by name, empty name is parsed as Group.
+func ParseMode(name string) (Mode, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "off":
+		return Off, nil
+	case "suppress":
+		return Suppress, nil
+	case "group", "":
+		return Group, nil
+	}
+
+	return Off, errors.WithMessagef(ErrUnknownMode, "%s", name)
+}
+
+// Fingerprint of a listing to compare listings re-posted under new ids.
+type Fingerprint struct {
+	Address  string  `json:"a"`
+	Rooms    int     `json:"r"`
+	Area     float64 `json:"s"`
+	Floor    int     `json:"f"`
+	Floors   int     `json:"ff"`
+	Price    int64   `json:"p"`
+	Photo    uint64  `json:"h"`
+	HasPhoto bool    `json:"hp"`
+}
+
+// Returns fingerprint of the listing without photo hash.
+func FromListing(l *listing.Listing) Fingerprint {
+	return Fingerprint{
+		Address: NormalizeAddress(l.Address),
+		Rooms:   l.Rooms,
+		Area:    l.Area,
+		Floor:   l.Floor,
+		Floors:  l.Floors,
+		Price:   l.Price,
+	}
+}
+
+// Sets perceptual hash of the first photo.
+func (fp Fingerprint) WithPhoto(hash uint64) Fingerprint {
+	fp.Photo = hash
+	fp.HasPhoto = true
+
+	return fp
+}
+
+// Implements encoding.BinaryMarshaler.
+func (fp Fingerprint) MarshalBinary() ([]byte, error) {
+	return json.Marshal(fp)
+}
+
+// Implements encoding.BinaryUnmarshaler.
+func (fp *Fingerprint) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, fp)
+}
+
+// Returns true if both fingerprints describe the same flat.
+// Flats shall have the same rooms, floor, close area and price,
+// and either the same address or the similar first photo.
+func Similar(a, b Fingerprint) bool {
+	if a.Rooms != b.Rooms || a.Floor != b.Floor {
+		return false
+	}
+	if a.Floors != 0 && b.Floors != 0 && a.Floors != b.Floors {
+		return false
+	}
+	if !isClose(a.Area, b.Area, AreaTolerance) || !isClose(float64(a.Price), float64(b.Price), PriceTolerance) {
+		return false
+	}
+
+	sameAddress := a.Address != "" && a.Address == b.Address
+	samePhoto := a.HasPhoto && b.HasPhoto && bits.OnesCount64(a.Photo^b.Photo) <= MaxPhotoDistance
+
+	return sameAddress || samePhoto
+}
+
+// Returns true if relative difference of the values is within tolerance.
+func isClose(a, b float64, tolerance float64) bool {
+	if a == b {
+		return true
+	}
+
+	return math.Abs(a-b) <= tolerance*math.Max(a, b)
+}
+
+//nolint:gochecknoglobals // compiled once
+var (
+	reNonWord     = regexp.MustCompile(`[^\p{L}\p{N}/]+`)
+	addressTokens = strings.NewReplacer(
+		"р-н", " ", "район", " ",
+		"мкр.", " ", "мкр", " ", "микрорайон", " ",
+		"ул.", " ", "улица", " ",
+		"пр.", " ", "проспект", " ",
+		"жк", " ", "ё", "е",
+	)
+)
+
+// Normalizes address to compare addresses written in different ways,
+// e.g. "Медеуский р-н, мкр Самал-2 , 58" and "медеуский, Самал-2 58".
+func NormalizeAddress(address string) string {
+	address = strings.ToLower(address)
+	address = addressTokens.Replace(address)
+	address = reNonWord.ReplaceAllString(address, " ")
+
+	return strings.Join(strings.Fields(address), " ")
+}
+
+type entry struct {
+	fp Fingerprint
+	dt time.Time
+}
+
+// Index of fingerprints by listing link.
+type Index struct {
+	entries map[string]entry
+	mx      sync.RWMutex
+}
+
+func NewIndex() *Index {
+	return &Index{
+		entries: make(map[string]entry),
+	}
+}
+
+// Adds or replaces fingerprint of the listing.
+func (idx *Index) Add(href string, fp Fingerprint, dt time.Time) {
+	idx.mx.Lock()
+	defer idx.mx.Unlock()
+
+	idx.entries[href] = entry{fp: fp, dt: dt}
+}
+
+// Returns link of the earliest listing similar to the given fingerprint,
+// the listing itself is skipped.
+func (idx *Index) Match(href string, fp Fingerprint) (string, bool) {
+	idx.mx.RLock()
+	defer idx.mx.RUnlock()
+
+	var (
+		origin string
+		found  bool
+		dt     time.Time
+	)
+
+	for h, e := range idx.entries {
+		if h == href || !Similar(fp, e.fp) {
+			continue
+		}
+		if !found || e.dt.Before(dt) || (e.dt.Equal(dt) && h < origin) {
+			origin, dt, found = h, e.dt, true
+		}
+	}
+
+	return origin, found
+}
+
+// Removes fingerprints added before given time and returns their links.
+func (idx *Index) Clean(before time.Time) []string {
+	idx.mx.Lock()
+	defer idx.mx.Unlock()
+
+	var removed []string
+	for href, e := range idx.entries {
+		if e.dt.Before(before) {
+			delete(idx.entries, href)
+			removed = append(removed, href)
+		}
+	}
+
+	return removed
+}
+
+// Returns number of fingerprints in the index.
+func (idx *Index) Len() int {
+	idx.mx.RLock()
+	defer idx.mx.RUnlock()
+
+	return len(idx.entries)
+}
@@ -0,0 +1,130 @@
+package dedup_test
+
+import (
+	"image"
+	"image/color"
+	"math/bits"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/dedup"
+	"krisha_kz_bot/pkg/listing"
+)
+
+func TestNormalizeAddress(t *testing.T) {
+	cases := []struct {
+		a, b string
+	}{
+		{"Медеуский р-н, мкр Самал-2 58", "медеуский  Самал-2, 58"},
+		{"Бостандыкский р-н, ул. Розыбакиева 247", "Бостандыкский район, Розыбакиева, 247"},
+	}
+
+	for i, c := range cases {
+		if got1, got2 := dedup.NormalizeAddress(c.a), dedup.NormalizeAddress(c.b); got1 != got2 {
+			t.Errorf("case %d, want equal addresses, got %q and %q", i, got1, got2)
+		}
+	}
+}
+
+func TestSimilar(t *testing.T) {
+	origin := listing.Listing{
+		Address: "Медеуский р-н, Кармысова 84",
+		Rooms:   2,
+		Area:    62,
+		Floor:   7,
+		Floors:  12,
+		Price:   600000,
+	}
+
+	cases := []struct {
+		name   string
+		modify func(l *listing.Listing)
+		want   bool
+	}{
+		{"same flat", func(l *listing.Listing) {}, true},
+		{"close area and price", func(l *listing.Listing) { l.Area = 61.5; l.Price = 590000 }, true},
+		{"other rooms", func(l *listing.Listing) { l.Rooms = 3 }, false},
+		{"other floor", func(l *listing.Listing) { l.Floor = 8 }, false},
+		{"other price band", func(l *listing.Listing) { l.Price = 450000 }, false},
+		{"other address", func(l *listing.Listing) { l.Address = "Алмалинский р-н, Абая 1" }, false},
+	}
+
+	for _, c := range cases {
+		l := origin
+		c.modify(&l)
+
+		if got := dedup.Similar(dedup.FromListing(&origin), dedup.FromListing(&l)); got != c.want {
+			t.Errorf("%s, want %t, got %t", c.name, c.want, got)
+		}
+	}
+
+	// different address, but the same photo
+	l := origin
+	l.Address = "Медеуский р-н"
+	a := dedup.FromListing(&origin).WithPhoto(0xF0F0F0F0F0F0F0F0)
+	b := dedup.FromListing(&l).WithPhoto(0xF0F0F0F0F0F0F0F1)
+	if !dedup.Similar(a, b) {
+		t.Errorf("same photo, want similar fingerprints %v and %v", a, b)
+	}
+}
+
+func TestIndex(t *testing.T) {
+	day := time.Date(2022, 11, 12, 0, 0, 0, 0, time.UTC)
+	fp := dedup.FromListing(&listing.Listing{Address: "Кармысова 84", Rooms: 2, Area: 62, Floor: 7, Price: 600000})
+
+	idx := dedup.NewIndex()
+	idx.Add("/a/show/2", fp, day)
+	idx.Add("/a/show/1", fp, day.Add(-24*time.Hour))
+
+	if origin, found := idx.Match("/a/show/3", fp); !found || origin != "/a/show/1" {
+		t.Errorf("want the earliest origin /a/show/1, got %q %t", origin, found)
+	}
+
+	if origin, found := idx.Match("/a/show/1", fp); !found || origin != "/a/show/2" {
+		t.Errorf("want the listing itself skipped, got %q %t", origin, found)
+	}
+
+	if removed := idx.Clean(day); len(removed) != 1 || removed[0] != "/a/show/1" || idx.Len() != 1 {
+		t.Errorf("want /a/show/1 removed, got %v, left %d", removed, idx.Len())
+	}
+}
+
+func TestDHash(t *testing.T) {
+	gradient := func(shift uint8) image.Image {
+		img := image.NewGray(image.Rect(0, 0, 90, 80))
+		for y := 0; y < 80; y++ {
+			for x := 0; x < 90; x++ {
+				img.SetGray(x, y, color.Gray{Y: uint8(x*2) + shift})
+			}
+		}
+		return img
+	}
+
+	a, b := dedup.DHash(gradient(0)), dedup.DHash(gradient(10))
+	if d := bits.OnesCount64(a ^ b); d > dedup.MaxPhotoDistance {
+		t.Errorf("want similar hashes of brightened image, got distance %d", d)
+	}
+
+	inverted := image.NewGray(image.Rect(0, 0, 90, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 90; x++ {
+			inverted.SetGray(x, y, color.Gray{Y: uint8(255 - x*2)})
+		}
+	}
+
+	if d := bits.OnesCount64(a ^ dedup.DHash(inverted)); d <= dedup.MaxPhotoDistance {
+		t.Errorf("want different hashes of inverted image, got distance %d", d)
+	}
+}
+
+func TestModeString(t *testing.T) {
+	for _, mode := range []dedup.Mode{dedup.Off, dedup.Suppress, dedup.Group} {
+		if parsed, err := dedup.ParseMode(mode.String()); err != nil || parsed != mode {
+			t.Errorf("want %s parsed back, got %s, error %v", mode, parsed, err)
+		}
+	}
+
+	if got := dedup.Mode(7).String(); got != "Mode(7)" {
+		t.Errorf("want fallback name of unknown mode, got %q", got)
+	}
+}
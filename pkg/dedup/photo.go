@@ -0,0 +1,127 @@
+package dedup
+
+import (
+	"context"
+	"image"
+	"net/http"
+
+	// register decoders of photo formats.
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	hashWidth  = 9 // width of the grayscale thumbnail, one more than bits in a row
+	hashHeight = 8 // height of the grayscale thumbnail
+)
+
+// Perceptual hasher of photos.
+type PhotoHasher interface {
+	Hash(ctx context.Context, url string) (uint64, error)
+}
+
+// Adapter to allow a use of functions as PhotoHasher.
+type PhotoHasherFunc func(ctx context.Context, url string) (uint64, error)
+
+// Implements PhotoHasher interface.
+func (fnc PhotoHasherFunc) Hash(ctx context.Context, url string) (uint64, error) {
+	return fnc(ctx, url)
+}
+
+// Loads photo over http and calculates its difference hash.
+type HTTPPhotoHasher struct {
+	Client *http.Client
+}
+
+// Returns photo hasher with the given client, http.DefaultClient if nil.
+func NewHTTPPhotoHasher(client *http.Client) *HTTPPhotoHasher {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	return &HTTPPhotoHasher{Client: client}
+}
+
+// Implements PhotoHasher interface.
+func (h *HTTPPhotoHasher) Hash(ctx context.Context, url string) (uint64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, http.NoBody)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Accept", "image/jpeg,image/png,image/gif")
+
+	resp, err := h.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, errors.Errorf("Error: %d - %s", resp.StatusCode, resp.Status)
+	}
+
+	img, _, err := image.Decode(resp.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	return DHash(img), nil
+}
+
+// Calculates difference hash of the image.
+// Image is shrunk to 9x8 grayscale thumbnail
+// and each bit is set when a pixel is brighter than its right neighbour.
+func DHash(img image.Image) uint64 {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if w == 0 || h == 0 {
+		return 0
+	}
+
+	var thumb [hashHeight][hashWidth]uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth; x++ {
+			thumb[y][x] = areaLuma(img,
+				bounds.Min.X+x*w/hashWidth, bounds.Min.Y+y*h/hashHeight,
+				bounds.Min.X+(x+1)*w/hashWidth, bounds.Min.Y+(y+1)*h/hashHeight,
+			)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < hashHeight; y++ {
+		for x := 0; x < hashWidth-1; x++ {
+			hash <<= 1
+			if thumb[y][x] > thumb[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+
+	return hash
+}
+
+// Returns average luma of the area, the area is at least one pixel.
+func areaLuma(img image.Image, x0, y0, x1, y1 int) uint64 {
+	if x1 <= x0 {
+		x1 = x0 + 1
+	}
+	if y1 <= y0 {
+		y1 = y0 + 1
+	}
+
+	var sum, cnt uint64
+	for y := y0; y < y1; y++ {
+		for x := x0; x < x1; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			// ITU-R BT.601 luma
+			sum += (299*uint64(r) + 587*uint64(g) + 114*uint64(b)) / 1000
+			cnt++
+		}
+	}
+
+	return sum / cnt
+}
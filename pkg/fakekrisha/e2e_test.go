@@ -0,0 +1,363 @@
+package fakekrisha_test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/bot"
+	"krisha_kz_bot/pkg/bot/fakeapi"
+	"krisha_kz_bot/pkg/clock"
+	webcrawler "krisha_kz_bot/pkg/crawler/web_crawler"
+	"krisha_kz_bot/pkg/dedup"
+	"krisha_kz_bot/pkg/fakekrisha"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/id"
+	krishakz "krisha_kz_bot/pkg/parser/krisha_kz"
+	"krisha_kz_bot/pkg/scanner"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	tgbotapi "github.com/go-telegram-bot-api/telegram-bot-api/v5"
+)
+
+const testFilter = "https://krisha.kz/arenda/kvartiry/almaty/?das[live.rooms]=2"
+
+// Collects n events or fails on timeout.
+func expectEvents(t *testing.T, events <-chan string, want ...string) {
+	t.Helper()
+
+	got := make([]string, 0, len(want))
+	timeout := time.After(5 * time.Second)
+	for len(got) < len(want) {
+		select {
+		case e := <-events:
+			got = append(got, e)
+		case <-timeout:
+			t.Fatalf("want events %v, got %v", want, got)
+		}
+	}
+
+	sort.Strings(got)
+	sort.Strings(want)
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("want events %v, got %v", want, got)
+		}
+	}
+}
+
+// Crawls fake krisha.kz through crawler, parser and scanner.
+func TestEndToEnd(t *testing.T) {
+	loc := mustLoadLocation(t)
+	fake := clock.NewFake(time.Date(2022, time.November, 10, 12, 0, 0, 0, loc))
+
+	site := fakekrisha.New(fake, 2)
+	site.Add(fakekrisha.Generate(1, fake.Now().AddDate(0, 0, -1), 2)...)
+	site.Add(fakekrisha.Generate(3, fake.Now(), 1)...)
+
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer rdb.Close()
+
+	p := krishakz.NewParser(fake, loc)
+	p.KeepOld = true
+
+	events := make(chan string, 10)
+	scanServ := scanner.NewServiceFromConfig(&scanner.Config[string]{
+		TimeZone: *loc,
+		Config: webcrawler.Config[holder.WithDT[string]]{
+			Interval: scanner.DefaultScanInterval,
+			Parser:   p,
+			Clock:    fake,
+		},
+		Client: srv.Client(),
+		OnResult: func(ctx context.Context, key id.Key, href string) {
+			events <- "new " + href
+		},
+		OnChanged: func(ctx context.Context, key id.Key, prev, cur holder.WithDT[string]) {
+			events <- "changed " + cur.GetValue()
+		},
+		OnRemoved: func(ctx context.Context, key id.Key, href string) {
+			events <- "removed " + href
+		},
+		IsRemoved: func(ctx context.Context, href string) bool {
+			resp, err := srv.Client().Head(srv.URL + href)
+			if err != nil {
+				return false
+			}
+			resp.Body.Close()
+			return resp.StatusCode == http.StatusNotFound
+		},
+	}).WithRedis(rdb)
+
+	key := id.Key{UserName: "tester", ChatID: 1}
+	if err := scanServ.Register(key, []string{srv.URL + "/?page=1", srv.URL + "/?page=2"}); err != nil {
+		t.Fatalf("failed to register, got error %v", err)
+	}
+	if err := scanServ.Start(context.Background(), key); err != nil {
+		t.Fatalf("failed to start, got error %v", err)
+	}
+	defer scanServ.StopAll()
+
+	// the first cycle notifies about ads of today only
+	ads := site.Ads()
+	expectEvents(t, events, "new "+ads[0].Href(), "new "+ads[1].Href(), "new "+ads[2].Href())
+
+	favorite := ads[1]
+	if err := scanServ.AddFavorite(context.Background(), key, favorite.Href()); err != nil {
+		t.Fatalf("failed to add favorite, got error %v", err)
+	}
+
+	next := func() {
+		fake.BlockUntil(1)
+		fake.Advance(scanner.DefaultScanInterval)
+	}
+
+	// new ad and changed price of favorite
+	fresh := fakekrisha.Generate(1, fake.Now(), 3)[0]
+	site.Add(fresh)
+	site.SetPrice(favorite.ID, favorite.Price+10000)
+	next()
+	expectEvents(t, events, "new "+fresh.Href(), "changed "+favorite.Href())
+
+	// removed favorite
+	site.Remove(favorite.ID)
+	next()
+	expectEvents(t, events, "removed "+favorite.Href())
+
+	// throttled cycle is incomplete, so missing ads are not treated as removed
+	if err := scanServ.AddFavorite(context.Background(), key, ads[0].Href()); err != nil {
+		t.Fatalf("failed to add favorite, got error %v", err)
+	}
+	site.Throttle(1, time.Hour)
+	next()
+	fake.BlockUntil(1)
+
+	select {
+	case e := <-events:
+		t.Errorf("want no events on throttled cycle, got %s", e)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if got := scanServ.Favorites(key); len(got) != 1 || got[0] != ads[0].Href() {
+		t.Errorf("want favorite kept, got %v", got)
+	}
+}
+
+// Bot and scanner wired the way the worker does, crawling fake krisha.kz.
+type pipeline struct {
+	api   *fakeapi.Server
+	clock *clock.Fake // clock of the bot outbound queue
+	bot   *bot.Service
+	scan  *scanner.Service[string]
+	seen  int // number of sent messages already expected
+	stop  context.CancelFunc
+}
+
+// Starts the pipeline against the site and the given redis, subscriptions stored in redis are restored.
+func startPipeline(t *testing.T, mr *miniredis.Miniredis, site *httptest.Server, fake *clock.Fake, loc *time.Location) *pipeline {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	pl := &pipeline{
+		api:   fakeapi.New(""),
+		clock: clock.NewFake(time.Now()),
+	}
+	t.Cleanup(func() {
+		pl.api.Close()
+		rdb.Close()
+	})
+
+	p := krishakz.NewParser(fake, loc)
+	p.KeepOld = true
+
+	pl.scan = scanner.NewServiceFromConfig(&scanner.Config[string]{
+		TimeZone: *loc,
+		Config: webcrawler.Config[holder.WithDT[string]]{
+			Interval: scanner.DefaultScanInterval,
+			Parser:   p,
+			Clock:    fake,
+		},
+		Client:    site.Client(),
+		DedupMode: dedup.Group,
+		OnResult: func(ctx context.Context, key id.Key, href string) {
+			text := fmt.Sprintf("@%s pls look at https://krisha.kz%s\n", key.UserName, href)
+			_ = pl.bot.SendMessageContext(ctx, tgbotapi.NewMessage(int64(key.ChatID), text))
+		},
+		OnDuplicate: func(ctx context.Context, key id.Key, href string, origin string) {
+			text := fmt.Sprintf("@%s pls look at https://krisha.kz%s\npossibly same as https://krisha.kz%s\n",
+				key.UserName, href, origin)
+			_ = pl.bot.SendMessageContext(ctx, tgbotapi.NewMessage(int64(key.ChatID), text))
+		},
+	}).WithRedis(rdb)
+
+	reply := func(key id.Key, text string) tgbotapi.Chattable {
+		return tgbotapi.NewMessage(int64(key.ChatID), text)
+	}
+
+	serv, err := bot.NewServiceFromConfig(&bot.Config{
+		Token:        "test",
+		APIEndpoint:  pl.api.Endpoint(),
+		UpdateConfig: tgbotapi.UpdateConfig{Timeout: bot.DefaultUpdateTimeout},
+		Clock:        pl.clock,
+		HandlersConfig: bot.HandlersConfig{
+			OnSubscribe: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				filter, _ := params[0].(url.URL)
+
+				// filter of krisha.kz is crawled on the fake site
+				if err := pl.scan.Register(key, []string{site.URL + filter.Path + "?page=1"}); err != nil {
+					return reply(key, "failed to subscribe"), err
+				}
+				if err := pl.scan.Start(context.Background(), key); err != nil {
+					_ = pl.scan.UnRegister(key)
+					return reply(key, "failed to start scanning"), err
+				}
+
+				return reply(key, "subscribed"), nil
+			},
+			OnStop: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				return reply(key, "stopped"), pl.scan.UnRegister(key)
+			},
+			OnKicked: func(update *tgbotapi.Update, key id.Key, params ...any) (tgbotapi.Chattable, error) {
+				return nil, pl.scan.UnRegister(key)
+			},
+		},
+	})
+	if err != nil {
+		t.Fatalf("failed to create bot service, got error %v", err)
+	}
+	pl.bot = serv.WithRedis(rdb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pl.stop = cancel
+
+	// restored subscriptions notify through outbound queue
+	pl.bot.Serve(ctx)
+	pl.bot.LoadFromRedis(ctx)
+	go func() {
+		_ = pl.bot.Poll(ctx)
+	}()
+
+	return pl
+}
+
+func (pl *pipeline) shutdown() {
+	_ = pl.bot.Shutdown()
+	pl.stop()
+	pl.scan.StopAll()
+}
+
+// Waits for the next messages sent to the chat in any order, flushes outbound queue by the fake clock.
+func (pl *pipeline) expectSent(t *testing.T, chatID int64, want ...string) {
+	t.Helper()
+
+	var sent []fakeapi.Sent
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		pl.clock.Advance(time.Minute)
+
+		if sent = pl.api.Sent(); len(sent) >= pl.seen+len(want) {
+			break
+		}
+
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	if len(sent) < pl.seen+len(want) {
+		t.Fatalf("want messages %q, got %v", want, sent[pl.seen:])
+	}
+
+	got := make([]string, 0, len(want))
+	for _, msg := range sent[pl.seen : pl.seen+len(want)] {
+		if msg.ChatID != chatID {
+			t.Fatalf("want messages to chat %d, got %q to chat %d", chatID, msg.Text, msg.ChatID)
+		}
+		got = append(got, msg.Text)
+	}
+	pl.seen += len(want)
+
+	sort.Strings(got)
+	sort.Strings(want)
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Fatalf("want messages %q, got %q", want, got)
+		}
+	}
+}
+
+// Crawls fake krisha.kz through crawler, parser, scanner and bot.
+func TestEndToEndBot(t *testing.T) {
+	const chatID = 1
+
+	loc := mustLoadLocation(t)
+	fake := clock.NewFake(time.Date(2022, time.November, 10, 12, 0, 0, 0, loc))
+
+	site := fakekrisha.New(fake, 10)
+	site.Add(fakekrisha.Generate(1, fake.Now().AddDate(0, 0, -1), 2)...)
+	today := fakekrisha.Generate(2, fake.Now(), 1)
+	site.Add(today...)
+
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	mr := miniredis.RunT(t)
+
+	look := func(ad fakekrisha.Ad) string {
+		return "@alice pls look at https://krisha.kz" + ad.Href() + "\n"
+	}
+	sameAs := func(ad fakekrisha.Ad) string {
+		return "possibly same as https://krisha.kz" + ad.Href()
+	}
+	// reposted ad differs by id only
+	repost := func(ad fakekrisha.Ad, adID string) fakekrisha.Ad {
+		ad.ID = adID
+		return ad
+	}
+
+	pl := startPipeline(t, mr, srv, fake, loc)
+
+	// the first cycle notifies about ads of today only
+	pl.api.PushUpdate(fakeapi.Message(chatID, "alice", "/url "+testFilter))
+	pl.expectSent(t, chatID, "subscribed", look(today[0]), look(today[1]))
+
+	// repost is grouped with its origin
+	dup := repost(today[0], "690000001")
+	site.Add(dup)
+	fake.BlockUntil(1)
+	fake.Advance(scanner.DefaultScanInterval)
+	pl.expectSent(t, chatID, look(dup)+sameAs(today[0]))
+
+	pl.shutdown()
+
+	// ads published while the worker is down
+	fresh := fakekrisha.Generate(1, fake.Now(), 3)[0]
+	dup = repost(today[1], "690000002")
+	site.Add(fresh, dup)
+
+	// restored subscription notifies about new ads only and groups reposts with stored ones
+	pl = startPipeline(t, mr, srv, fake, loc)
+	defer pl.shutdown()
+
+	pl.expectSent(t, chatID, look(fresh), look(dup)+sameAs(today[1]))
+
+	// nothing else is sent
+	fake.BlockUntil(1)
+	fake.Advance(scanner.DefaultScanInterval)
+	fake.BlockUntil(1)
+	time.Sleep(100 * time.Millisecond)
+	pl.clock.Advance(time.Minute)
+	time.Sleep(100 * time.Millisecond)
+
+	if sent := pl.api.Sent(); len(sent) != pl.seen {
+		t.Errorf("want no more messages, got %v", sent[pl.seen:])
+	}
+}
@@ -0,0 +1,366 @@
+package fakekrisha
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/logger"
+)
+
+const (
+	DefaultPageSize = 20
+
+	ControlPrefix = "/_fake/" // prefix of control endpoints
+)
+
+// Markup variants of search page cards.
+type Markup string
+
+const (
+	MarkupDefault Markup = "default"  // card with photo, address and currency sign
+	MarkupCompact Markup = "compact"  // card without photo and address, plain price
+	MarkupNoStats Markup = "no-stats" // card without publication day
+)
+
+//nolint:gochecknoglobals // see time lib implementation
+var shortMonthNames = [...]string{
+	"янв.", "фев.", "мар.", "апр.", "май", "июн.",
+	"июл.", "авг.", "сен.", "окт.", "нояб.", "дек.",
+}
+
+// Ad published on the fake site.
+type Ad struct {
+	ID      string    `json:"id"`
+	Title   string    `json:"title"` // e.g. 2-комнатная квартира, 45 м², 3/9 этаж
+	Address string    `json:"address"`
+	Price   int64     `json:"price"`
+	Photo   string    `json:"photo"`
+	Day     time.Time `json:"day"` // publication day, today by the server clock if zero
+}
+
+// Returns link of the ad as parsed from search page.
+func (ad *Ad) Href() string {
+	return "/a/show/" + ad.ID
+}
+
+// Fake krisha.kz serving search pages of controllable ads.
+// Ads are listed newest first, the first added ad is the last one.
+type Server struct {
+	clock    clock.Clock
+	pageSize int
+
+	ads        []*Ad
+	markup     Markup
+	throttled  int           // number of next requests refused with 429
+	retryAfter time.Duration // Retry-After of refused requests
+	requests   int
+	mx         sync.Mutex
+}
+
+// Creates fake site with the given clock and page size, DefaultPageSize if not positive.
+func New(clk clock.Clock, pageSize int) *Server {
+	if pageSize <= 0 {
+		pageSize = DefaultPageSize
+	}
+
+	return &Server{
+		clock:    clock.OrDefault(clk),
+		pageSize: pageSize,
+		markup:   MarkupDefault,
+	}
+}
+
+// Publishes ads on top of the search list.
+func (s *Server) Add(ads ...Ad) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for i := range ads {
+		ad := ads[i]
+		if ad.Day.IsZero() {
+			ad.Day = s.clock.Now()
+		}
+		s.ads = append([]*Ad{&ad}, s.ads...)
+	}
+}
+
+// Removes the ad, returns false if ad not found.
+func (s *Server) Remove(id string) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	for i, ad := range s.ads {
+		if ad.ID == id {
+			s.ads = append(s.ads[:i], s.ads[i+1:]...)
+			return true
+		}
+	}
+
+	return false
+}
+
+// Changes price of the ad, returns false if ad not found.
+func (s *Server) SetPrice(id string, price int64) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if ad := s.find(id); ad != nil {
+		ad.Price = price
+		return true
+	}
+
+	return false
+}
+
+// Sets markup variant of search page cards.
+func (s *Server) SetMarkup(markup Markup) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.markup = markup
+}
+
+// Refuses the next n requests with 429 Too Many Requests and the given Retry-After.
+func (s *Server) Throttle(n int, retryAfter time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.throttled = n
+	s.retryAfter = retryAfter
+}
+
+// Returns number of served requests, including refused ones.
+func (s *Server) Requests() int {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	return s.requests
+}
+
+// Returns published ads newest first.
+func (s *Server) Ads() []Ad {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	ads := make([]Ad, len(s.ads))
+	for i, ad := range s.ads {
+		ads[i] = *ad
+	}
+
+	return ads
+}
+
+func (s *Server) find(id string) *Ad {
+	for _, ad := range s.ads {
+		if ad.ID == id {
+			return ad
+		}
+	}
+
+	return nil
+}
+
+// Implements http.Handler.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, ControlPrefix) {
+		s.serveControl(w, r)
+		return
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.requests++
+
+	if s.throttled > 0 {
+		s.throttled--
+		w.Header().Set("Retry-After", strconv.Itoa(int(s.retryAfter.Seconds())))
+		http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+		return
+	}
+
+	var err error
+	if id := strings.TrimPrefix(r.URL.Path, "/a/show/"); id != r.URL.Path {
+		err = s.serveAd(w, id)
+	} else {
+		err = s.serveSearch(w, r)
+	}
+
+	if err != nil {
+		logger.FromContext(r.Context()).Error("fake krisha failed to serve", logger.URL, r.URL, logger.Err, err)
+	}
+}
+
+type card struct {
+	Ad
+	Price  string
+	Day    string
+	Markup Markup
+}
+
+type pageLink struct {
+	Number  int
+	Current bool
+}
+
+// Serves search page by page query parameter, pages beyond the last one are empty.
+func (s *Server) serveSearch(w http.ResponseWriter, r *http.Request) error {
+	page, err := strconv.Atoi(r.URL.Query().Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+
+	from := (page - 1) * s.pageSize
+	to := from + s.pageSize
+	if from > len(s.ads) {
+		from = len(s.ads)
+	}
+	if to > len(s.ads) {
+		to = len(s.ads)
+	}
+
+	data := struct {
+		Cards []card
+		Pages []pageLink
+	}{}
+
+	for _, ad := range s.ads[from:to] {
+		data.Cards = append(data.Cards, card{
+			Ad:     *ad,
+			Price:  formatPrice(ad.Price),
+			Day:    formatDay(ad.Day),
+			Markup: s.markup,
+		})
+	}
+
+	for i := 0; i*s.pageSize < len(s.ads); i++ {
+		data.Pages = append(data.Pages, pageLink{Number: i + 1, Current: i+1 == page})
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return searchTemplate.Execute(w, data)
+}
+
+// Serves page of the ad or 404 if ad has been removed.
+func (s *Server) serveAd(w http.ResponseWriter, id string) error {
+	ad := s.find(strings.Trim(id, "/"))
+	if ad == nil {
+		http.NotFound(w, nil)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	return adTemplate.Execute(w, card{Ad: *ad, Price: formatPrice(ad.Price)})
+}
+
+// Serves control endpoints:
+//
+//	GET    /_fake/ads                                 - list ads
+//	POST   /_fake/ads                                 - publish ad from json body
+//	DELETE /_fake/ads/<id>                            - remove ad
+//	POST   /_fake/price?id=<id>&price=<price>         - change price
+//	POST   /_fake/markup?variant=<markup>             - change markup variant
+//	POST   /_fake/throttle?n=<n>&retry_after=<dur>    - refuse next n requests
+func (s *Server) serveControl(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, ControlPrefix)
+	query := r.URL.Query()
+
+	switch {
+	case path == "ads" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(s.Ads()); err != nil {
+			logger.FromContext(r.Context()).Error("fake krisha failed to encode ads", logger.Err, err)
+		}
+
+	case path == "ads" && r.Method == http.MethodPost:
+		var ad Ad
+		if err := json.NewDecoder(r.Body).Decode(&ad); err != nil || ad.ID == "" {
+			http.Error(w, "invalid ad", http.StatusBadRequest)
+			return
+		}
+		s.Add(ad)
+
+	case strings.HasPrefix(path, "ads/") && r.Method == http.MethodDelete:
+		if !s.Remove(strings.TrimPrefix(path, "ads/")) {
+			http.NotFound(w, r)
+		}
+
+	case path == "price" && r.Method == http.MethodPost:
+		price, err := strconv.ParseInt(query.Get("price"), 10, 64)
+		if err != nil {
+			http.Error(w, "invalid price", http.StatusBadRequest)
+			return
+		}
+		if !s.SetPrice(query.Get("id"), price) {
+			http.NotFound(w, r)
+		}
+
+	case path == "markup" && r.Method == http.MethodPost:
+		s.SetMarkup(Markup(query.Get("variant")))
+
+	case path == "throttle" && r.Method == http.MethodPost:
+		n, err := strconv.Atoi(query.Get("n"))
+		if err != nil {
+			http.Error(w, "invalid n", http.StatusBadRequest)
+			return
+		}
+		retryAfter, _ := time.ParseDuration(query.Get("retry_after"))
+		s.Throttle(n, retryAfter)
+
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// Formats price with thousands separated by spaces, e.g. 600 000.
+func formatPrice(price int64) string {
+	digits := strconv.FormatInt(price, 10)
+
+	var b strings.Builder
+	for i, d := range digits {
+		if i > 0 && (len(digits)-i)%3 == 0 {
+			b.WriteRune(' ')
+		}
+		b.WriteRune(d)
+	}
+
+	return b.String()
+}
+
+// Formats publication day as krisha.kz does, e.g. 24 окт.
+func formatDay(day time.Time) string {
+	return fmt.Sprintf("%d %s", day.Day(), shortMonthNames[day.Month()-1])
+}
+
+// Generates n ads published on the given day, the same seed generates the same ads.
+func Generate(n int, day time.Time, seed int64) []Ad {
+	//nolint:gosec // fake data does not need crypto rand
+	rnd := rand.New(rand.NewSource(seed))
+	streets := []string{"Абая", "Достык", "Сейфуллина", "Тимирязева", "Розыбакиева", "Жандосова"}
+	districts := []string{"Алмалинский р-н", "Бостандыкский р-н", "Медеуский р-н", "Ауэзовский р-н"}
+
+	ads := make([]Ad, n)
+	for i := range ads {
+		rooms := 1 + rnd.Intn(4)
+		floors := 5 + rnd.Intn(12)
+		area := 25 + rooms*15 + rnd.Intn(20)
+
+		ads[i] = Ad{
+			ID:    strconv.Itoa(680000000 + int(seed%1000)*10000 + i),
+			Title: fmt.Sprintf("%d-комнатная квартира, %d м², %d/%d этаж", rooms, area, 1+rnd.Intn(floors), floors),
+			Address: fmt.Sprintf("%s, %s %d",
+				districts[rnd.Intn(len(districts))], streets[rnd.Intn(len(streets))], 1+rnd.Intn(200)),
+			Price: int64(150+rooms*50+rnd.Intn(100)) * 1000,
+			Day:   day,
+		}
+	}
+
+	return ads
+}
@@ -0,0 +1,120 @@
+package fakekrisha_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/fakekrisha"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/listing"
+	krishakz "krisha_kz_bot/pkg/parser/krisha_kz"
+)
+
+func mustLoadLocation(t *testing.T) *time.Location {
+	loc, err := time.LoadLocation("Asia/Almaty")
+	if err != nil {
+		t.Fatalf("failed to load location, got error %v", err)
+	}
+	return loc
+}
+
+func TestMarkupVariants(t *testing.T) {
+	loc := mustLoadLocation(t)
+	fake := clock.NewFake(time.Date(2022, time.November, 10, 12, 0, 0, 0, loc))
+
+	site := fakekrisha.New(fake, 10)
+	site.Add(fakekrisha.Generate(2, fake.Now().AddDate(0, 0, -1), 2)...)
+	site.Add(fakekrisha.Generate(3, fake.Now(), 1)...)
+
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	p := krishakz.NewParser(fake, loc)
+
+	for _, markup := range []fakekrisha.Markup{fakekrisha.MarkupDefault, fakekrisha.MarkupCompact, fakekrisha.MarkupNoStats} {
+		site.SetMarkup(markup)
+
+		resp, err := srv.Client().Get(srv.URL + "/arenda/kvartiry/almaty/?page=1")
+		if err != nil {
+			t.Fatalf("failed to get search page, got error %v", err)
+		}
+
+		prices := make(map[string]int64)
+		err = p.Parse(resp.Body, func(val holder.WithDT[string]) {
+			if l, ok := val.(*listing.Listing); ok {
+				prices[l.Href] = l.Price
+			}
+		})
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to parse %s markup, got error %v", markup, err)
+		}
+
+		// ads without publication day are treated as published today
+		want := 3
+		if markup == fakekrisha.MarkupNoStats {
+			want = 5
+		}
+		if len(prices) != want {
+			t.Errorf("want %d ads of today in %s markup, got %v", want, markup, prices)
+		}
+
+		for _, ad := range site.Ads()[:3] {
+			if prices[ad.Href()] != ad.Price {
+				t.Errorf("want price %d of %s in %s markup, got %d", ad.Price, ad.Href(), markup, prices[ad.Href()])
+			}
+		}
+	}
+}
+
+func TestPaginationAndThrottle(t *testing.T) {
+	site := fakekrisha.New(clock.NewFake(time.Now()), 2)
+	site.Add(fakekrisha.Generate(3, time.Now(), 1)...)
+
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	site.Throttle(1, time.Minute)
+
+	resp, err := srv.Client().Get(srv.URL + "/?page=2")
+	if err != nil {
+		t.Fatalf("failed to get search page, got error %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests || resp.Header.Get("Retry-After") != "60" {
+		t.Errorf("want 429 with Retry-After 60, got %d %s", resp.StatusCode, resp.Header.Get("Retry-After"))
+	}
+
+	cnt := 0
+	resp, err = srv.Client().Get(srv.URL + "/?page=2")
+	if err != nil {
+		t.Fatalf("failed to get search page, got error %v", err)
+	}
+	defer resp.Body.Close()
+
+	if err = krishakz.NewParser(nil, time.UTC).Parse(resp.Body, func(val holder.WithDT[string]) { cnt++ }); err != nil {
+		t.Fatalf("failed to parse, got error %v", err)
+	}
+
+	if cnt != 1 || site.Requests() != 2 {
+		t.Errorf("want 1 ad on the last page after 2 requests, got %d ads after %d requests", cnt, site.Requests())
+	}
+
+	if !site.Remove(site.Ads()[0].ID) {
+		t.Fatalf("failed to remove ad")
+	}
+
+	resp, err = srv.Client().Head(srv.URL + "/a/show/" + site.Ads()[0].ID)
+	if err != nil {
+		t.Fatalf("failed to get ad, got error %v", err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("want published ad found, got %d", resp.StatusCode)
+	}
+}
@@ -0,0 +1,86 @@
+package fakekrisha
+
+import (
+	"html/template"
+)
+
+//nolint:gochecknoglobals // parsed once
+var searchTemplate = template.Must(template.New("search").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Аренда квартир в Алматы</title></head>
+<body>
+<section class="a-list a-search-list a-list-with-favs">
+{{- range .Cards}}
+{{template "card" .}}
+{{- end}}
+</section>
+<nav class="paginator">
+{{- range .Pages}}
+    <a class="paginator__btn{{if .Current}} is-selected{{end}}" href="?page={{.Number}}">{{.Number}}</a>
+{{- end}}
+</nav>
+</body>
+</html>
+{{define "card"}}<div
+    data-id="{{.ID}}"
+    class="a-card a-storage-live ddl_product ddl_product_link not-colored is-visible"
+    id="id-{{.ID}}">
+<div class="a-card__inc">
+    {{- if and .Photo (ne .Markup "compact")}}
+    <a class="a-card__image" href="/a/show/{{.ID}}" target="_blank">
+        <picture class="is-moderated has-photo"><img alt="{{.Title}}" src="{{.Photo}}" loading="lazy"/></picture>
+    </a>
+    {{- end}}
+    <div class="a-card__descr">
+        <div class="a-card__header">
+            <div class="a-card__main-info">
+                <div class="a-card__header-left">
+                    <a href="/a/show/{{.ID}}" class="a-card__title " target="_blank">{{.Title}}</a>
+                </div>
+                <div class="a-card__price">
+                    {{- if eq .Markup "compact"}}
+                    {{.Price}} 〒
+                    {{- else}}
+                    {{.Price}}&nbsp;<span class="currency-sign offer__currency">〒</span>
+                    {{- end}}
+                </div>
+            </div>
+            <div class="a-card__header-body">
+                {{- if ne .Markup "compact"}}
+                <div class="a-card__wrapper-subtitle">
+                    <div class="a-card__subtitle ">
+                        {{.Address}}
+                    </div>
+                </div>
+                {{- end}}
+            </div>
+        </div>
+        <div class="a-card__footer">
+            <div class="a-card__card-info">
+                {{- if ne .Markup "no-stats"}}
+                <div class="card-stats">
+                    <div class="card-stats__item">Алматы</div>
+                    <div class="card-stats__item">
+                        {{.Day}}
+                    </div>
+                    <div class="card-stats__item" title="Количество просмотров">
+                        <span class="fi-eye"></span>
+                    </div>
+                </div>
+                {{- end}}
+            </div>
+        </div>
+    </div>
+</div>
+</div>{{end}}`))
+
+//nolint:gochecknoglobals // parsed once
+var adTemplate = template.Must(template.New("ad").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>{{.Title}}</title></head>
+<body>
+<div class="offer__advert-title"><h1>{{.Title}}</h1></div>
+<div class="offer__price">{{.Price}} 〒</div>
+<div class="offer__location">{{.Address}}</div>
+</body>
+</html>`))
@@ -0,0 +1,105 @@
+package fakekrisha_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	webcrawler "krisha_kz_bot/pkg/crawler/web_crawler"
+	"krisha_kz_bot/pkg/fakekrisha"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/id"
+	krishakz "krisha_kz_bot/pkg/parser/krisha_kz"
+	"krisha_kz_bot/pkg/scanner"
+	"krisha_kz_bot/pkg/tracing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Follows the new ad from the crawl cycle to the notification handler.
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(tracing.NewProvider(tracing.Config{}, sdktrace.WithSyncer(exporter)))
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	loc := mustLoadLocation(t)
+	fake := clock.NewFake(time.Date(2022, time.November, 10, 12, 0, 0, 0, loc))
+
+	site := fakekrisha.New(fake, 2)
+	site.Add(fakekrisha.Generate(1, fake.Now(), 1)...)
+	ad := site.Ads()[0]
+
+	srv := httptest.NewServer(site)
+	defer srv.Close()
+
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer rdb.Close()
+
+	notified := make(chan trace.SpanContext, 1)
+	scanServ := scanner.NewServiceFromConfig(&scanner.Config[string]{
+		TimeZone: *loc,
+		Config: webcrawler.Config[holder.WithDT[string]]{
+			Interval: scanner.DefaultScanInterval,
+			Parser:   krishakz.NewParser(fake, loc),
+			Clock:    fake,
+		},
+		Client: srv.Client(),
+		OnResult: func(ctx context.Context, key id.Key, href string) {
+			notified <- trace.SpanContextFromContext(ctx)
+		},
+	}).WithRedis(rdb)
+
+	key := id.Key{UserName: "tester", ChatID: 1}
+	if err := scanServ.Register(key, []string{srv.URL + "/?page=1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := scanServ.Start(context.Background(), key); err != nil {
+		t.Fatal(err)
+	}
+	defer scanServ.StopAll()
+
+	var sc trace.SpanContext
+	select {
+	case sc = <-notified:
+	case <-time.After(5 * time.Second):
+		t.Fatal("want notification")
+	}
+
+	// storage write is asynchronous
+	spans := map[string]tracetest.SpanStub{}
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) && len(spans) < 7 {
+		for _, span := range exporter.GetSpans() {
+			if span.SpanContext.TraceID() == sc.TraceID() {
+				spans[span.Name] = span
+			}
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	for _, name := range []string{"crawler.cycle", "crawler.page", "crawler.fetch", "crawler.parse", "scanner.process", "scanner.visited", "scanner.store"} {
+		if _, found := spans[name]; !found {
+			t.Errorf("want %s span in the trace of notification, got %v", name, spans)
+		}
+	}
+
+	visited := spans["scanner.visited"]
+	if visited.SpanContext.SpanID() != sc.SpanID() {
+		t.Errorf("want notification in scanner.visited span")
+	}
+	attrs := attribute.NewSet(visited.Attributes...)
+	if v, _ := attrs.Value(tracing.AdID); v.AsString() != ad.ID {
+		t.Errorf("want ad id %s, got %v", ad.ID, visited.Attributes)
+	}
+	if spans["scanner.store"].Parent.SpanID() != sc.SpanID() {
+		t.Errorf("want storage write in scanner.visited span")
+	}
+}
@@ -0,0 +1,164 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/logger"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+
+	DefaultMaxHeartbeatAge = 2 * time.Minute
+	DefaultMaxBotIdle      = time.Minute
+	DefaultMaxCrawlAge     = time.Hour
+	DefaultCheckTimeout    = 5 * time.Second
+)
+
+// State of worker published periodically.
+type Heartbeat struct {
+	At            time.Time `json:"at"`             // time of publication
+	BotAliveAt    time.Time `json:"bot_alive_at"`   // the last iteration of bot update loop
+	TelegramErr   string    `json:"telegram_error"` // empty if Telegram Bot API is reachable
+	LastCrawlAt   time.Time `json:"last_crawl_at"`  // the last successful crawl of any subscription, zero if none
+	Subscriptions int       `json:"subscriptions"`
+}
+
+// Returns the latest heartbeat of worker, false if not published.
+type Source func(ctx context.Context) (Heartbeat, bool, error)
+
+// Outcome of a single check.
+type Check struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// Outcome of all checks.
+type Report struct {
+	OK     bool    `json:"ok"`
+	Checks []Check `json:"checks"`
+}
+
+type Config struct {
+	MaxHeartbeatAge time.Duration // DefaultMaxHeartbeatAge if not positive
+	MaxBotIdle      time.Duration // DefaultMaxBotIdle if not positive
+	MaxCrawlAge     time.Duration // DefaultMaxCrawlAge if not positive
+	Clock           clock.Clock   // system clock if nil
+}
+
+// Serves liveness and readiness of worker:
+// liveness fails when storage is unreachable, heartbeat is stale or bot update loop is stuck,
+// readiness also fails when Telegram is unreachable or nothing has been crawled for a long time.
+type Handler struct {
+	config Config
+	ping   func(ctx context.Context) error
+	source Source
+}
+
+// Creates handler checking storage by ping and worker state by heartbeats of the source.
+func NewHandler(cfg Config, ping func(ctx context.Context) error, source Source) *Handler {
+	if cfg.MaxHeartbeatAge <= 0 {
+		cfg.MaxHeartbeatAge = DefaultMaxHeartbeatAge
+	}
+	if cfg.MaxBotIdle <= 0 {
+		cfg.MaxBotIdle = DefaultMaxBotIdle
+	}
+	if cfg.MaxCrawlAge <= 0 {
+		cfg.MaxCrawlAge = DefaultMaxCrawlAge
+	}
+	cfg.Clock = clock.OrDefault(cfg.Clock)
+
+	return &Handler{
+		config: cfg,
+		ping:   ping,
+		source: source,
+	}
+}
+
+// Implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var ready bool
+	switch r.URL.Path {
+	case LivenessPath:
+		ready = false
+	case ReadinessPath:
+		ready = true
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), DefaultCheckTimeout)
+	defer cancel()
+
+	report := h.Evaluate(ctx, ready)
+
+	code := http.StatusOK
+	if !report.OK {
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.FromContext(ctx).Error("failed to encode health report", logger.Err, err)
+	}
+}
+
+// Evaluates liveness checks, readiness checks as well if ready is true.
+func (h *Handler) Evaluate(ctx context.Context, ready bool) Report {
+	now := h.config.Clock.Now()
+	report := Report{OK: true}
+	add := func(name string, ok bool, detail string) {
+		report.Checks = append(report.Checks, Check{Name: name, OK: ok, Detail: detail})
+		report.OK = report.OK && ok
+	}
+
+	if err := h.ping(ctx); err != nil {
+		add("redis", false, err.Error())
+	} else {
+		add("redis", true, "")
+	}
+
+	hb, found, err := h.source(ctx)
+	switch {
+	case err != nil:
+		add("heartbeat", false, err.Error())
+		return report
+	case !found:
+		add("heartbeat", false, "worker has not published heartbeat")
+		return report
+	}
+
+	age := now.Sub(hb.At)
+	add("heartbeat", age <= h.config.MaxHeartbeatAge, fmt.Sprintf("published %s ago", age.Round(time.Second)))
+
+	idle := hb.At.Sub(hb.BotAliveAt)
+	add("bot", !hb.BotAliveAt.IsZero() && idle <= h.config.MaxBotIdle,
+		fmt.Sprintf("update loop idle for %s", idle.Round(time.Second)))
+
+	if !ready {
+		return report
+	}
+
+	add("telegram", hb.TelegramErr == "", hb.TelegramErr)
+
+	switch {
+	case hb.Subscriptions == 0:
+		add("crawl", true, "no subscriptions")
+	case hb.LastCrawlAt.IsZero():
+		add("crawl", false, "nothing crawled successfully yet")
+	default:
+		since := now.Sub(hb.LastCrawlAt)
+		add("crawl", since <= h.config.MaxCrawlAge, fmt.Sprintf("last successful crawl %s ago", since.Round(time.Second)))
+	}
+
+	return report
+}
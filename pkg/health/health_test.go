@@ -0,0 +1,102 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/health"
+)
+
+func TestHandler(t *testing.T) {
+	now := time.Date(2022, 10, 24, 12, 0, 0, 0, time.UTC)
+	live := health.Heartbeat{
+		At:            now.Add(-10 * time.Second),
+		BotAliveAt:    now.Add(-15 * time.Second),
+		LastCrawlAt:   now.Add(-5 * time.Minute),
+		Subscriptions: 2,
+	}
+
+	tests := []struct {
+		name      string
+		pingErr   error
+		hb        *health.Heartbeat
+		wantLive  bool
+		wantReady bool
+		failed    string
+	}{
+		{name: "healthy", hb: &live, wantLive: true, wantReady: true},
+		{name: "redis down", pingErr: errors.New("connection refused"), hb: &live, failed: "redis"},
+		{name: "worker down", failed: "heartbeat"},
+		{name: "stale heartbeat", hb: &health.Heartbeat{At: now.Add(-time.Hour), BotAliveAt: now.Add(-time.Hour)}, failed: "heartbeat"},
+		{name: "bot stuck", hb: &health.Heartbeat{At: now, BotAliveAt: now.Add(-10 * time.Minute)}, failed: "bot"},
+		{
+			name:     "telegram unreachable",
+			hb:       &health.Heartbeat{At: now, BotAliveAt: now, TelegramErr: "timeout"},
+			wantLive: true,
+			failed:   "telegram",
+		},
+		{
+			name:     "crawls failing",
+			hb:       &health.Heartbeat{At: now, BotAliveAt: now, LastCrawlAt: now.Add(-2 * time.Hour), Subscriptions: 1},
+			wantLive: true,
+			failed:   "crawl",
+		},
+		{
+			name:      "no subscriptions",
+			hb:        &health.Heartbeat{At: now, BotAliveAt: now},
+			wantLive:  true,
+			wantReady: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(health.NewHandler(
+				health.Config{Clock: clock.NewFake(now)},
+				func(ctx context.Context) error { return tt.pingErr },
+				func(ctx context.Context) (health.Heartbeat, bool, error) {
+					if tt.hb == nil {
+						return health.Heartbeat{}, false, nil
+					}
+					return *tt.hb, true, nil
+				},
+			))
+			defer srv.Close()
+
+			for path, want := range map[string]bool{health.LivenessPath: tt.wantLive, health.ReadinessPath: tt.wantReady} {
+				resp, err := http.Get(srv.URL + path)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var report health.Report
+				err = json.NewDecoder(resp.Body).Decode(&report)
+				resp.Body.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if report.OK != want || (resp.StatusCode == http.StatusOK) != want {
+					t.Errorf("%s want ok %v, got %d %+v", path, want, resp.StatusCode, report)
+				}
+
+				if !want {
+					found := false
+					for _, check := range report.Checks {
+						found = found || (check.Name == tt.failed && !check.OK)
+					}
+					if !found && (path == health.ReadinessPath || !tt.wantLive) {
+						t.Errorf("%s want %s check failed, got %+v", path, tt.failed, report.Checks)
+					}
+				}
+			}
+		})
+	}
+}
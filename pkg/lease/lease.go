@@ -0,0 +1,222 @@
+package lease
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/logger"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultTTL = 30 * time.Second
+
+	prefix = "lease;"
+)
+
+// Scripts change the lease only if it is held by the owner.
+//
+//nolint:gochecknoglobals // immutable scripts loaded by redis once
+var (
+	renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+	releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+)
+
+// Leases of named resources held by the owner in redis.
+// A lease expires after ttl unless renewed, so resources of a crashed owner are taken over by others.
+type Leases struct {
+	rdb   *redis.Client
+	owner string
+	ttl   time.Duration
+	clock clock.Clock
+}
+
+// Creates leases of the owner valid for ttl, DefaultTTL if not positive.
+func New(rdb *redis.Client, owner string, ttl time.Duration, clk clock.Clock) *Leases {
+	if ttl <= 0 {
+		ttl = DefaultTTL
+	}
+
+	return &Leases{
+		rdb:   rdb,
+		owner: owner,
+		ttl:   ttl,
+		clock: clock.OrDefault(clk),
+	}
+}
+
+// Returns owner of the leases.
+func (l *Leases) Owner() string {
+	return l.owner
+}
+
+// Returns ttl of the leases.
+func (l *Leases) TTL() time.Duration {
+	return l.ttl
+}
+
+func key(name string) string {
+	return prefix + name
+}
+
+// Acquires the lease if it is free or renews it if it is held by the owner.
+// Returns false if the lease is held by another owner.
+func (l *Leases) Acquire(ctx context.Context, name string) (bool, error) {
+	ok, err := l.rdb.SetNX(ctx, key(name), l.owner, l.ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed redis:setnx %s, error %w", key(name), err)
+	}
+	if ok {
+		return true, nil
+	}
+
+	return l.Renew(ctx, name)
+}
+
+// Extends the lease held by the owner for ttl.
+// Returns false if the lease has expired or is held by another owner.
+func (l *Leases) Renew(ctx context.Context, name string) (bool, error) {
+	res, err := renewScript.Run(ctx, l.rdb, []string{key(name)}, l.owner, l.ttl.Milliseconds()).Int()
+	if err != nil {
+		return false, fmt.Errorf("failed redis:evalsha renew %s, error %w", key(name), err)
+	}
+
+	return res == 1, nil
+}
+
+// Releases the lease held by the owner, so others acquire it without waiting for expiration.
+func (l *Leases) Release(ctx context.Context, name string) error {
+	if err := releaseScript.Run(ctx, l.rdb, []string{key(name)}, l.owner).Err(); err != nil {
+		return fmt.Errorf("failed redis:evalsha release %s, error %w", key(name), err)
+	}
+
+	return nil
+}
+
+// Returns current owner of the lease, empty if the lease is free.
+func (l *Leases) Holder(ctx context.Context, name string) (string, error) {
+	owner, err := l.rdb.Get(ctx, key(name)).Result()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return "", nil
+	case err != nil:
+		return "", fmt.Errorf("failed redis:get %s, error %w", key(name), err)
+	}
+
+	return owner, nil
+}
+
+// Returns number of held leases with names matching the pattern, e.g. instance:*.
+func (l *Leases) Count(ctx context.Context, pattern string) (int, error) {
+	const count = 100
+
+	var (
+		cnt    int
+		cursor uint64
+	)
+	for {
+		keys, next, err := l.rdb.Scan(ctx, cursor, key(pattern), count).Result()
+		if err != nil {
+			return cnt, fmt.Errorf("failed redis:scan %s, error %w", key(pattern), err)
+		}
+		cnt += len(keys)
+
+		if cursor = next; cursor == 0 {
+			return cnt, nil
+		}
+	}
+}
+
+// Campaigns for the lease until context is done, the lease is renewed every third of ttl.
+// Invokes onElected with context canceled when the lease is lost or context is done,
+// the lease is released after onElected returns.
+// Blocks execution.
+func (l *Leases) Campaign(ctx context.Context, name string, onElected func(ctx context.Context)) {
+	log := logger.FromContext(ctx).With("lease", name)
+
+	var (
+		demote  context.CancelFunc // cancels context of onElected, nil if not elected
+		done    chan struct{}      // closed when onElected returns
+		renewed time.Time          // the last time the lease has been acquired or renewed
+	)
+
+	// waits for onElected and releases the lease
+	resign := func() {
+		demote()
+		<-done
+		l.release(log, name)
+		demote, done = nil, nil
+	}
+
+	timer := l.clock.NewTimer(0)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if demote != nil {
+				resign()
+			}
+			return
+
+		case <-done:
+			// onElected returned before the lease is lost
+			resign()
+
+		case <-timer.C():
+			opCtx, stop := context.WithTimeout(ctx, l.ttl/3)
+			ok, err := l.Acquire(opCtx, name)
+			stop()
+
+			switch {
+			case ok:
+				renewed = l.clock.Now()
+			case err != nil:
+				log.Error("failed to acquire lease", logger.Err, err)
+				// the lease is still held until it expires
+				ok = demote != nil && clock.Since(l.clock, renewed) < l.ttl
+			}
+
+			switch {
+			case ok && demote == nil:
+				log.Info("elected")
+				var elected context.Context
+				elected, demote = context.WithCancel(ctx)
+				done = make(chan struct{})
+				go func(ctx context.Context, done chan<- struct{}) {
+					defer close(done)
+					onElected(ctx)
+				}(elected, done)
+
+			case !ok && demote != nil:
+				// another owner may acquire the lease
+				log.Warn("lost lease")
+				resign()
+			}
+
+			timer.Reset(l.ttl / 3)
+		}
+	}
+}
+
+func (l *Leases) release(log *logger.Logger, name string) {
+	ctx, stop := context.WithTimeout(context.Background(), l.ttl/3)
+	defer stop()
+
+	if err := l.Release(ctx, name); err != nil {
+		log.Error("failed to release lease", logger.Err, err)
+	}
+}
@@ -0,0 +1,117 @@
+package lease_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/lease"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+)
+
+const testTimeout = 5 * time.Second
+
+func newLeases(t *testing.T, mr *miniredis.Miniredis, owner string, ttl time.Duration) *lease.Leases {
+	t.Helper()
+
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	return lease.New(rdb, owner, ttl, nil)
+}
+
+func TestLeases(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	a := newLeases(t, mr, "a", time.Minute)
+	b := newLeases(t, mr, "b", time.Minute)
+
+	if ok, err := a.Acquire(ctx, "sub:1"); !ok || err != nil {
+		t.Fatalf("want lease acquired, got %v, error %v", ok, err)
+	}
+	if ok, err := b.Acquire(ctx, "sub:1"); ok || err != nil {
+		t.Errorf("want lease held by another owner, got %v, error %v", ok, err)
+	}
+	if ok, err := a.Acquire(ctx, "sub:1"); !ok || err != nil {
+		t.Errorf("want lease renewed by owner, got %v, error %v", ok, err)
+	}
+
+	// release of another owner is ignored
+	if err := b.Release(ctx, "sub:1"); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _ := a.Holder(ctx, "sub:1"); holder != "a" {
+		t.Errorf("want lease held by a, got %q", holder)
+	}
+
+	// expired lease is taken over
+	mr.FastForward(time.Minute)
+	if ok, err := a.Renew(ctx, "sub:1"); ok || err != nil {
+		t.Errorf("want expired lease not renewed, got %v, error %v", ok, err)
+	}
+	if ok, err := b.Acquire(ctx, "sub:1"); !ok || err != nil {
+		t.Errorf("want expired lease taken over, got %v, error %v", ok, err)
+	}
+
+	if _, err := a.Acquire(ctx, "sub:2"); err != nil {
+		t.Fatal(err)
+	}
+	if cnt, err := a.Count(ctx, "sub:*"); cnt != 2 || err != nil {
+		t.Errorf("want 2 leases, got %d, error %v", cnt, err)
+	}
+
+	if err := a.Release(ctx, "sub:2"); err != nil {
+		t.Fatal(err)
+	}
+	if holder, _ := a.Holder(ctx, "sub:2"); holder != "" {
+		t.Errorf("want lease released, got held by %q", holder)
+	}
+}
+
+// Campaigns for the leader lease, reports elected owner and demotion.
+func campaign(ctx context.Context, l *lease.Leases, events chan<- string) {
+	l.Campaign(ctx, "leader", func(ctx context.Context) {
+		events <- "elected " + l.Owner()
+		<-ctx.Done()
+		events <- "demoted " + l.Owner()
+	})
+}
+
+func expectEvent(t *testing.T, events <-chan string, want string) {
+	t.Helper()
+
+	select {
+	case got := <-events:
+		if got != want {
+			t.Fatalf("want %q, got %q", want, got)
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("want %q, got nothing", want)
+	}
+}
+
+func TestCampaign(t *testing.T) {
+	mr := miniredis.RunT(t)
+	events := make(chan string, 10)
+
+	ctxA, stopA := context.WithCancel(context.Background())
+	defer stopA()
+	go campaign(ctxA, newLeases(t, mr, "a", 300*time.Millisecond), events)
+	expectEvent(t, events, "elected a")
+
+	ctxB, stopB := context.WithCancel(context.Background())
+	defer stopB()
+	go campaign(ctxB, newLeases(t, mr, "b", 300*time.Millisecond), events)
+
+	// lease is released on stop and taken over without waiting for expiration
+	stopA()
+	expectEvent(t, events, "demoted a")
+	expectEvent(t, events, "elected b")
+
+	// lease held by another owner demotes the leader
+	mr.Set("lease;leader", "c")
+	expectEvent(t, events, "demoted b")
+}
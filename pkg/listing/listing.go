@@ -0,0 +1,30 @@
+package listing
+
+import (
+	"time"
+)
+
+// Advertisement card parsed from a search page.
+type Listing struct {
+	ID      string    // advertisement id, e.g. 679859047
+	Href    string    // relative link, e.g. /a/show/679859047
+	Title   string    // card title, e.g. 5-комнатная квартира, 130 м², 2/3 этаж
+	Address string    // district and street
+	Rooms   int       // number of rooms, 0 if unknown
+	Area    float64   // total area in square meters, 0 if unknown
+	Floor   int       // floor, 0 if unknown
+	Floors  int       // floors in a building, 0 if unknown
+	Price   int64     // price in tenge, 0 if unknown
+	Photo   string    // link to the first photo, empty if absent
+	DT      time.Time // day of publication
+}
+
+// Implements holder.WithValue.
+func (l *Listing) GetValue() string {
+	return l.Href
+}
+
+// Implements holder.WithDT.
+func (l *Listing) GetDT() time.Time {
+	return l.DT
+}
@@ -0,0 +1,276 @@
+package logger
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/id"
+
+	"github.com/pkg/errors"
+)
+
+var ErrUnknownLevel = errors.New("unknown log level")
+
+// Keys of fields shared between packages.
+const (
+	ChatID = "chat_id"
+	User   = "user"
+	Sub    = "sub"
+	URL    = "url"
+	Cycle  = "cycle"
+	Err    = "error"
+
+	redacted = "[REDACTED]"
+	badKey   = "!BADKEY"
+)
+
+type Level int32
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+func (l Level) String() string {
+	switch l {
+	case Debug:
+		return "debug"
+	case Info:
+		return "info"
+	case Warn:
+		return "warn"
+	case Error:
+		return "error"
+	}
+
+	return fmt.Sprintf("level(%d)", int32(l))
+}
+
+// Parses level by name, Info if empty.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return Debug, nil
+	case "", "info":
+		return Info, nil
+	case "warn", "warning":
+		return Warn, nil
+	case "error":
+		return Error, nil
+	}
+
+	return Info, errors.WithMessagef(ErrUnknownLevel, "%q", s)
+}
+
+// Output shared by logger and its children.
+type sink struct {
+	mx      sync.Mutex
+	out     io.Writer
+	level   int32
+	clock   clock.Clock
+	secrets []string
+}
+
+// Leveled logger writing lines of key=value fields, e.g.
+//
+//	time=2022-10-24T12:00:00Z level=info msg="crawled pages" sub=bot;usr:alice;chat:1 pages=2
+type Logger struct {
+	sink   *sink
+	fields []any
+}
+
+// Creates logger writing entries of the level and above, system clock if nil.
+func New(out io.Writer, level Level, c clock.Clock) *Logger {
+	return &Logger{
+		sink: &sink{
+			out:   out,
+			level: int32(level),
+			clock: clock.OrDefault(c),
+		},
+	}
+}
+
+//nolint:gochecknoglobals // default logger replaced on startup
+var std atomic.Value
+
+func init() {
+	std.Store(New(os.Stderr, Info, nil))
+}
+
+// Returns default logger.
+func Default() *Logger {
+	return std.Load().(*Logger)
+}
+
+// Replaces default logger.
+func SetDefault(l *Logger) {
+	std.Store(l)
+}
+
+type ctxKey struct{}
+
+// Returns context carrying the logger.
+func WithContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, ctxKey{}, l)
+}
+
+// Returns logger of the context, default logger if none.
+func FromContext(ctx context.Context) *Logger {
+	if ctx == nil {
+		return Default()
+	}
+	if l, ok := ctx.Value(ctxKey{}).(*Logger); ok {
+		return l
+	}
+
+	return Default()
+}
+
+// Returns child logger with the key/value fields added to every entry.
+func (l *Logger) With(kv ...any) *Logger {
+	fields := make([]any, 0, len(l.fields)+len(kv))
+	fields = append(fields, l.fields...)
+	fields = append(fields, kv...)
+
+	return &Logger{sink: l.sink, fields: fields}
+}
+
+// Returns child logger with fields of the subscription.
+func (l *Logger) WithKey(key id.Key) *Logger {
+	return l.With(Sub, key.String(), ChatID, int64(key.ChatID), User, key.UserName)
+}
+
+// Changes level of the logger and its children.
+func (l *Logger) SetLevel(level Level) {
+	atomic.StoreInt32(&l.sink.level, int32(level))
+}
+
+// Returns level of the logger.
+func (l *Logger) Level() Level {
+	return Level(atomic.LoadInt32(&l.sink.level))
+}
+
+// Returns true if entries of the level are written.
+func (l *Logger) Enabled(level Level) bool {
+	return level >= l.Level()
+}
+
+// Replaces the secrets in all entries of the logger and its children, e.g. bot token.
+func (l *Logger) Redact(secrets ...string) {
+	l.sink.mx.Lock()
+	defer l.sink.mx.Unlock()
+
+	for _, s := range secrets {
+		if s != "" {
+			l.sink.secrets = append(l.sink.secrets, s)
+		}
+	}
+}
+
+func (l *Logger) Debug(msg string, kv ...any) { l.log(Debug, msg, kv) }
+func (l *Logger) Info(msg string, kv ...any)  { l.log(Info, msg, kv) }
+func (l *Logger) Warn(msg string, kv ...any)  { l.log(Warn, msg, kv) }
+func (l *Logger) Error(msg string, kv ...any) { l.log(Error, msg, kv) }
+
+func (l *Logger) log(level Level, msg string, kv []any) {
+	if !l.Enabled(level) {
+		return
+	}
+
+	var b strings.Builder
+	b.WriteString("time=")
+	b.WriteString(l.sink.clock.Now().UTC().Format(time.RFC3339))
+	b.WriteString(" level=")
+	b.WriteString(level.String())
+	b.WriteString(" msg=")
+	b.WriteString(quote(msg))
+	writeFields(&b, l.fields)
+	writeFields(&b, kv)
+	b.WriteByte('\n')
+
+	l.sink.mx.Lock()
+	defer l.sink.mx.Unlock()
+
+	line := b.String()
+	for _, s := range l.sink.secrets {
+		line = strings.ReplaceAll(line, s, redacted)
+	}
+	_, _ = io.WriteString(l.sink.out, line)
+}
+
+func writeFields(b *strings.Builder, kv []any) {
+	for i := 0; i < len(kv); i += 2 {
+		key, ok := kv[i].(string)
+		if !ok || i+1 == len(kv) {
+			// value without key
+			key, i = badKey, i-1
+		}
+
+		b.WriteByte(' ')
+		b.WriteString(key)
+		b.WriteByte('=')
+		b.WriteString(quote(format(kv[i+1])))
+	}
+}
+
+func format(v any) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case error:
+		return val.Error()
+	case time.Time:
+		return val.Format(time.RFC3339)
+	case fmt.Stringer:
+		return val.String()
+	}
+
+	return fmt.Sprint(v)
+}
+
+// Quotes the value if it is empty or contains spaces, quotes or equal signs.
+func quote(s string) string {
+	if s == "" || strings.IndexFunc(s, func(r rune) bool {
+		return r == '"' || r == '=' || unicode.IsSpace(r) || !unicode.IsPrint(r)
+	}) >= 0 {
+		return fmt.Sprintf("%q", s)
+	}
+
+	return s
+}
+
+// Adapter of the logger for libraries logging by Printf and Println.
+type Printer struct {
+	logger *Logger
+	level  Level
+}
+
+// Returns adapter writing entries of the level.
+func (l *Logger) Printer(level Level) *Printer {
+	return &Printer{logger: l, level: level}
+}
+
+func (p *Printer) Printf(format string, v ...any) {
+	p.logger.log(p.level, strings.TrimSpace(fmt.Sprintf(format, v...)), nil)
+}
+
+func (p *Printer) Println(v ...any) {
+	p.logger.log(p.level, strings.TrimSpace(fmt.Sprintln(v...)), nil)
+}
+
+// Implements io.Writer for the standard log package, a line per entry.
+func (p *Printer) Write(data []byte) (int, error) {
+	p.logger.log(p.level, strings.TrimSpace(string(data)), nil)
+
+	return len(data), nil
+}
@@ -0,0 +1,77 @@
+package logger_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+)
+
+func TestLogger(t *testing.T) {
+	now := time.Date(2022, 10, 24, 12, 0, 0, 0, time.UTC)
+	var b strings.Builder
+	l := logger.New(&b, logger.Info, clock.NewFake(now))
+
+	key := id.Key{ChatID: -100, UserName: "alice"}
+	l.WithKey(key).With(logger.Cycle, 3).Info("crawled pages", "pages", 2, logger.Err, errors.New("not found"))
+	l.Debug("hidden")
+	l.Warn("odd", "orphan")
+
+	want := `time=2022-10-24T12:00:00Z level=info msg="crawled pages" sub=usr:alice;chat:-100 chat_id=-100 user=alice cycle=3 pages=2 error="not found"` + "\n" +
+		`time=2022-10-24T12:00:00Z level=warn msg=odd !BADKEY=orphan` + "\n"
+	if got := b.String(); got != want {
+		t.Errorf("want\n%s\ngot\n%s", want, got)
+	}
+
+	b.Reset()
+	l.SetLevel(logger.Debug)
+	l.Debug("shown")
+	if !strings.Contains(b.String(), "level=debug msg=shown") {
+		t.Errorf("want debug entry after level change, got %s", b.String())
+	}
+}
+
+func TestRedact(t *testing.T) {
+	var b strings.Builder
+	l := logger.New(&b, logger.Info, nil)
+	l.Redact("123:secret")
+
+	// secrets are redacted in children and adapters too
+	l.With("op", "send").Error("failed", logger.Err, errors.New(`Post "https://api.telegram.org/bot123:secret/sendMessage": timeout`))
+	l.Printer(logger.Warn).Printf("token %s", "123:secret")
+
+	if got := b.String(); strings.Contains(got, "secret") || strings.Count(got, "[REDACTED]") != 2 {
+		t.Errorf("want token redacted, got %s", got)
+	}
+}
+
+func TestContext(t *testing.T) {
+	var b strings.Builder
+	l := logger.New(&b, logger.Info, nil).With(logger.User, "bob")
+
+	if logger.FromContext(context.Background()) != logger.Default() {
+		t.Error("want default logger without logger in context")
+	}
+
+	logger.FromContext(logger.WithContext(context.Background(), l)).Info("hello")
+	if !strings.Contains(b.String(), "msg=hello user=bob") {
+		t.Errorf("want logger of context, got %s", b.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for raw, want := range map[string]logger.Level{"": logger.Info, "DEBUG": logger.Debug, "warn": logger.Warn, "error": logger.Error} {
+		if got, err := logger.ParseLevel(raw); err != nil || got != want {
+			t.Errorf("want %s for %q, got %s, error %v", want, raw, got, err)
+		}
+	}
+
+	if _, err := logger.ParseLevel("verbose"); !errors.Is(err, logger.ErrUnknownLevel) {
+		t.Errorf("want %v, got %v", logger.ErrUnknownLevel, err)
+	}
+}
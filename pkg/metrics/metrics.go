@@ -0,0 +1,122 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	Path      = "/metrics"
+	namespace = "krisha"
+
+	// Code of crawl request failed without response.
+	CodeError = "error"
+
+	// Results of outbound message.
+	Sent   = "sent"
+	Failed = "failed"
+)
+
+// Crawler metrics.
+//
+//nolint:gochecknoglobals // registered once in the default registry
+var (
+	CrawlRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "crawler",
+		Name:      "requests_total",
+		Help:      "Crawl requests by host and status code.",
+	}, []string{"host", "code"})
+
+	CrawlLatency = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "crawler",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of crawl requests by host.",
+		Buckets:   prometheus.ExponentialBuckets(0.1, 2, 10),
+	}, []string{"host"})
+
+	CardsParsed = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "crawler",
+		Name:      "cards_per_page",
+		Help:      "Cards parsed per successfully crawled page.",
+		Buckets:   prometheus.LinearBuckets(0, 5, 8),
+	})
+)
+
+// Scanner metrics.
+//
+//nolint:gochecknoglobals // registered once in the default registry
+var (
+	NewAds = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scanner",
+		Name:      "new_ads_total",
+		Help:      "Ads not visited before by subscription.",
+	}, []string{"subscription"})
+
+	ActiveSubscriptions = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "scanner",
+		Name:      "active_subscriptions",
+		Help:      "Registered scanners.",
+	})
+
+	VisitedSize = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "scanner",
+		Name:      "visited_size",
+		Help:      "Size of visited set by subscription.",
+	}, []string{"subscription"})
+
+	ScanInterval = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "scanner",
+		Name:      "interval_seconds",
+		Help:      "Crawl interval by subscription.",
+	}, []string{"subscription"})
+)
+
+// Bot metrics.
+//
+//nolint:gochecknoglobals // registered once in the default registry
+var (
+	Notifications = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "bot",
+		Name:      "messages_total",
+		Help:      "Outbound messages by result, sent or failed.",
+	}, []string{"result"})
+
+	OutboundQueue = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Subsystem: "bot",
+		Name:      "outbound_queue_depth",
+		Help:      "Messages waiting in the outbound queue.",
+	})
+)
+
+// Storage metrics.
+//
+//nolint:gochecknoglobals // registered once in the default registry
+var RedisErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Subsystem: "redis",
+	Name:      "errors_total",
+	Help:      "Failed redis operations by command.",
+}, []string{"op"})
+
+// Returns label of the response status code.
+func Code(status int) string {
+	return strconv.Itoa(status)
+}
+
+// Returns handler exposing metrics of the default registry.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -0,0 +1,49 @@
+package metrics
+
+import (
+	"context"
+	"net"
+
+	"github.com/go-redis/redis/v9"
+)
+
+// Redis hook counting failed operations, missing keys are not failures.
+type RedisHook struct{}
+
+// Implements redis.Hook.
+func (RedisHook) DialHook(next redis.DialHook) redis.DialHook {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		conn, err := next(ctx, network, addr)
+		if err != nil {
+			RedisErrors.WithLabelValues("dial").Inc()
+		}
+
+		return conn, err
+	}
+}
+
+// Implements redis.Hook.
+func (RedisHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if err != nil && err != redis.Nil {
+			RedisErrors.WithLabelValues(cmd.Name()).Inc()
+		}
+
+		return err
+	}
+}
+
+// Implements redis.Hook.
+func (RedisHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		err := next(ctx, cmds)
+		for _, cmd := range cmds {
+			if e := cmd.Err(); e != nil && e != redis.Nil {
+				RedisErrors.WithLabelValues(cmd.Name()).Inc()
+			}
+		}
+
+		return err
+	}
+}
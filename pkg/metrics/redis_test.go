@@ -0,0 +1,45 @@
+package metrics_test
+
+import (
+	"context"
+	"testing"
+
+	"krisha_kz_bot/pkg/metrics"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestRedisHook(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+	rdb.AddHook(metrics.RedisHook{})
+
+	ctx := context.Background()
+
+	// missing key is not a failure
+	if err := rdb.Get(ctx, "missing").Err(); err != redis.Nil {
+		t.Fatalf("want %v, got %v", redis.Nil, err)
+	}
+
+	// wrong type
+	mr.SAdd("set", "a")
+	if err := rdb.Get(ctx, "set").Err(); err == nil {
+		t.Fatal("want error of GET on set")
+	}
+
+	pipe := rdb.Pipeline()
+	pipe.HGet(ctx, "set", "field")
+	pipe.SCard(ctx, "set")
+	if _, err := pipe.Exec(ctx); err == nil {
+		t.Fatal("want error of HGET on set")
+	}
+
+	for op, want := range map[string]float64{"get": 1, "hget": 1, "scard": 0} {
+		if got := testutil.ToFloat64(metrics.RedisErrors.WithLabelValues(op)); got != want {
+			t.Errorf("want %v errors of %s, got %v", want, op, got)
+		}
+	}
+}
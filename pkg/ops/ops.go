@@ -0,0 +1,423 @@
+package ops
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"krisha_kz_bot/pkg/health"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/pkg/errors"
+)
+
+const (
+	DefaultStatusInterval    = 30 * time.Second
+	DefaultHeartbeatInterval = 15 * time.Second
+	DefaultNotificationsCap  = 100  // recent notifications returned per request
+	DefaultHistoryCap        = 1000 // notifications kept per subscription within retention
+
+	notifyPattern = "notify;*"
+	scanCount     = 100
+
+	commandsKey  = "ops;commands"
+	heartbeatKey = "ops;heartbeat"
+	pollTimeout  = 5 * time.Second
+)
+
+var ErrUnknownOp = errors.New("unknown operation")
+
+// Operations requested from worker.
+type Op string
+
+const (
+	OpSubscribe   Op = "subscribe"
+	OpUnsubscribe Op = "unsubscribe"
+	OpCrawlNow    Op = "crawl-now"
+	OpFavorite    Op = "favorite"   // forwarded to worker instance scanning the subscription
+	OpUnfavorite  Op = "unfavorite" // forwarded to worker instance scanning the subscription
+)
+
+// Command to worker.
+type Command struct {
+	Op     Op        `json:"op"`
+	ChatID int64     `json:"chat_id"`
+	User   string    `json:"user"`
+	URL    string    `json:"url,omitempty"`  // filter of OpSubscribe
+	Href   string    `json:"href,omitempty"` // ad path of OpFavorite and OpUnfavorite
+	At     time.Time `json:"at"`
+}
+
+// Returns subscription key of the command.
+func (cmd *Command) Key() id.Key {
+	return id.Key{ChatID: id.ChatID(cmd.ChatID), UserName: cmd.User}
+}
+
+// Validates the command.
+func (cmd *Command) Validate() error {
+	switch cmd.Op {
+	case OpSubscribe, OpUnsubscribe, OpCrawlNow, OpFavorite, OpUnfavorite:
+	default:
+		return errors.WithMessagef(ErrUnknownOp, "%q", cmd.Op)
+	}
+
+	if cmd.User == "" {
+		return errors.New("user is required")
+	}
+
+	if cmd.Op == OpSubscribe && cmd.URL == "" {
+		return errors.New("url is required")
+	}
+
+	if (cmd.Op == OpFavorite || cmd.Op == OpUnfavorite) && cmd.Href == "" {
+		return errors.New("href is required")
+	}
+
+	return nil
+}
+
+// Crawling status of a subscription published by worker.
+type Status struct {
+	At        time.Time `json:"at"`       // time of the last crawled page, zero if pending
+	URL       string    `json:"url"`      // the last crawled page
+	Results   int       `json:"results"`  // number of results parsed from the page
+	Error     string    `json:"error"`    // empty if page crawled successfully
+	Blocked   bool      `json:"blocked"`  // true if host refused to serve the page
+	Crawls    uint64    `json:"crawls"`   // crawl cycles by timer
+	Requests  uint64    `json:"requests"` // pages loaded
+	Published time.Time `json:"published"`
+}
+
+// Delivery status of a notification.
+type Delivery string
+
+const (
+	Delivered Delivery = "delivered"
+	Failed    Delivery = "failed"
+)
+
+// Notification sent to a subscriber.
+type Notification struct {
+	AdID      string    `json:"ad_id,omitempty"`
+	Href      string    `json:"href"`
+	Origin    string    `json:"origin,omitempty"` // similar ad notified earlier
+	Title     string    `json:"title,omitempty"`
+	Price     int64     `json:"price,omitempty"` // price in tenge at notification, 0 if unknown
+	Photo     string    `json:"photo,omitempty"`
+	At        time.Time `json:"at"`
+	Status    Delivery  `json:"status,omitempty"`     // empty if recorded before delivery was tracked
+	MessageID int       `json:"message_id,omitempty"` // telegram message id, 0 if not delivered
+	Error     string    `json:"error,omitempty"`      // reason of failed delivery
+}
+
+type statusID id.Key
+
+func (sid statusID) String() string {
+	return fmt.Sprintf("status;%s", id.Key(sid))
+}
+
+type notifyID id.Key
+
+func (nid notifyID) String() string {
+	return fmt.Sprintf("notify;%s", id.Key(nid))
+}
+
+type sentID struct {
+	key  id.Key
+	adID string
+}
+
+func (sid sentID) String() string {
+	return fmt.Sprintf("sent;%s;ad:%s", sid.key, sid.adID)
+}
+
+// Operational state shared by worker and web through redis.
+type Store struct {
+	rdb *redis.Client
+}
+
+func NewStore(rdb *redis.Client) *Store {
+	return &Store{rdb: rdb}
+}
+
+// Publishes crawling status of the subscription valid for ttl.
+func (st *Store) PutStatus(ctx context.Context, key id.Key, status Status, ttl time.Duration) error {
+	statusKey := statusID(key)
+
+	data, err := json.Marshal(status)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s", statusKey)
+	}
+
+	if err = st.rdb.Set(ctx, statusKey.String(), data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed redis:set %s, error %w", statusKey, err)
+	}
+
+	return nil
+}
+
+// Returns crawling status of the subscription, false if not published.
+func (st *Store) GetStatus(ctx context.Context, key id.Key) (Status, bool, error) {
+	var status Status
+
+	statusKey := statusID(key)
+	data, err := st.rdb.Get(ctx, statusKey.String()).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return status, false, nil
+	case err != nil:
+		return status, false, fmt.Errorf("failed redis:get %s, error %w", statusKey, err)
+	}
+
+	if err = json.Unmarshal(data, &status); err != nil {
+		return status, false, errors.Wrapf(err, "failed to unmarshal %s", statusKey)
+	}
+
+	return status, true, nil
+}
+
+// Appends the notification to the log of the subscription.
+func (st *Store) AddNotification(ctx context.Context, key id.Key, n Notification) error {
+	notifyKey := notifyID(key)
+
+	data, err := json.Marshal(n)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s", notifyKey)
+	}
+
+	_, err = st.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, notifyKey.String(), data)
+		pipe.LTrim(ctx, notifyKey.String(), 0, DefaultHistoryCap-1)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed redis:lpush %s, error %w", notifyKey, err)
+	}
+
+	return nil
+}
+
+// Claims notification of the subscription about the ad for ttl.
+// Returns false if the notification has been already claimed, e.g. by another worker instance.
+func (st *Store) ClaimNotification(ctx context.Context, key id.Key, adID string, ttl time.Duration) (bool, error) {
+	sentKey := sentID{key: key, adID: adID}
+
+	ok, err := st.rdb.SetNX(ctx, sentKey.String(), 1, ttl).Result()
+	if err != nil {
+		return false, fmt.Errorf("failed redis:setnx %s, error %w", sentKey, err)
+	}
+
+	return ok, nil
+}
+
+// Releases claim of the notification, e.g. failed to send, so it is claimed again.
+func (st *Store) ReleaseNotification(ctx context.Context, key id.Key, adID string) error {
+	sentKey := sentID{key: key, adID: adID}
+
+	if err := st.rdb.Del(ctx, sentKey.String()).Err(); err != nil {
+		return fmt.Errorf("failed redis:del %s, error %w", sentKey, err)
+	}
+
+	return nil
+}
+
+// Returns up to n recent notifications of the subscription, the newest first.
+func (st *Store) Notifications(ctx context.Context, key id.Key, n int) ([]Notification, error) {
+	notifyKey := notifyID(key)
+
+	values, err := st.rdb.LRange(ctx, notifyKey.String(), 0, int64(n)-1).Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed redis:lrange %s, error %w", notifyKey, err)
+	}
+
+	res := make([]Notification, 0, len(values))
+	for _, v := range values {
+		var notification Notification
+		if err = json.Unmarshal([]byte(v), &notification); err != nil {
+			logger.FromContext(ctx).Warn("failed to unmarshal notification", "key", notifyKey, "value", v, logger.Err, err)
+			continue
+		}
+		res = append(res, notification)
+	}
+
+	return res, nil
+}
+
+// Removes notifications older than the given time from logs of all subscriptions.
+// Returns number of removed notifications.
+func (st *Store) TrimNotifications(ctx context.Context, before time.Time) (int, error) {
+	var (
+		removed int
+		cursor  uint64
+	)
+
+	for {
+		keys, next, err := st.rdb.Scan(ctx, cursor, notifyPattern, scanCount).Result()
+		if err != nil {
+			return removed, fmt.Errorf("failed redis:scan %s, error %w", notifyPattern, err)
+		}
+
+		for _, key := range keys {
+			n, e := st.trimNotifications(ctx, key, before)
+			if e != nil {
+				return removed, e
+			}
+			removed += n
+		}
+
+		if cursor = next; cursor == 0 {
+			return removed, nil
+		}
+	}
+}
+
+// Removes the oldest notifications of the log before the given time.
+func (st *Store) trimNotifications(ctx context.Context, key string, before time.Time) (int, error) {
+	values, err := st.rdb.LRange(ctx, key, 0, -1).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed redis:lrange %s, error %w", key, err)
+	}
+
+	// the newest first, so the outdated are at the tail
+	outdated := 0
+	for i := len(values) - 1; i >= 0; i-- {
+		var notification Notification
+		if err = json.Unmarshal([]byte(values[i]), &notification); err == nil && !notification.At.Before(before) {
+			break
+		}
+		outdated++
+	}
+
+	if outdated == 0 {
+		return 0, nil
+	}
+
+	// counted from the tail, notifications pushed meanwhile are kept
+	if err = st.rdb.LTrim(ctx, key, 0, -int64(outdated)-1).Err(); err != nil {
+		return 0, fmt.Errorf("failed redis:ltrim %s, error %w", key, err)
+	}
+
+	return outdated, nil
+}
+
+// Publishes heartbeat of worker valid for ttl.
+func (st *Store) PutHeartbeat(ctx context.Context, hb health.Heartbeat, ttl time.Duration) error {
+	data, err := json.Marshal(hb)
+	if err != nil {
+		return errors.Wrapf(err, "failed to marshal %s", heartbeatKey)
+	}
+
+	if err = st.rdb.Set(ctx, heartbeatKey, data, ttl).Err(); err != nil {
+		return fmt.Errorf("failed redis:set %s, error %w", heartbeatKey, err)
+	}
+
+	return nil
+}
+
+// Returns the latest heartbeat of worker, false if expired or not published.
+// Implements health.Source.
+func (st *Store) GetHeartbeat(ctx context.Context) (health.Heartbeat, bool, error) {
+	var hb health.Heartbeat
+
+	data, err := st.rdb.Get(ctx, heartbeatKey).Bytes()
+	switch {
+	case errors.Is(err, redis.Nil):
+		return hb, false, nil
+	case err != nil:
+		return hb, false, fmt.Errorf("failed redis:get %s, error %w", heartbeatKey, err)
+	}
+
+	if err = json.Unmarshal(data, &hb); err != nil {
+		return hb, false, errors.Wrapf(err, "failed to unmarshal %s", heartbeatKey)
+	}
+
+	return hb, true, nil
+}
+
+// Queues the command to worker.
+func (st *Store) Push(ctx context.Context, cmd Command) error {
+	return st.push(ctx, commandsKey, cmd)
+}
+
+// Queues the command to the worker instance.
+func (st *Store) PushTo(ctx context.Context, instance string, cmd Command) error {
+	return st.push(ctx, instanceCommandsKey(instance), cmd)
+}
+
+func instanceCommandsKey(instance string) string {
+	return fmt.Sprintf("%s:%s", commandsKey, instance)
+}
+
+func (st *Store) push(ctx context.Context, queue string, cmd Command) error {
+	if err := cmd.Validate(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal command")
+	}
+
+	if err = st.rdb.RPush(ctx, queue, data).Err(); err != nil {
+		return fmt.Errorf("failed redis:rpush %s, error %w", queue, err)
+	}
+
+	return nil
+}
+
+// Returns channel of queued commands, closed when context is done.
+func (st *Store) Commands(ctx context.Context) <-chan Command {
+	return st.commands(ctx, commandsKey)
+}
+
+// Returns channel of commands queued to the worker instance, closed when context is done.
+func (st *Store) InstanceCommands(ctx context.Context, instance string) <-chan Command {
+	return st.commands(ctx, instanceCommandsKey(instance))
+}
+
+func (st *Store) commands(ctx context.Context, queue string) <-chan Command {
+	ch := make(chan Command)
+
+	go func() {
+		defer close(ch)
+
+		for ctx.Err() == nil {
+			values, err := st.rdb.BLPop(ctx, pollTimeout, queue).Result()
+			switch {
+			case errors.Is(err, redis.Nil):
+				continue
+			case err != nil:
+				if ctx.Err() == nil {
+					logger.FromContext(ctx).Error("failed redis:blpop", "key", queue, logger.Err, err)
+					// avoid busy loop while redis is unavailable
+					select {
+					case <-ctx.Done():
+					case <-time.After(pollTimeout):
+					}
+				}
+				continue
+			}
+
+			var cmd Command
+			if err = json.Unmarshal([]byte(values[1]), &cmd); err != nil {
+				logger.FromContext(ctx).Warn("failed to unmarshal command", "value", values[1], logger.Err, err)
+				continue
+			}
+
+			select {
+			case ch <- cmd:
+			case <-ctx.Done():
+				// return the command to the queue for the next consumer
+				if err = st.rdb.LPush(context.Background(), queue, values[1]).Err(); err != nil {
+					logger.FromContext(ctx).Error("failed redis:lpush", "key", queue, logger.Err, err)
+				}
+				return
+			}
+		}
+	}()
+
+	return ch
+}
@@ -0,0 +1,130 @@
+package ops_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/ops"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+)
+
+func TestTrimNotifications(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	store := ops.NewStore(rdb)
+	now := time.Date(2022, 11, 10, 12, 0, 0, 0, time.UTC)
+
+	alice := id.Key{ChatID: 1, UserName: "alice"}
+	bob := id.Key{ChatID: 2, UserName: "bob"}
+	for _, add := range []struct {
+		key id.Key
+		n   ops.Notification
+	}{
+		{alice, ops.Notification{Href: "/a/show/1", At: now.Add(-72 * time.Hour)}},
+		{alice, ops.Notification{Href: "/a/show/2", At: now.Add(-48 * time.Hour)}},
+		{alice, ops.Notification{Href: "/a/show/3", At: now, Status: ops.Delivered, MessageID: 10}},
+		{bob, ops.Notification{Href: "/a/show/4", At: now.Add(-72 * time.Hour)}},
+	} {
+		if err := store.AddNotification(ctx, add.key, add.n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	removed, err := store.TrimNotifications(ctx, now.Add(-24*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed != 3 {
+		t.Errorf("want 3 outdated notifications removed, got %d", removed)
+	}
+
+	got, err := store.Notifications(ctx, alice, ops.DefaultNotificationsCap)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Href != "/a/show/3" || got[0].Status != ops.Delivered || got[0].MessageID != 10 {
+		t.Errorf("want recent notification kept, got %+v", got)
+	}
+
+	if got, err = store.Notifications(ctx, bob, ops.DefaultNotificationsCap); err != nil || len(got) != 0 {
+		t.Errorf("want log of bob emptied, got %+v, error %v", got, err)
+	}
+}
+
+func TestClaimNotification(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	defer rdb.Close()
+
+	ctx := context.Background()
+	store := ops.NewStore(rdb)
+
+	alice := id.Key{ChatID: 1, UserName: "alice"}
+	bob := id.Key{ChatID: 2, UserName: "bob"}
+	for _, claim := range []struct {
+		key  id.Key
+		adID string
+		want bool
+	}{
+		{alice, "1", true},
+		{alice, "1", false}, // already claimed by another instance
+		{alice, "2", true},
+		{bob, "1", true},
+	} {
+		if got, err := store.ClaimNotification(ctx, claim.key, claim.adID, time.Hour); got != claim.want || err != nil {
+			t.Errorf("want claim of ad %s by @%s %v, got %v, error %v", claim.adID, claim.key.UserName, claim.want, got, err)
+		}
+	}
+
+	// claim expires with ttl
+	mr.FastForward(time.Hour)
+	if got, err := store.ClaimNotification(ctx, alice, "1", time.Hour); !got || err != nil {
+		t.Errorf("want expired claim renewed, got %v, error %v", got, err)
+	}
+
+	// released claim is claimed again
+	if err := store.ReleaseNotification(ctx, alice, "1"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := store.ClaimNotification(ctx, alice, "1", time.Hour); !got || err != nil {
+		t.Errorf("want released claim renewed, got %v, error %v", got, err)
+	}
+}
+
+func TestInstanceCommands(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	defer rdb.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	store := ops.NewStore(rdb)
+
+	if err := store.PushTo(ctx, "a", ops.Command{Op: ops.OpFavorite, User: "alice"}); err == nil {
+		t.Errorf("want favorite without href rejected")
+	}
+
+	cmd := ops.Command{Op: ops.OpFavorite, ChatID: 1, User: "alice", Href: "/a/show/1"}
+	if err := store.PushTo(ctx, "b", cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	// commands are queued to the instance only
+	if n, _ := rdb.LLen(ctx, "ops;commands").Result(); n != 0 {
+		t.Errorf("want shared queue empty, got %d commands", n)
+	}
+
+	select {
+	case got := <-store.InstanceCommands(ctx, "b"):
+		if got != cmd {
+			t.Errorf("want %+v, got %+v", cmd, got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("want command of instance, got nothing")
+	}
+}
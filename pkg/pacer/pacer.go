@@ -0,0 +1,115 @@
+package pacer
+
+import (
+	"math"
+	"time"
+)
+
+const (
+	DefaultTarget         = 0.5              // new ads expected per crawl cycle
+	DefaultMinSamples     = 10               // arrivals required to learn rates
+	DefaultUpdateInterval = 15 * time.Minute // interval of adapting crawl intervals
+
+	hoursPerDay = 24
+)
+
+// Arrival rates of new ads per hour by hour of day.
+type Rates [hoursPerDay]float64
+
+// Pacer config, crawl interval is kept within bounds.
+type Config struct {
+	MinInterval time.Duration
+	MaxInterval time.Duration
+	Target      float64 // new ads expected per crawl cycle, DefaultTarget if not positive
+	MinSamples  int     // arrivals required to learn rates, DefaultMinSamples if not positive
+}
+
+// Learns crawl interval of a filter from arrivals of its new ads.
+type Pacer struct {
+	config Config
+}
+
+// Creates new pacer from the given config.
+func New(config Config) *Pacer {
+	cfg := config
+	if cfg.Target <= 0 {
+		cfg.Target = DefaultTarget
+	}
+	if cfg.MinSamples <= 0 {
+		cfg.MinSamples = DefaultMinSamples
+	}
+	if cfg.MaxInterval < cfg.MinInterval {
+		cfg.MaxInterval = cfg.MinInterval
+	}
+
+	return &Pacer{config: cfg}
+}
+
+// Returns arrival rates by hour of day in the given time zone.
+// Arrivals before since are ignored, the window starts at the oldest arrival if it is later.
+func Learn(arrivals []time.Time, since, until time.Time, loc *time.Location) Rates {
+	var (
+		counts   Rates
+		observed Rates // hours of the window by hour of day
+		oldest   = until
+	)
+
+	for _, at := range arrivals {
+		if at.Before(since) || !at.Before(until) {
+			continue
+		}
+		counts[at.In(loc).Hour()]++
+
+		if at.Before(oldest) {
+			oldest = at
+		}
+	}
+
+	for at := oldest.Truncate(time.Hour); at.Before(until); at = at.Add(time.Hour) {
+		observed[at.In(loc).Hour()]++
+	}
+
+	var rates Rates
+	for h := range rates {
+		if observed[h] > 0 {
+			rates[h] = counts[h] / observed[h]
+		}
+	}
+
+	return rates
+}
+
+// Returns crawl interval expecting Target new ads per cycle at the given time, hours of day are in its location.
+// The next hour is taken into account to speed up before ads arrive.
+// Returns false if there are not enough arrivals to learn rates.
+func (p *Pacer) Interval(arrivals []time.Time, since, at time.Time) (time.Duration, bool) {
+	samples := 0
+	for _, t := range arrivals {
+		if !t.Before(since) && t.Before(at) {
+			samples++
+		}
+	}
+	if samples < p.config.MinSamples {
+		return 0, false
+	}
+
+	loc := at.Location()
+	rates := Learn(arrivals, since, at, loc)
+
+	hour := at.Hour()
+	rate := math.Max(rates[hour], rates[(hour+1)%hoursPerDay])
+	if rate <= 0 {
+		return p.config.MaxInterval, true
+	}
+
+	// compared before conversion to avoid overflow of rare arrivals
+	interval := p.config.Target / rate * float64(time.Hour)
+	switch {
+	case interval < float64(p.config.MinInterval):
+		return p.config.MinInterval, true
+	case interval > float64(p.config.MaxInterval):
+		return p.config.MaxInterval, true
+	}
+
+	return time.Duration(interval), true
+}
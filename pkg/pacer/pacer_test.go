@@ -0,0 +1,81 @@
+package pacer_test
+
+import (
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/pacer"
+)
+
+// Returns arrivals of n ads within the hour of each day.
+func arrivals(start time.Time, days, hour, n int) []time.Time {
+	var res []time.Time
+	for d := 0; d < days; d++ {
+		at := start.AddDate(0, 0, d).Add(time.Duration(hour) * time.Hour)
+		for i := 0; i < n; i++ {
+			res = append(res, at.Add(time.Duration(i)*time.Minute))
+		}
+	}
+
+	return res
+}
+
+func TestLearn(t *testing.T) {
+	loc := time.FixedZone("ALMT", 6*60*60)
+	start := time.Date(2022, time.November, 1, 0, 0, 0, 0, loc)
+	until := start.AddDate(0, 0, 7)
+
+	// window starts at the oldest arrival, the same rate is learned from the week and the last day
+	for _, days := range []int{7, 1} {
+		since := until.AddDate(0, 0, -days)
+		rates := pacer.Learn(arrivals(since, days, 19, 6), start, until, loc)
+
+		if rates[19] != 6 {
+			t.Errorf("want 6 ads per hour at 19h from %d days, got %v", days, rates[19])
+		}
+		if rates[3] != 0 {
+			t.Errorf("want no ads at 3h, got %v", rates[3])
+		}
+	}
+
+	// arrivals out of the window are ignored
+	if rates := pacer.Learn(arrivals(start.AddDate(0, 0, -7), 7, 19, 6), start, until, loc); rates[19] != 0 {
+		t.Errorf("want old arrivals ignored, got %v", rates[19])
+	}
+}
+
+func TestInterval(t *testing.T) {
+	loc := time.FixedZone("ALMT", 6*60*60)
+	start := time.Date(2022, time.November, 1, 0, 0, 0, 0, loc)
+	until := start.AddDate(0, 0, 7)
+
+	history := append(arrivals(start, 7, 19, 6), arrivals(start, 7, 12, 1)...)
+
+	p := pacer.New(pacer.Config{MinInterval: 2 * time.Minute, MaxInterval: time.Hour})
+
+	for _, tc := range []struct {
+		hour int
+		want time.Duration
+	}{
+		{hour: 19, want: 5 * time.Minute},  // hot hour
+		{hour: 18, want: 5 * time.Minute},  // speeds up before hot hour
+		{hour: 12, want: 30 * time.Minute}, // one ad per hour
+		{hour: 3, want: time.Hour},         // no ads
+	} {
+		at := until.Add(time.Duration(tc.hour) * time.Hour)
+		if got, ok := p.Interval(history, start, at); !ok || got != tc.want {
+			t.Errorf("want interval %s at %dh, got %s, learned %v", tc.want, tc.hour, got, ok)
+		}
+	}
+
+	// too few arrivals to learn
+	if _, ok := p.Interval(arrivals(start, 1, 19, 6), start, until); ok {
+		t.Errorf("want interval not learned from %d arrivals", 6)
+	}
+
+	// bounded by min interval
+	fast := pacer.New(pacer.Config{MinInterval: 10 * time.Minute, MaxInterval: time.Hour})
+	if got, _ := fast.Interval(history, start, until.Add(19*time.Hour)); got != 10*time.Minute {
+		t.Errorf("want min interval, got %s", got)
+	}
+}
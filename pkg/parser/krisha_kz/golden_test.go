@@ -0,0 +1,85 @@
+package krishakz_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/crawler/fixture"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/listing"
+	krishakz "krisha_kz_bot/pkg/parser/krisha_kz"
+)
+
+//nolint:gochecknoglobals // test flag
+var update = flag.Bool("update", false, "update golden files of recorded fixtures")
+
+// Parses recorded responses as of their Date header and compares listings with golden files.
+// Record new fixtures by the worker with CRAWLER_RECORD_DIR and run go test -run TestGolden -update.
+func TestGolden(t *testing.T) {
+	const fixtureDir = "./test_data/fixtures"
+
+	files, err := filepath.Glob(filepath.Join(fixtureDir, "*"+fixture.Ext))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("failed to find fixtures in %s, got error %v", fixtureDir, err)
+	}
+
+	loc := newTestUnit(t, "", "Asia/Almaty").getLoc()
+
+	for _, path := range files {
+		path := path
+		t.Run(filepath.Base(path), func(t *testing.T) {
+			resp, err := fixture.Load(path, nil)
+			if err != nil {
+				t.Fatalf("failed to load fixture, got error %v", err)
+			}
+			defer resp.Body.Close()
+
+			now, err := http.ParseTime(resp.Header.Get("Date"))
+			if err != nil {
+				t.Fatalf("failed to parse Date header of fixture, got error %v", err)
+			}
+
+			p := krishakz.NewParser(clock.NewFake(now), loc)
+			p.KeepOld = true
+
+			listings := []*listing.Listing{}
+			if err = p.Parse(resp.Body, func(val holder.WithDT[string]) {
+				if l, ok := val.(*listing.Listing); ok {
+					listings = append(listings, l)
+				}
+			}); err != nil {
+				t.Fatalf("failed to parse fixture, got error %v", err)
+			}
+
+			got, err := json.MarshalIndent(listings, "", "  ")
+			if err != nil {
+				t.Fatalf("failed to marshal listings, got error %v", err)
+			}
+			got = append(got, '\n')
+
+			golden := strings.TrimSuffix(path, fixture.Ext) + ".golden.json"
+			if *update {
+				//nolint:gosec // golden files are committed
+				if err = os.WriteFile(golden, got, 0o644); err != nil {
+					t.Fatalf("failed to update golden file, got error %v", err)
+				}
+			}
+
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("failed to read golden file, run with -update to create it, got error %v", err)
+			}
+
+			if !bytes.Equal(got, want) {
+				t.Errorf("listings differ from %s, run with -update if layout changed intentionally\ngot:\n%s", golden, got)
+			}
+		})
+	}
+}
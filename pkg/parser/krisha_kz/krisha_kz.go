@@ -3,11 +3,15 @@ package krishakz
 import (
 	"fmt"
 	"io"
-	"log"
+	"regexp"
+	"strconv"
 	"strings"
 	"time"
 
+	"krisha_kz_bot/pkg/clock"
 	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/listing"
+	"krisha_kz_bot/pkg/logger"
 	"krisha_kz_bot/pkg/parser"
 
 	"github.com/PuerkitoBio/goquery"
@@ -21,14 +25,26 @@ var shortMonthNames = [...]string{
 	"июл.", "авг.", "сен.", "окт.", "нояб.", "дек.",
 }
 
+//nolint:gochecknoglobals // compiled once
+var (
+	reRooms = regexp.MustCompile(`(\d+)-комнатная`)
+	reArea  = regexp.MustCompile(`([\d.,]+)\s*м²`)
+	reFloor = regexp.MustCompile(`(\d+)(?:/(\d+))?\s*этаж`)
+	reDigit = regexp.MustCompile(`\D`)
+)
+
 type Parser struct {
-	GetNow func() time.Time
+	GetNow  func() time.Time
+	KeepOld bool // emit ads published before today with their publication day
 }
 
-func NewParser(loc *time.Location) parser.Parser[holder.WithDT[string]] {
+// Creates parser of ads published today by the given clock in the location.
+func NewParser(clk clock.Clock, loc *time.Location) *Parser {
+	clk = clock.OrDefault(clk)
+
 	return &Parser{
 		GetNow: func() time.Time {
-			return time.Now().In(loc)
+			return clk.Now().In(loc)
 		},
 	}
 }
@@ -41,7 +57,7 @@ func (p *Parser) Parse(payload io.Reader, handler parser.HandlerFunc[holder.With
 	}
 
 	now := p.GetNow()
-	log.Printf("Parser, Now in %s %s\n", now.Location(), now)
+	logger.Default().Debug("parsing page", "now", now, "location", now.Location())
 
 	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
 	today := fmt.Sprintf("%d %s", now.Day(), shortMonthNames[now.Month()-1])
@@ -50,45 +66,95 @@ func (p *Parser) Parse(payload io.Reader, handler parser.HandlerFunc[holder.With
 	doc.Find("section.a-list.a-search-list div.ddl_product.ddl_product_link").
 		Each(func(i int, s *goquery.Selection) {
 			isAdOld := false
+			dt := day
 			statsNodes := s.Find("div.card-stats__item").Nodes
 
-			if len(statsNodes) == 3 || statsNodes[1].FirstChild == nil {
+			if len(statsNodes) == 3 && statsNodes[1].FirstChild != nil {
 				d := statsNodes[1].FirstChild.Data // 24 окт.
 				d = strings.TrimSpace(d)
 
 				// filter by current date
 				isAdOld = d != today
+				if isAdOld {
+					dt = parseDay(d, now)
+				}
 			} else {
-				log.Println("failed to find advertisement date")
+				logger.Default().Warn("failed to find advertisement date")
 			}
 
 			// skip out of date ads
-			if !isAdOld {
-				s.Find("a[href].a-card__title").
-					Each(func(i int, s *goquery.Selection) {
-						// For each item found, get the title
-						if href, ok := s.Attr("href"); ok {
-							var fnc fncRes = func() (string, time.Time) {
-								return href, day
-							}
-
-							handler(holder.WithDT[string](fnc))
-						}
-					})
+			if !isAdOld || p.KeepOld {
+				if l, ok := parseCard(s, dt); ok {
+					handler(l)
+				}
 			}
 		})
 
 	return nil
 }
 
-type fncRes func() (string, time.Time)
+// Parses publication day of an ad, e.g. 24 окт.
+// Returns the day before today if label is not recognized.
+func parseDay(label string, now time.Time) time.Time {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	yesterday := today.AddDate(0, 0, -1)
+
+	fields := strings.Fields(label)
+	if len(fields) != 2 {
+		return yesterday
+	}
+
+	d, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return yesterday
+	}
+
+	for i, name := range shortMonthNames {
+		if name == fields[1] {
+			dt := time.Date(now.Year(), time.Month(i+1), d, 0, 0, 0, 0, now.Location())
+			// ads published in december are seen in january
+			if dt.After(today) {
+				dt = dt.AddDate(-1, 0, 0)
+			}
+			return dt
+		}
+	}
 
-func (f fncRes) GetValue() string {
-	href, _ := f()
-	return href
+	return yesterday
 }
 
-func (f fncRes) GetDT() time.Time {
-	_, dt := f()
-	return dt
+// Parses advertisement card, returns false if card has no link.
+func parseCard(s *goquery.Selection, day time.Time) (*listing.Listing, bool) {
+	title := s.Find("a[href].a-card__title").First()
+
+	href, ok := title.Attr("href")
+	if !ok {
+		return nil, false
+	}
+
+	l := &listing.Listing{
+		Href:    href,
+		Title:   strings.TrimSpace(title.Text()),
+		Address: strings.TrimSpace(s.Find("div.a-card__subtitle").First().Text()),
+		DT:      day,
+	}
+	l.ID, _ = s.Attr("data-id")
+	l.Photo, _ = s.Find("a.a-card__image img").First().Attr("src")
+
+	if m := reRooms.FindStringSubmatch(l.Title); m != nil {
+		l.Rooms, _ = strconv.Atoi(m[1])
+	}
+	if m := reArea.FindStringSubmatch(l.Title); m != nil {
+		l.Area, _ = strconv.ParseFloat(strings.ReplaceAll(m[1], ",", "."), 64)
+	}
+	if m := reFloor.FindStringSubmatch(l.Title); m != nil {
+		l.Floor, _ = strconv.Atoi(m[1])
+		l.Floors, _ = strconv.Atoi(m[2])
+	}
+
+	price := s.Find("div.a-card__price").First().Clone()
+	price.Find("span").Remove()
+	l.Price, _ = strconv.ParseInt(reDigit.ReplaceAllString(price.Text(), ""), 10, 64)
+
+	return l, true
 }
@@ -3,6 +3,7 @@ package krishakz_test
 import (
 	"fmt"
 	"io"
+	"krisha_kz_bot/pkg/clock"
 	"krisha_kz_bot/pkg/holder"
 	"krisha_kz_bot/pkg/parser"
 	krishakz "krisha_kz_bot/pkg/parser/krisha_kz"
@@ -17,7 +18,7 @@ type testUnit struct {
 	testData      func(string) io.ReadCloser
 	getNow        func(*time.Location) time.Time
 	getLoc        func() *time.Location
-	getMockParser func(func() time.Time) parser.Parser[holder.WithDT[string]]
+	getMockParser func(time.Time) parser.Parser[holder.WithDT[string]]
 }
 
 func newTestUnit(t *testing.T, now string, locName string) *testUnit {
@@ -43,10 +44,8 @@ func newTestUnit(t *testing.T, now string, locName string) *testUnit {
 			}
 			return loc
 		},
-		getMockParser: func(fncNow func() time.Time) parser.Parser[holder.WithDT[string]] {
-			return &krishakz.Parser{
-				GetNow: fncNow,
-			}
+		getMockParser: func(now time.Time) parser.Parser[holder.WithDT[string]] {
+			return krishakz.NewParser(clock.NewFake(now), now.Location())
 		},
 	}
 }
@@ -72,9 +71,7 @@ func TestParser(t *testing.T) {
 		t.Run(fmt.Sprintf("Test case %d with test data %s", i, c.TestPage), func(t *testing.T) {
 			c.testUnit = *newTestUnit(t, c.Now, c.LocName)
 
-			mockParser := c.getMockParser(func() time.Time {
-				return c.getNow(c.getLoc())
-			})
+			mockParser := c.getMockParser(c.getNow(c.getLoc()))
 
 			er := make([]string, len(c.Want))
 			copy(er, c.Want)
@@ -3,15 +3,19 @@ package scanner
 import (
 	"context"
 	"errors"
-	"log"
 	"net/http"
 	"sync"
 	"time"
 
+	"krisha_kz_bot/pkg/clock"
 	"krisha_kz_bot/pkg/crawler"
 	webcrawler "krisha_kz_bot/pkg/crawler/web_crawler"
+	"krisha_kz_bot/pkg/dedup"
 	"krisha_kz_bot/pkg/holder"
 	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/listing"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/metrics"
 	"krisha_kz_bot/pkg/utils"
 
 	"github.com/go-redis/redis/v9"
@@ -22,6 +26,9 @@ const (
 	DefaultRetentionPolicy = 24 * time.Hour // days available in cache
 	DefaultVisitedBufSize  = 100
 	DefaultRedisTimeout    = 2 * time.Minute
+	DefaultPhotoTimeout    = 10 * time.Second
+	DefaultPhotoWorkers    = 4                // photos hashed concurrently per crawl cycle
+	DefaultHashingTimeout  = 30 * time.Second // photo hashing of the whole crawl cycle
 )
 
 var (
@@ -30,15 +37,58 @@ var (
 )
 
 // Handler of WebParser results.
-type ResultHandlerFunc[Result ~string] func(key id.Key, val Result)
+type ResultHandlerFunc[Result ~string] func(ctx context.Context, key id.Key, val Result)
+
+// Handler of WebParser results similar to already notified origin.
+type DuplicateHandlerFunc[Result ~string] func(ctx context.Context, key id.Key, val Result, origin Result)
+
+// Handler of favorite result changed between crawl cycles.
+type ChangedHandlerFunc[Result ~string] func(ctx context.Context, key id.Key, prev, cur holder.WithDT[Result])
+
+// Checks if the result missing in the crawl cycle has been removed from the web site.
+type RemovedCheckFunc[Result ~string] func(ctx context.Context, val Result) bool
+
+// Outcome of the last crawled page.
+type CrawlStatus struct {
+	At      time.Time // time of the last crawled page
+	URL     string    // the last crawled page
+	Results int       // number of results parsed from the page
+	Err     error     // nil if page crawled successfully
+}
+
+// Returns true if host refused to serve the page.
+func (status CrawlStatus) IsBlocked() bool {
+	return errors.Is(status.Err, webcrawler.ErrBlocked) || errors.Is(status.Err, webcrawler.ErrCircuitOpen)
+}
 
 // Scanner entity with web site.
 type scanner[Result ~string] struct {
-	key      id.Key
-	crawler  crawler.Crawler[holder.WithDT[Result]]
-	counter  crawler.Countable
-	resultCh <-chan holder.WithDT[Result]
-	visited  map[Result]time.Time
+	key       id.Key
+	status    CrawlStatus
+	crawler   crawler.Crawler[holder.WithDT[Result]]
+	counter   crawler.Countable
+	stats     crawler.StatsProvider
+	trigger   crawler.Triggerable
+	schedule  crawler.Reschedulable
+	target    crawler.Retargetable
+	interval  time.Duration // crawl interval of the user, service one if zero
+	adaptive  time.Duration // crawl interval learned from arrivals, applied if the user has no own one
+	resultCh  <-chan crawler.CrawlResult[holder.WithDT[Result]]
+	visited   map[Result]time.Time
+	index     *dedup.Index
+	last      map[Result]holder.WithDT[Result] // results of the last crawl cycle
+	favorites map[Result]struct{}
+}
+
+// Stops crawler of the scanner if it has been started.
+// Invoke under lock.
+func (sc *scanner[Result]) stop() {
+	if sc.resultCh == nil {
+		return
+	}
+
+	sc.crawler.Stop()
+	sc.resultCh = nil
 }
 
 // Scanner service.
@@ -47,7 +97,8 @@ type Service[Result ~string] struct {
 	entities map[id.Key]*scanner[Result]
 	mx       sync.RWMutex
 
-	rdb *redis.Client
+	clock clock.Clock
+	rdb   *redis.Client
 }
 
 // Scanner config.
@@ -57,6 +108,13 @@ type Config[Result ~string] struct {
 	VisitedBufSize  int
 	RetentionPolicy time.Duration
 	OnResult        ResultHandlerFunc[Result]
+	Client          *http.Client                 // http.DefaultClient if nil
+	DedupMode       dedup.Mode                   // Off by default
+	PhotoHasher     dedup.PhotoHasher            // not mandatory, listings are compared without photos if nil
+	OnDuplicate     DuplicateHandlerFunc[Result] // not mandatory, OnResult is used if nil
+	OnRemoved       ResultHandlerFunc[Result]    // not mandatory, notifies about removed favorites
+	OnChanged       ChangedHandlerFunc[Result]   // not mandatory, notifies about changed favorites
+	IsRemoved       RemovedCheckFunc[Result]     // not mandatory, missing favorites are treated as removed if nil
 }
 
 // Creates new scanner service from the given config.
@@ -68,8 +126,23 @@ func NewServiceFromConfig[Result ~string](config *Config[Result]) *Service[Resul
 	cfg.VisitedBufSize = utils.GraterOrEqDefOr(cfg.VisitedBufSize, DefaultVisitedBufSize)
 	cfg.RetentionPolicy = utils.GraterOrEqDefOr(cfg.RetentionPolicy, DefaultRetentionPolicy)
 
+	if cfg.OnDuplicate == nil {
+		onResult := cfg.OnResult
+		cfg.OnDuplicate = func(ctx context.Context, key id.Key, val Result, origin Result) {
+			onResult(ctx, key, val)
+		}
+	}
+
+	if cfg.OnRemoved == nil {
+		cfg.OnRemoved = func(ctx context.Context, key id.Key, val Result) {}
+	}
+	if cfg.OnChanged == nil {
+		cfg.OnChanged = func(ctx context.Context, key id.Key, prev, cur holder.WithDT[Result]) {}
+	}
+
 	return &Service[Result]{
 		config:   cfg,
+		clock:    clock.OrDefault(cfg.Clock),
 		entities: make(map[id.Key]*scanner[Result]),
 	}
 }
@@ -87,16 +160,15 @@ func (s *Service[Result]) StopAll() {
 	defer s.mx.Unlock()
 
 	for key, scanner := range s.entities {
-		scanner.crawler.Stop()
-		scanner.crawler = nil
-		scanner.resultCh = nil
+		scanner.stop()
+		metrics.NewAds.DeleteLabelValues(key.String())
 
-		log.Printf("scanner for @%s stopped\n", key)
+		logger.Default().WithKey(key).Info("scanner stopped")
 	}
 }
 
 func (s *Service[Result]) Shutdown() error {
-	log.Println("shutting down scanner service")
+	logger.Default().Info("shutting down scanner service")
 	// _, done := context.WithCancel(ctx)
 	s.StopAll()
 	// done()
@@ -107,7 +179,9 @@ func (s *Service[Result]) Shutdown() error {
 // And asynchronously subscribes on result channel.
 // The user shall be registered first.
 func (s *Service[Result]) Start(ctx context.Context, key id.Key) error {
-	var resultCh <-chan holder.WithDT[Result]
+	ctx = logger.WithContext(ctx, logger.FromContext(ctx).WithKey(key))
+
+	var resultCh <-chan crawler.CrawlResult[holder.WithDT[Result]]
 	scannerFound := false
 
 	// check if scanner exists and start it async
@@ -134,64 +208,116 @@ func (s *Service[Result]) Start(ctx context.Context, key id.Key) error {
 		defer stop()
 		defer wg.Done()
 
-		now := time.Now().In(&s.config.TimeZone)
-		log.Printf("Loader scanner, Now in %s %s\n", s.config.TimeZone.String(), now)
-		day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, &s.config.TimeZone)
+		log := logger.FromContext(ctx)
+		day := s.today()
+		log.Debug("loading scanner", "time_zone", s.config.TimeZone.String(), "today", day)
 
-		if values, err := loadValues(ctx, s.rdb, key); err == nil {
-			log.Printf("Loader scanner, %s values %v\n", key, values)
+		if favorites, err := loadFavorites(ctx, s.rdb, key); err == nil {
+			log.Info("loaded favorites", "favorites", len(favorites))
 
-			// no need to lock entities if nothing to update
-			if len(values) == 0 {
-				return
+			s.mx.Lock()
+			if scanner, found := s.entities[key]; found {
+				for _, val := range favorites {
+					scanner.favorites[Result(val)] = struct{}{}
+				}
 			}
+			s.mx.Unlock()
+		} else {
+			log.Error("failed to load favorites", logger.Err, err)
+		}
+
+		if values, err := loadValues(ctx, s.rdb, key); err == nil {
+			log.Info("loaded visited", "visited", len(values))
 
 			s.mx.Lock()
-			defer s.mx.Unlock()
+			if scanner, found := s.entities[key]; found && len(values) > 0 {
+				for _, val := range values {
+					scanner.visited[Result(val)] = day
+				}
+				metrics.VisitedSize.WithLabelValues(key.String()).Set(float64(len(scanner.visited)))
+			}
+			s.mx.Unlock()
+		} else {
+			log.Error("failed to load visited", logger.Err, err)
+		}
+
+		if s.config.DedupMode == dedup.Off {
+			return
+		}
 
-			visited := s.entities[key].visited
-			for _, val := range values {
-				visited[Result(val)] = day
+		if fps, err := loadFingerprints(ctx, s.rdb, key); err == nil {
+			log.Info("loaded fingerprints", "fingerprints", len(fps))
+
+			s.mx.RLock()
+			if scanner, found := s.entities[key]; found {
+				for href, fp := range fps {
+					// retention of the fingerprint is kept
+					dt := fp.Day
+					if dt.IsZero() {
+						dt = day
+					}
+					scanner.index.Add(href, fp.Fingerprint, dt)
+				}
 			}
-			s.entities[key].visited = visited
+			s.mx.RUnlock()
 		} else {
-			log.Printf("failed to load data, error %v\n", err)
+			log.Error("failed to load fingerprints", logger.Err, err)
 		}
 	}(ctx, key)
 
 	// blocks until visited cache loaded or timeout
 	wg.Wait()
 
-	// subscribe on results
-	go func(ctx context.Context, key id.Key, resultCh <-chan holder.WithDT[Result]) {
-		for val := range resultCh {
-			v := val.GetValue()
-			dt := val.GetDT()
+	// subscribe on results of crawl cycles
+	go func(ctx context.Context, key id.Key, resultCh <-chan crawler.CrawlResult[holder.WithDT[Result]]) {
+		for res := range resultCh {
+			s.process(ctx, key, &res)
+		}
+	}(ctx, key, resultCh)
 
-			// check if link has been visited and notify
-			s.mx.Lock()
-			if _, found := s.entities[key].visited[v]; !found {
-				s.config.OnResult(key, v)
+	return nil
+}
 
-				// store in storage with timeout - only when it is a new link
-				go func(ctx context.Context, key id.Key, value Result) {
-					ctx, stop := context.WithTimeout(ctx, DefaultRedisTimeout)
-					defer stop()
+// Returns notification about the new link or its near-duplicate according to dedup mode, nil if suppressed.
+func (s *Service[Result]) notification(ctx context.Context, key id.Key, index *dedup.Index, v Result, fp *dedup.Fingerprint) func() {
+	if fp == nil {
+		return func() { s.config.OnResult(ctx, key, v) }
+	}
 
-					s.addValue(ctx, key, value)
-				}(ctx, key, v)
-			} else {
-				log.Printf("%s already notified about %v\n", key, v)
-			}
+	origin, found := index.Match(string(v), *fp)
+	switch {
+	case !found:
+		return func() { s.config.OnResult(ctx, key, v) }
+	case s.config.DedupMode == dedup.Suppress:
+		logger.FromContext(ctx).Info("suppressed near-duplicate", "href", v, "origin", origin)
+		return nil
+	default:
+		logger.FromContext(ctx).Info("grouped near-duplicate", "href", v, "origin", origin)
+		return func() { s.config.OnDuplicate(ctx, key, v, Result(origin)) }
+	}
+}
 
-			// add to visited
-			s.entities[key].visited[v] = dt
+// Returns fingerprint of the listing or nil if dedup is off or value is not a listing.
+func (s *Service[Result]) fingerprint(ctx context.Context, val holder.WithDT[Result]) *dedup.Fingerprint {
+	l, ok := any(val).(*listing.Listing)
+	if !ok || s.config.DedupMode == dedup.Off {
+		return nil
+	}
 
-			s.mx.Unlock()
+	fp := dedup.FromListing(l)
+
+	if s.config.PhotoHasher != nil && l.Photo != "" {
+		ctx, stop := context.WithTimeout(ctx, DefaultPhotoTimeout)
+		defer stop()
+
+		if hash, err := s.config.PhotoHasher.Hash(ctx, l.Photo); err == nil {
+			fp = fp.WithPhoto(hash)
+		} else {
+			logger.FromContext(ctx).Warn("failed to hash photo", "photo", l.Photo, logger.Err, err)
 		}
-	}(ctx, key, resultCh)
+	}
 
-	return nil
+	return &fp
 }
 
 // Registers user with given url for scanning in the service.
@@ -201,25 +327,122 @@ func (s *Service[Result]) Register(key id.Key, urls []string) error {
 	defer s.mx.Unlock()
 
 	if _, ok := s.entities[key]; ok {
-		log.Printf("@%s already registered in chat %d\n", key.UserName, key.ChatID)
+		logger.Default().WithKey(key).Warn("scanner already registered")
 		return ErrExist
 	}
 
-	crawler := webcrawler.NewCrawler(&s.config.Config, urls, http.DefaultClient)
+	// config per scanner to track crawling status of the user
+	cfg := s.config.Config
+	cfg.OnCrawled = func(url string, cnt int, err error) {
+		s.onCrawled(key, url, cnt, err)
+	}
+
+	crawler := webcrawler.NewCrawler(&cfg, urls, s.config.Client)
 
 	// add scanner for a user and url
 	scanner := &scanner[Result]{
-		key:     key,
-		crawler: crawler,
-		counter: crawler,
-		visited: make(map[Result]time.Time, DefaultVisitedBufSize),
+		key:       key,
+		crawler:   crawler,
+		counter:   crawler,
+		stats:     crawler,
+		trigger:   crawler,
+		schedule:  crawler,
+		target:    crawler,
+		visited:   make(map[Result]time.Time, DefaultVisitedBufSize),
+		index:     dedup.NewIndex(),
+		favorites: make(map[Result]struct{}),
 	}
 	s.entities[key] = scanner
+	metrics.ActiveSubscriptions.Set(float64(len(s.entities)))
+	metrics.ScanInterval.WithLabelValues(key.String()).Set(cfg.Interval.Seconds())
 
-	log.Printf("@%s subscribed in chat %d on scanning of %s\n", key.UserName, key.ChatID, urls)
+	logger.Default().WithKey(key).Info("subscribed on scanning", "urls", urls)
 	return nil
 }
 
+// Tracks crawling status of the user.
+func (s *Service[Result]) onCrawled(key id.Key, url string, cnt int, err error) {
+	status := CrawlStatus{
+		At:      s.clock.Now(),
+		URL:     url,
+		Results: cnt,
+		Err:     err,
+	}
+
+	log := logger.Default().WithKey(key).With(logger.URL, url)
+	switch {
+	case status.IsBlocked():
+		log.Warn("blocked", logger.Err, err)
+	case err != nil:
+		log.Error("failed to crawl", logger.Err, err)
+	case cnt == 0:
+		log.Info("no results")
+	default:
+		log.Debug("crawled", "results", cnt)
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if scanner, found := s.entities[key]; found {
+		scanner.status = status
+	}
+}
+
+// Returns status of the last crawled page for the given user.
+func (s *Service[Result]) GetStatus(key id.Key) (CrawlStatus, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if scanner, found := s.entities[key]; found {
+		return scanner.status, true
+	}
+
+	return CrawlStatus{}, false
+}
+
+// Returns status of the last crawled page per user.
+func (s *Service[Result]) GetStatuses() map[id.Key]CrawlStatus {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	statuses := make(map[id.Key]CrawlStatus, len(s.entities))
+	for key, scanner := range s.entities {
+		statuses[key] = scanner.status
+	}
+
+	return statuses
+}
+
+// Requests crawl cycle of the given user out of schedule.
+func (s *Service[Result]) CrawlNow(key id.Key) error {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	scanner, found := s.entities[key]
+	if !found {
+		return ErrNotExist
+	}
+
+	if !scanner.trigger.CrawlNow() {
+		logger.Default().WithKey(key).Info("crawl already requested")
+	}
+
+	return nil
+}
+
+// Returns crawling statistics for the given user.
+func (s *Service[Result]) GetStats(key id.Key) (crawler.Stats, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if scanner, found := s.entities[key]; found {
+		return scanner.stats.GetStats(), true
+	}
+
+	return crawler.Stats{}, false
+}
+
 // Returns true if there is registered scanner for the given user.
 func (s *Service[Result]) Exists(key id.Key) bool {
 	s.mx.RLock()
@@ -236,8 +459,12 @@ func (s *Service[Result]) UnRegister(key id.Key) error {
 	defer s.mx.Unlock()
 
 	if scanner, ok := s.entities[key]; ok {
-		scanner.crawler.Stop()
+		scanner.stop()
 		delete(s.entities, key)
+		metrics.ActiveSubscriptions.Set(float64(len(s.entities)))
+		metrics.VisitedSize.DeleteLabelValues(key.String())
+		metrics.ScanInterval.DeleteLabelValues(key.String())
+		metrics.NewAds.DeleteLabelValues(key.String())
 
 		// del from storage with timeout
 		go func(ctx context.Context, key id.Key) {
@@ -247,27 +474,45 @@ func (s *Service[Result]) UnRegister(key id.Key) error {
 			s.delKey(ctx, key)
 		}(context.Background(), key)
 
-		log.Printf("@%s unsubscribed in chat %d from scanning\n", key.UserName, key.ChatID)
+		logger.Default().WithKey(key).Info("unsubscribed from scanning")
 		return nil
 	}
 
-	log.Printf("@%s not registered in chat %d\n", key.UserName, key.ChatID)
+	logger.Default().WithKey(key).Warn("scanner not registered")
 	return ErrNotExist
 }
 
+// Stops and removes scanner for the given user, stored state is kept to resume scanning by another worker instance.
+func (s *Service[Result]) Detach(key id.Key) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	scanner, ok := s.entities[key]
+	if !ok {
+		return ErrNotExist
+	}
+
+	scanner.stop()
+	delete(s.entities, key)
+	metrics.ActiveSubscriptions.Set(float64(len(s.entities)))
+	metrics.VisitedSize.DeleteLabelValues(key.String())
+	metrics.ScanInterval.DeleteLabelValues(key.String())
+	metrics.NewAds.DeleteLabelValues(key.String())
+
+	logger.Default().WithKey(key).Info("detached from scanning")
+	return nil
+}
+
 // Clears links visited less than scanner counter minus ROTATION_POLICY_THRESHOLD.
 func (s *Service[Result]) Clean() {
 	s.mx.Lock()
 	defer s.mx.Unlock()
 
-	now := time.Now().In(&s.config.TimeZone)
-	log.Printf("Cleansing scanner, Now in %s %s\n", s.config.TimeZone.String(), now)
-
-	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, &s.config.TimeZone)
-	day = day.Add(-s.config.RetentionPolicy)
+	day := s.retainedSince()
+	logger.Default().Info("cleansing scanner", "time_zone", s.config.TimeZone.String(), "retained_since", day)
 
 	for key, scanner := range s.entities {
-		log.Printf("Cleansing scanner %s\n", key)
+		logger.Default().WithKey(key).Debug("cleansing scanner")
 		for v, dt := range scanner.visited {
 			if dt.Before(day) {
 				// delete from local cache
@@ -278,9 +523,156 @@ func (s *Service[Result]) Clean() {
 					ctx, stop := context.WithTimeout(ctx, DefaultRedisTimeout)
 					defer stop()
 
-					s.delValue(ctx, scanner.key, value)
+					s.delValue(ctx, key, value)
 				}(context.Background(), key, v)
 			}
 		}
+		metrics.VisitedSize.WithLabelValues(key.String()).Set(float64(len(scanner.visited)))
+
+		if hrefs := scanner.index.Clean(day); len(hrefs) > 0 {
+			// del from storage with timeout
+			go func(ctx context.Context, key id.Key, hrefs []string) {
+				ctx, stop := context.WithTimeout(ctx, DefaultRedisTimeout)
+				defer stop()
+
+				s.delFingerprints(ctx, key, hrefs...)
+			}(context.Background(), key, hrefs)
+		}
+	}
+}
+
+// Returns start of the day of the oldest data kept by RetentionPolicy.
+func (s *Service[Result]) RetainedSince() time.Time {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	return s.retainedSince()
+}
+
+func (s *Service[Result]) retainedSince() time.Time {
+	return s.today().Add(-s.config.RetentionPolicy)
+}
+
+// Changes retention policy applied by the next cleansing, not less than DefaultRetentionPolicy.
+func (s *Service[Result]) SetRetentionPolicy(retention time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.config.RetentionPolicy = utils.GraterOrEqDefOr(retention, DefaultRetentionPolicy)
+	logger.Default().Info("changed retention policy", "retention", s.config.RetentionPolicy)
+}
+
+// Changes crawl interval of new and running scanners, not less than DefaultScanInterval.
+func (s *Service[Result]) SetInterval(interval time.Duration) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	s.config.Interval = utils.GraterOrEqDefOr(interval, DefaultScanInterval)
+	for _, scanner := range s.entities {
+		// scanners with own or adaptive interval are not affected
+		s.reschedule(scanner)
+	}
+	logger.Default().Info("changed scan interval", "interval", s.config.Interval, "scanners", len(s.entities))
+}
+
+// Changes crawl interval of the given user, not less than DefaultScanInterval.
+// The adaptive or service interval is restored if zero.
+func (s *Service[Result]) Reschedule(key id.Key, interval time.Duration) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	scanner, found := s.entities[key]
+	if !found {
+		return ErrNotExist
+	}
+
+	scanner.interval = 0
+	if interval > 0 {
+		scanner.interval = utils.GraterOrEqDefOr(interval, DefaultScanInterval)
+	}
+	s.reschedule(scanner)
+	logger.Default().WithKey(key).Info("changed scan interval", "interval", s.interval(scanner))
+
+	return nil
+}
+
+// Changes crawl interval of the given user learned from arrivals, not less than DefaultScanInterval.
+// Own interval of the user takes precedence, the service interval is restored if zero.
+func (s *Service[Result]) Adapt(key id.Key, interval time.Duration) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	scanner, found := s.entities[key]
+	if !found {
+		return ErrNotExist
+	}
+
+	prev := s.interval(scanner)
+	scanner.adaptive = 0
+	if interval > 0 {
+		scanner.adaptive = utils.GraterOrEqDefOr(interval, DefaultScanInterval)
+	}
+
+	if next := s.interval(scanner); next != prev {
+		s.reschedule(scanner)
+		logger.Default().WithKey(key).Info("adapted scan interval", "interval", next)
+	}
+
+	return nil
+}
+
+// Changes pages crawled for the given user from the next crawl cycle, visited links are kept.
+func (s *Service[Result]) Retarget(key id.Key, urls []string) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	scanner, found := s.entities[key]
+	if !found {
+		return ErrNotExist
 	}
+
+	scanner.target.SetURLs(urls)
+	logger.Default().WithKey(key).Info("changed scanned pages", "urls", urls)
+
+	return nil
+}
+
+// Returns crawl interval of the given user.
+func (s *Service[Result]) GetInterval(key id.Key) (time.Duration, bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	if scanner, found := s.entities[key]; found {
+		return s.interval(scanner), true
+	}
+
+	return 0, false
+}
+
+// Returns own interval of the scanner, adaptive or the service one.
+// Invoke under lock.
+func (s *Service[Result]) interval(scanner *scanner[Result]) time.Duration {
+	switch {
+	case scanner.interval > 0:
+		return scanner.interval
+	case scanner.adaptive > 0:
+		return scanner.adaptive
+	}
+
+	return s.config.Interval
+}
+
+// Applies interval of the scanner to its crawler.
+// Invoke under lock.
+func (s *Service[Result]) reschedule(scanner *scanner[Result]) {
+	interval := s.interval(scanner)
+	scanner.schedule.SetInterval(interval)
+	metrics.ScanInterval.WithLabelValues(scanner.key.String()).Set(interval.Seconds())
+}
+
+// Returns start of the current day in the scanner time zone.
+func (s *Service[Result]) today() time.Time {
+	now := s.clock.Now().In(&s.config.TimeZone)
+
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, &s.config.TimeZone)
 }
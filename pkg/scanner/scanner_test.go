@@ -0,0 +1,202 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	webcrawler "krisha_kz_bot/pkg/crawler/web_crawler"
+	"krisha_kz_bot/pkg/dedup"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/metrics"
+	"krisha_kz_bot/pkg/parser"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+)
+
+func TestReschedule(t *testing.T) {
+	s := NewServiceFromConfig(&Config[string]{
+		OnResult: func(ctx context.Context, key id.Key, val string) {},
+	})
+
+	alice := id.Key{UserName: "alice", ChatID: 1}
+	bob := id.Key{UserName: "bob", ChatID: 1}
+	for _, key := range []id.Key{alice, bob} {
+		if err := s.Register(key, []string{"http://localhost/?page=1"}); err != nil {
+			t.Fatalf("failed to register, got error %v", err)
+		}
+	}
+
+	interval := func(key id.Key) time.Duration {
+		got, _ := s.GetInterval(key)
+		return got
+	}
+
+	if err := s.Reschedule(alice, 10*time.Minute); err != nil || interval(alice) != 10*time.Minute {
+		t.Errorf("want own interval 10m, got %s, error %v", interval(alice), err)
+	}
+	if err := s.Reschedule(bob, time.Minute); err != nil || interval(bob) != DefaultScanInterval {
+		t.Errorf("want own interval not less than default, got %s, error %v", interval(bob), err)
+	}
+
+	// service interval does not override own one
+	s.SetInterval(20 * time.Minute)
+	if interval(alice) != 10*time.Minute {
+		t.Errorf("want own interval kept, got %s", interval(alice))
+	}
+
+	// service interval is restored
+	if err := s.Reschedule(alice, 0); err != nil || interval(alice) != 20*time.Minute {
+		t.Errorf("want service interval 20m, got %s, error %v", interval(alice), err)
+	}
+
+	// adaptive interval replaces the service one, own interval takes precedence
+	if err := s.Adapt(alice, 30*time.Minute); err != nil || interval(alice) != 30*time.Minute {
+		t.Errorf("want adaptive interval 30m, got %s, error %v", interval(alice), err)
+	}
+	if err := s.Reschedule(alice, 10*time.Minute); err != nil || interval(alice) != 10*time.Minute {
+		t.Errorf("want own interval 10m, got %s, error %v", interval(alice), err)
+	}
+	if err := s.Reschedule(alice, 0); err != nil || interval(alice) != 30*time.Minute {
+		t.Errorf("want adaptive interval restored, got %s, error %v", interval(alice), err)
+	}
+	if err := s.Adapt(alice, 0); err != nil || interval(alice) != 20*time.Minute {
+		t.Errorf("want service interval restored, got %s, error %v", interval(alice), err)
+	}
+
+	unknown := id.Key{UserName: "carol", ChatID: 1}
+	if err := s.Reschedule(unknown, time.Hour); !errors.Is(err, ErrNotExist) {
+		t.Errorf("want not exist, got %v", err)
+	}
+	if err := s.Adapt(unknown, time.Hour); !errors.Is(err, ErrNotExist) {
+		t.Errorf("want not exist, got %v", err)
+	}
+	if err := s.Retarget(unknown, nil); !errors.Is(err, ErrNotExist) {
+		t.Errorf("want not exist, got %v", err)
+	}
+}
+
+func TestStartRestoresState(t *testing.T) {
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	t.Cleanup(srv.Close)
+
+	key := id.Key{UserName: "alice", ChatID: 1}
+	stored := time.Now().AddDate(0, 0, -3)
+	fp, _ := storedFingerprint{
+		Fingerprint: dedup.Fingerprint{Address: "abay 1", Rooms: 2, Area: 50, Price: 100},
+		Day:         stored,
+	}.MarshalBinary()
+	mr.SAdd(scanID(key).String(), "/a/show/1")
+	mr.HSet(fpID(key).String(), "/a/show/1", string(fp))
+
+	s := NewServiceFromConfig(&Config[string]{
+		DedupMode: dedup.Group,
+		OnResult:  func(ctx context.Context, key id.Key, val string) {},
+		Config: webcrawler.Config[holder.WithDT[string]]{
+			Parser: parser.Func[holder.WithDT[string]](func(io.Reader, parser.HandlerFunc[holder.WithDT[string]]) error {
+				return nil
+			}),
+		},
+	}).WithRedis(rdb)
+
+	if err := s.Register(key, []string{srv.URL + "/?page=1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- s.Start(context.Background(), key) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("want scanner started, got blocked loading stored state")
+	}
+	t.Cleanup(s.StopAll)
+
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+	if _, found := s.entities[key].visited["/a/show/1"]; !found {
+		t.Errorf("want stored visited link loaded")
+	}
+	if n := s.entities[key].index.Len(); n != 1 {
+		t.Errorf("want 1 stored fingerprint loaded, got %d", n)
+	}
+
+	// stored day of the fingerprint is kept
+	if removed := s.entities[key].index.Clean(stored.AddDate(0, 0, 1)); len(removed) != 1 {
+		t.Errorf("want fingerprint of stored day cleansed, got %v", removed)
+	}
+}
+
+func TestStopNotStarted(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	s := NewServiceFromConfig(&Config[string]{
+		OnResult: func(ctx context.Context, key id.Key, val string) {},
+	}).WithRedis(rdb)
+
+	alice := id.Key{UserName: "alice", ChatID: 1}
+	bob := id.Key{UserName: "bob", ChatID: 1}
+	for _, key := range []id.Key{alice, bob} {
+		if err := s.Register(key, []string{"http://localhost/?page=1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s.StopAll()
+	s.StopAll()
+	if err := s.UnRegister(alice); err != nil {
+		t.Errorf("want not started scanner unregistered, got %v", err)
+	}
+	if err := s.Detach(bob); err != nil {
+		t.Errorf("want not started scanner detached, got %v", err)
+	}
+}
+
+func TestMetricsDeleted(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	s := NewServiceFromConfig(&Config[string]{
+		OnResult: func(ctx context.Context, key id.Key, val string) {},
+	}).WithRedis(rdb)
+
+	alice := id.Key{UserName: "alice", ChatID: 1}
+	bob := id.Key{UserName: "bob", ChatID: 1}
+	carol := id.Key{UserName: "carol", ChatID: 1}
+	for _, key := range []id.Key{alice, bob, carol} {
+		if err := s.Register(key, []string{"http://localhost/?page=1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cases := []struct {
+		key    id.Key
+		remove func()
+	}{
+		{alice, func() { _ = s.UnRegister(alice) }},
+		{bob, func() { _ = s.Detach(bob) }},
+		{carol, s.StopAll},
+	}
+	for _, c := range cases {
+		metrics.NewAds.WithLabelValues(c.key.String()).Inc()
+		c.remove()
+
+		if metrics.NewAds.DeleteLabelValues(c.key.String()) {
+			t.Errorf("want new ads of %s deleted", c.key)
+		}
+	}
+}
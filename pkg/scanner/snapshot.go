@@ -0,0 +1,389 @@
+package scanner
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"krisha_kz_bot/pkg/crawler"
+	"krisha_kz_bot/pkg/dedup"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/listing"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/metrics"
+	"krisha_kz_bot/pkg/tracing"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Difference between results of two crawl cycles.
+type diff[Result ~string] struct {
+	added   []Result
+	removed []Result
+	changed []Result
+}
+
+// Compares results of the previous and the current crawl cycles.
+// Removed results are reported for complete cycles only, missing pages would look like removed ads.
+func diffSnapshots[Result ~string](prev, cur map[Result]holder.WithDT[Result], complete bool) diff[Result] {
+	var d diff[Result]
+
+	for v, val := range cur {
+		old, found := prev[v]
+		switch {
+		case !found:
+			d.added = append(d.added, v)
+		case isChanged(old, val):
+			d.changed = append(d.changed, v)
+		}
+	}
+
+	if !complete {
+		return d
+	}
+
+	for v := range prev {
+		if _, found := cur[v]; !found {
+			d.removed = append(d.removed, v)
+		}
+	}
+
+	return d
+}
+
+// Returns true if price, title or address of the listing has been changed.
+func isChanged[Result ~string](prev, cur holder.WithDT[Result]) bool {
+	p, okPrev := any(prev).(*listing.Listing)
+	c, okCur := any(cur).(*listing.Listing)
+	if !okPrev || !okCur {
+		return false
+	}
+
+	return p.Price != c.Price || p.Title != c.Title || p.Address != c.Address
+}
+
+// Notifies about new results of the crawl cycle and about removed or changed favorites.
+func (s *Service[Result]) process(ctx context.Context, key id.Key, res *crawler.CrawlResult[holder.WithDT[Result]]) {
+	// continues trace of the crawl cycle
+	ctx, span := tracer().Start(trace.ContextWithSpanContext(ctx, res.Trace), "scanner.process", trace.WithAttributes(
+		tracing.Sub.String(key.String()),
+		tracing.ChatID.Int64(int64(key.ChatID)),
+		attribute.Int("crawl.results", len(res.Items)),
+	))
+	defer span.End()
+
+	log := logger.FromContext(ctx)
+	log.Info("crawled pages", "pages", res.Pages, "results", len(res.Items), "duration", res.Duration, "errors", len(res.Errors))
+
+	today := s.today()
+
+	// fingerprint new links only, before lock as it may load a photo
+	fps := s.fingerprints(ctx, key, res.Items, today)
+
+	removed := s.update(ctx, key, res, fps, today)
+
+	// verify removed favorites outside of lock as it may load a page
+	for _, v := range removed {
+		if s.config.IsRemoved != nil && !s.config.IsRemoved(ctx, v) {
+			log.Info("favorite is missing in results, but not removed", "href", v)
+			continue
+		}
+
+		s.mx.Lock()
+		found := false
+		if scanner, registered := s.entities[key]; registered {
+			if _, found = scanner.favorites[v]; found {
+				delete(scanner.favorites, v)
+			}
+		}
+		s.mx.Unlock()
+
+		if found {
+			s.config.OnRemoved(ctx, key, v)
+
+			go func(ctx context.Context, key id.Key, value Result) {
+				ctx, stop := context.WithTimeout(ctx, DefaultRedisTimeout)
+				defer stop()
+
+				s.delFavorite(ctx, key, value)
+			}(ctx, key, v)
+		}
+	}
+}
+
+// Returns span attributes identifying the ad.
+func adAttributes[Result ~string](val holder.WithDT[Result]) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{attribute.String("ad.href", string(val.GetValue()))}
+	if l, ok := any(val).(*listing.Listing); ok {
+		attrs = append(attrs, tracing.AdID.String(l.ID))
+	} else {
+		attrs = append(attrs, tracing.AdID.String(string(val.GetValue())))
+	}
+
+	return attrs
+}
+
+func tracer() trace.Tracer {
+	return tracing.Tracer("pkg/scanner")
+}
+
+// Returns fingerprints of fresh results not visited yet.
+func (s *Service[Result]) fingerprints(
+	ctx context.Context, key id.Key, items []holder.WithDT[Result], today time.Time,
+) map[Result]*dedup.Fingerprint {
+	var fresh []holder.WithDT[Result]
+
+	s.mx.RLock()
+	if scanner, registered := s.entities[key]; registered {
+		for _, val := range items {
+			if _, found := scanner.visited[val.GetValue()]; !found && !val.GetDT().Before(today) {
+				fresh = append(fresh, val)
+			}
+		}
+	}
+	s.mx.RUnlock()
+
+	// photos are hashed by a few workers within the total deadline, fingerprints not ready in time are nil
+	ctx, stop := context.WithTimeout(ctx, DefaultHashingTimeout)
+	defer stop()
+
+	fps := make(map[Result]*dedup.Fingerprint, len(fresh))
+	var (
+		mx sync.Mutex
+		wg sync.WaitGroup
+	)
+
+	jobs := make(chan holder.WithDT[Result])
+	for i := 0; i < DefaultPhotoWorkers && i < len(fresh); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for val := range jobs {
+				fp := s.fingerprint(ctx, val)
+				if ctx.Err() != nil {
+					logger.FromContext(ctx).Warn("fingerprint timed out", "href", val.GetValue())
+					continue
+				}
+
+				mx.Lock()
+				fps[val.GetValue()] = fp
+				mx.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for _, val := range fresh {
+		select {
+		case jobs <- val:
+		case <-ctx.Done():
+			break feed
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	return fps
+}
+
+// Notifies about fresh results not visited yet, replaces snapshot of the last crawl cycle.
+// Returns favorites missing in the cycle.
+func (s *Service[Result]) update(
+	ctx context.Context,
+	key id.Key,
+	res *crawler.CrawlResult[holder.WithDT[Result]],
+	fps map[Result]*dedup.Fingerprint,
+	today time.Time,
+) []Result {
+	notifications, removed := s.collect(ctx, key, res, fps, today)
+
+	// notifications are sent outside of lock, so slow callbacks do not block other scanners
+	for _, notify := range notifications {
+		notify()
+	}
+
+	return removed
+}
+
+// Replaces snapshot of the last crawl cycle under lock.
+// Returns notifications about fresh results and changed favorites, and favorites missing in the cycle.
+func (s *Service[Result]) collect(
+	ctx context.Context,
+	key id.Key,
+	res *crawler.CrawlResult[holder.WithDT[Result]],
+	fps map[Result]*dedup.Fingerprint,
+	today time.Time,
+) ([]func(), []Result) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	scanner, registered := s.entities[key]
+	if !registered {
+		return nil, nil
+	}
+
+	var notifications []func()
+
+	current := make(map[Result]holder.WithDT[Result], len(res.Items))
+	for _, val := range res.Items {
+		v := val.GetValue()
+		dt := val.GetDT()
+		current[v] = val
+
+		// old ads are tracked for favorites only
+		if dt.Before(today) {
+			trace.SpanFromContext(ctx).AddEvent("outdated", trace.WithAttributes(adAttributes(val)...))
+			continue
+		}
+
+		// check if link has been visited and notify
+		adCtx, span := tracer().Start(ctx, "scanner.visited", trace.WithAttributes(adAttributes(val)...))
+		if _, found := scanner.visited[v]; !found {
+			span.SetAttributes(attribute.Bool("ad.visited", false))
+
+			fp := fps[v]
+			if notify := s.notification(adCtx, key, scanner.index, v, fp); notify != nil {
+				notifications = append(notifications, notify)
+			}
+			metrics.NewAds.WithLabelValues(key.String()).Inc()
+
+			if fp != nil {
+				scanner.index.Add(string(v), *fp, dt)
+			}
+
+			// store in storage with timeout - only when it is a new link
+			go func(ctx context.Context, key id.Key, value Result, fp *dedup.Fingerprint, day time.Time) {
+				ctx, storeSpan := tracer().Start(ctx, "scanner.store")
+				defer storeSpan.End()
+
+				ctx, stop := context.WithTimeout(ctx, DefaultRedisTimeout)
+				defer stop()
+
+				s.addValue(ctx, key, value)
+				if fp != nil {
+					s.addFingerprint(ctx, key, value, *fp, day)
+				}
+			}(adCtx, key, v, fp, dt)
+		} else {
+			span.SetAttributes(attribute.Bool("ad.visited", true))
+			logger.FromContext(ctx).Debug("already notified", "href", v)
+		}
+		span.End()
+
+		// add to visited
+		scanner.visited[v] = dt
+	}
+	metrics.VisitedSize.WithLabelValues(key.String()).Set(float64(len(scanner.visited)))
+
+	// nothing to compare with on the first cycle
+	if scanner.last == nil {
+		scanner.last = current
+		return notifications, nil
+	}
+
+	d := diffSnapshots(scanner.last, current, res.IsComplete())
+	logger.FromContext(ctx).Info("compared with last cycle", "added", len(d.added), "removed", len(d.removed), "changed", len(d.changed))
+
+	for _, v := range d.changed {
+		if _, found := scanner.favorites[v]; found {
+			prev, cur := scanner.last[v], current[v]
+			notifications = append(notifications, func() { s.config.OnChanged(ctx, key, prev, cur) })
+		}
+	}
+
+	var removed []Result
+	for _, v := range d.removed {
+		if _, found := scanner.favorites[v]; found {
+			removed = append(removed, v)
+		}
+	}
+
+	// keep results of failed pages, so they are not treated as removed on the next cycle
+	if !res.IsComplete() {
+		for v, val := range scanner.last {
+			if _, found := current[v]; !found {
+				current[v] = val
+			}
+		}
+	}
+	scanner.last = current
+
+	return notifications, removed
+}
+
+// Adds the result to favorites of the given user, the user shall be registered.
+func (s *Service[Result]) AddFavorite(ctx context.Context, key id.Key, val Result) error {
+	s.mx.Lock()
+	scanner, registered := s.entities[key]
+	if registered {
+		scanner.favorites[val] = struct{}{}
+	}
+	s.mx.Unlock()
+
+	if !registered {
+		return ErrNotExist
+	}
+
+	// stored outside of lock, so redis does not block other scanners
+	ctx, stop := context.WithTimeout(ctx, DefaultRedisTimeout)
+	defer stop()
+
+	return s.addFavorite(ctx, key, val)
+}
+
+// Removes the result from favorites of the given user.
+func (s *Service[Result]) RemoveFavorite(ctx context.Context, key id.Key, val Result) error {
+	s.mx.Lock()
+	scanner, registered := s.entities[key]
+	if registered {
+		delete(scanner.favorites, val)
+	}
+	s.mx.Unlock()
+
+	if !registered {
+		return ErrNotExist
+	}
+
+	ctx, stop := context.WithTimeout(ctx, DefaultRedisTimeout)
+	defer stop()
+
+	s.delFavorite(ctx, key, val)
+
+	return nil
+}
+
+// Returns favorites of the given user.
+func (s *Service[Result]) Favorites(key id.Key) []Result {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	scanner, registered := s.entities[key]
+	if !registered {
+		return nil
+	}
+
+	favorites := make([]Result, 0, len(scanner.favorites))
+	for v := range scanner.favorites {
+		favorites = append(favorites, v)
+	}
+
+	return favorites
+}
+
+// Returns the result of the last crawl cycle of the given user.
+func (s *Service[Result]) Lookup(key id.Key, val Result) (holder.WithDT[Result], bool) {
+	s.mx.RLock()
+	defer s.mx.RUnlock()
+
+	scanner, registered := s.entities[key]
+	if !registered {
+		return nil, false
+	}
+
+	res, found := scanner.last[val]
+
+	return res, found
+}
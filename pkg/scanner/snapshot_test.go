@@ -0,0 +1,166 @@
+package scanner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/crawler"
+	"krisha_kz_bot/pkg/dedup"
+	"krisha_kz_bot/pkg/holder"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/listing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+)
+
+func snapshot(items ...*listing.Listing) map[string]holder.WithDT[string] {
+	res := make(map[string]holder.WithDT[string], len(items))
+	for _, l := range items {
+		res[l.Href] = l
+	}
+	return res
+}
+
+func sorted(values []string) []string {
+	sort.Strings(values)
+	return values
+}
+
+func TestDiffSnapshots(t *testing.T) {
+	prev := snapshot(
+		&listing.Listing{Href: "/a/show/1", Price: 100},
+		&listing.Listing{Href: "/a/show/2", Price: 200},
+		&listing.Listing{Href: "/a/show/3", Price: 300},
+	)
+	cur := snapshot(
+		&listing.Listing{Href: "/a/show/1", Price: 100},
+		&listing.Listing{Href: "/a/show/2", Price: 150},
+		&listing.Listing{Href: "/a/show/4", Price: 400},
+	)
+
+	d := diffSnapshots(prev, cur, true)
+	if got := sorted(d.added); len(got) != 1 || got[0] != "/a/show/4" {
+		t.Errorf("expected added /a/show/4, got %v", got)
+	}
+	if got := sorted(d.changed); len(got) != 1 || got[0] != "/a/show/2" {
+		t.Errorf("expected changed /a/show/2, got %v", got)
+	}
+	if got := sorted(d.removed); len(got) != 1 || got[0] != "/a/show/3" {
+		t.Errorf("expected removed /a/show/3, got %v", got)
+	}
+
+	// missing pages of incomplete cycle are not treated as removed ads
+	if d = diffSnapshots(prev, cur, false); len(d.removed) != 0 {
+		t.Errorf("expected nothing removed on incomplete cycle, got %v", d.removed)
+	}
+}
+
+func TestProcessNotifiesOutsideOfLock(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+
+	notified := make(chan string)
+	release := make(chan struct{})
+	s := NewServiceFromConfig(&Config[string]{
+		OnResult: func(ctx context.Context, key id.Key, val string) {
+			notified <- val
+			<-release
+		},
+	}).WithRedis(rdb)
+
+	alice := id.Key{UserName: "alice", ChatID: 1}
+	bob := id.Key{UserName: "bob", ChatID: 1}
+	for _, key := range []id.Key{alice, bob} {
+		if err := s.Register(key, []string{"http://localhost/?page=1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	res := &crawler.CrawlResult[holder.WithDT[string]]{
+		Items: []holder.WithDT[string]{&listing.Listing{Href: "/a/show/1", DT: time.Now()}},
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.process(context.Background(), alice, res)
+	}()
+
+	if got := <-notified; got != "/a/show/1" {
+		t.Fatalf("want /a/show/1 notified, got %s", got)
+	}
+
+	// other scanners are not blocked by the slow callback
+	locked := make(chan error, 1)
+	go func() { locked <- s.AddFavorite(context.Background(), bob, "/a/show/2") }()
+	select {
+	case err := <-locked:
+		if err != nil {
+			t.Errorf("want favorite added, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("want scanners not blocked by notification")
+	}
+
+	close(release)
+	<-done
+}
+
+func TestFingerprintsHashedConcurrently(t *testing.T) {
+	// every hash waits for the other workers, so photos hashed one by one would time out
+	var arrived sync.WaitGroup
+	arrived.Add(DefaultPhotoWorkers)
+	ready := make(chan struct{})
+	go func() {
+		arrived.Wait()
+		close(ready)
+	}()
+
+	var calls int32
+	s := NewServiceFromConfig(&Config[string]{
+		OnResult:  func(ctx context.Context, key id.Key, val string) {},
+		DedupMode: dedup.Group,
+		PhotoHasher: dedup.PhotoHasherFunc(func(ctx context.Context, url string) (uint64, error) {
+			if atomic.AddInt32(&calls, 1) <= DefaultPhotoWorkers {
+				arrived.Done()
+			}
+
+			select {
+			case <-ready:
+				return 1, nil
+			case <-time.After(5 * time.Second):
+				return 0, errors.New("photos are hashed one by one")
+			}
+		}),
+	})
+
+	key := id.Key{UserName: "alice", ChatID: 1}
+	if err := s.Register(key, []string{"http://localhost/?page=1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	var items []holder.WithDT[string]
+	for i := 0; i < 2*DefaultPhotoWorkers; i++ {
+		items = append(items, &listing.Listing{
+			Href:  fmt.Sprintf("/a/show/%d", i),
+			Photo: fmt.Sprintf("/photo/%d.jpg", i),
+			DT:    time.Now(),
+		})
+	}
+
+	fps := s.fingerprints(context.Background(), key, items, s.today())
+	if len(fps) != len(items) {
+		t.Fatalf("want %d fingerprints, got %d", len(items), len(fps))
+	}
+	for href, fp := range fps {
+		if fp == nil || !fp.HasPhoto {
+			t.Errorf("want photo of %s hashed, got %v", href, fp)
+		}
+	}
+}
@@ -2,10 +2,13 @@ package scanner
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"krisha_kz_bot/pkg/dedup"
 	"krisha_kz_bot/pkg/id"
-	"log"
+	"krisha_kz_bot/pkg/logger"
 	"strings"
+	"time"
 
 	"github.com/go-redis/redis/v9"
 )
@@ -36,13 +39,41 @@ func (sid *scanID) UnmarshalBinary(data []byte) error {
 	return key.UnmarshalBinary([]byte(strings.Join(values[1:], ";")))
 }
 
+// Fingerprint stored with the day of the result, so retention of the reloaded fingerprint is kept.
+type storedFingerprint struct {
+	dedup.Fingerprint
+	Day time.Time `json:"d,omitempty"`
+}
+
+// Implements encoding.BinaryMarshaler.
+func (sfp storedFingerprint) MarshalBinary() ([]byte, error) {
+	return json.Marshal(sfp)
+}
+
+// Implements encoding.BinaryUnmarshaler.
+func (sfp *storedFingerprint) UnmarshalBinary(data []byte) error {
+	return json.Unmarshal(data, sfp)
+}
+
+type fpID id.Key
+
+func (fid fpID) String() string {
+	return fmt.Sprintf("fp;%s", id.Key(fid))
+}
+
+type favID id.Key
+
+func (fid favID) String() string {
+	return fmt.Sprintf("fav;%s", id.Key(fid))
+}
+
 func (s *Service[Result]) addValue(ctx context.Context, key id.Key, value Result) {
 	scanKey := scanID(key)
 
 	if status := s.rdb.SAdd(ctx, scanKey.String(), value); status.Err() != nil {
-		log.Printf("failed redis:sadd %s value %s, error %v\n", scanKey, value, status.Err())
+		logger.FromContext(ctx).Error("failed redis:sadd", "key", scanKey, "value", value, logger.Err, status.Err())
 	} else {
-		log.Printf("success redis:sadd %s %s\n", scanKey, value)
+		logger.FromContext(ctx).Debug("success redis:sadd", "key", scanKey, "value", value)
 	}
 }
 
@@ -50,19 +81,63 @@ func (s *Service[Result]) delValue(ctx context.Context, key id.Key, values ...Re
 	scanKey := scanID(key)
 
 	if status := s.rdb.SRem(ctx, scanKey.String(), values); status.Err() != nil {
-		log.Printf("failed redis:srem %s values %s, error %v\n", scanKey, values, status.Err())
+		logger.FromContext(ctx).Error("failed redis:srem", "key", scanKey, "values", values, logger.Err, status.Err())
 	} else {
-		log.Printf("success redis:srem %s %s\n", scanKey, values)
+		logger.FromContext(ctx).Debug("success redis:srem", "key", scanKey, "values", values)
 	}
 }
 
 func (s *Service[Result]) delKey(ctx context.Context, key id.Key) {
 	scanKey := scanID(key)
+	fpKey := fpID(key)
+	favKey := favID(key)
+
+	if status := s.rdb.Del(ctx, scanKey.String(), fpKey.String(), favKey.String()); status.Err() != nil {
+		logger.FromContext(ctx).Error("failed redis:del", "keys", []string{scanKey.String(), fpKey.String(), favKey.String()}, logger.Err, status.Err())
+	} else {
+		logger.FromContext(ctx).Debug("success redis:del", "keys", []string{scanKey.String(), fpKey.String(), favKey.String()})
+	}
+}
+
+func (s *Service[Result]) addFavorite(ctx context.Context, key id.Key, value Result) error {
+	favKey := favID(key)
+
+	if status := s.rdb.SAdd(ctx, favKey.String(), value); status.Err() != nil {
+		logger.FromContext(ctx).Error("failed redis:sadd", "key", favKey, "value", value, logger.Err, status.Err())
+		return status.Err()
+	}
+	logger.FromContext(ctx).Debug("success redis:sadd", "key", favKey, "value", value)
+
+	return nil
+}
 
-	if status := s.rdb.Del(ctx, scanKey.String()); status.Err() != nil {
-		log.Printf("failed redis:del %s, error %v\n", scanKey, status.Err())
+func (s *Service[Result]) delFavorite(ctx context.Context, key id.Key, value Result) {
+	favKey := favID(key)
+
+	if status := s.rdb.SRem(ctx, favKey.String(), value); status.Err() != nil {
+		logger.FromContext(ctx).Error("failed redis:srem", "key", favKey, "value", value, logger.Err, status.Err())
 	} else {
-		log.Printf("success redis:del %s\n", scanKey)
+		logger.FromContext(ctx).Debug("success redis:srem", "key", favKey, "value", value)
+	}
+}
+
+func (s *Service[Result]) addFingerprint(ctx context.Context, key id.Key, value Result, fp dedup.Fingerprint, day time.Time) {
+	fpKey := fpID(key)
+
+	if status := s.rdb.HSet(ctx, fpKey.String(), string(value), storedFingerprint{Fingerprint: fp, Day: day}); status.Err() != nil {
+		logger.FromContext(ctx).Error("failed redis:hset", "key", fpKey, "field", value, logger.Err, status.Err())
+	} else {
+		logger.FromContext(ctx).Debug("success redis:hset", "key", fpKey, "field", value)
+	}
+}
+
+func (s *Service[Result]) delFingerprints(ctx context.Context, key id.Key, hrefs ...string) {
+	fpKey := fpID(key)
+
+	if status := s.rdb.HDel(ctx, fpKey.String(), hrefs...); status.Err() != nil {
+		logger.FromContext(ctx).Error("failed redis:hdel", "key", fpKey, "fields", hrefs, logger.Err, status.Err())
+	} else {
+		logger.FromContext(ctx).Debug("success redis:hdel", "key", fpKey, "fields", hrefs)
 	}
 }
 
@@ -83,3 +158,56 @@ func loadValues(ctx context.Context, rdb *redis.Client, key id.Key) ([]string, e
 
 	return values, nil
 }
+
+// Returns stored fingerprints by href, fingerprints stored without day have zero one.
+func loadFingerprints(ctx context.Context, rdb *redis.Client, key id.Key) (map[string]storedFingerprint, error) {
+	var err error
+
+	fpKey := fpID(key)
+	status := rdb.HGetAll(ctx, fpKey.String())
+
+	if err = status.Err(); err != nil {
+		return nil, fmt.Errorf("failed redis:hgetall %s, error %w", fpKey, err)
+	}
+
+	var values map[string]string
+	if values, err = status.Result(); err != nil {
+		return nil, fmt.Errorf("failed redis:hgetall %s, error %w", fpKey, err)
+	}
+
+	fps := make(map[string]storedFingerprint, len(values))
+	for href, raw := range values {
+		var fp storedFingerprint
+		if err = fp.UnmarshalBinary([]byte(raw)); err != nil {
+			logger.FromContext(ctx).Warn("failed to parse fingerprint", "key", fpKey, "field", href, logger.Err, err)
+			continue
+		}
+		fps[href] = fp
+	}
+
+	return fps, nil
+}
+
+func loadFavorites(ctx context.Context, rdb *redis.Client, key id.Key) ([]string, error) {
+	favKey := favID(key)
+	status := rdb.SMembers(ctx, favKey.String())
+
+	values, err := status.Result()
+	if err != nil {
+		return nil, fmt.Errorf("failed redis:smembers %s, error %w", favKey, err)
+	}
+
+	return values, nil
+}
+
+// Returns number of stored results notified to the user.
+func LoadVisitedCount(ctx context.Context, rdb *redis.Client, key id.Key) (int64, error) {
+	scanKey := scanID(key)
+
+	cnt, err := rdb.SCard(ctx, scanKey.String()).Result()
+	if err != nil {
+		return 0, fmt.Errorf("failed redis:scard %s, error %w", scanKey, err)
+	}
+
+	return cnt, nil
+}
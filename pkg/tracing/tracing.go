@@ -0,0 +1,106 @@
+package tracing
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Prefix of tracer names, tracer per package.
+const Prefix = "krisha_kz_bot/"
+
+const (
+	DefaultShutdownTimeout = 5 * time.Second
+
+	defaultTracesPath = "/v1/traces"
+)
+
+// Attributes shared between packages.
+const (
+	AdID   = attribute.Key("ad.id")
+	Sub    = attribute.Key("subscription")
+	ChatID = attribute.Key("chat.id")
+	Cycle  = attribute.Key("crawl.cycle")
+)
+
+var ErrInvalidEndpoint = errors.New("invalid otlp endpoint")
+
+type Config struct {
+	Endpoint    string  // url of OTLP/HTTP collector, e.g. http://localhost:4318, tracing is disabled if empty
+	ServiceName string  // service.name of the resource
+	SampleRatio float64 // ratio of sampled traces, all traces if not positive
+}
+
+// Installs global tracer provider exporting spans to the collector by OTLP/HTTP.
+// Returns shutdown flushing exported spans, no-op if tracing is disabled.
+func Setup(ctx context.Context, cfg Config) (func(context.Context) error, error) {
+	if cfg.Endpoint == "" {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	u, err := url.Parse(cfg.Endpoint)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, errors.WithMessagef(ErrInvalidEndpoint, "%q", cfg.Endpoint)
+	}
+
+	opts := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + defaultTracesPath),
+	}
+	if u.Scheme == "http" {
+		opts = append(opts, otlptracehttp.WithInsecure())
+	}
+
+	exporter, err := otlptracehttp.New(ctx, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := NewProvider(cfg, sdktrace.WithBatcher(exporter))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// Returns tracer provider of the service with the given span processors, e.g. in-memory exporter in tests.
+func NewProvider(cfg Config, opts ...sdktrace.TracerProviderOption) *sdktrace.TracerProvider {
+	sampler := sdktrace.AlwaysSample()
+	if cfg.SampleRatio > 0 && cfg.SampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(cfg.SampleRatio)
+	}
+
+	opts = append([]sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(semconv.ServiceNameKey.String(cfg.ServiceName))),
+	}, opts...)
+
+	return sdktrace.NewTracerProvider(opts...)
+}
+
+// Returns tracer of the package by the global provider, e.g. Tracer("pkg/scanner").
+func Tracer(pkg string) trace.Tracer {
+	return otel.Tracer(Prefix + pkg)
+}
+
+// Records the error on the span, nil error is ignored.
+func Fail(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
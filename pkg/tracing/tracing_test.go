@@ -0,0 +1,61 @@
+package tracing_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"krisha_kz_bot/pkg/tracing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestSetup(t *testing.T) {
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	var exported int32
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost && r.URL.Path == "/otlp/v1/traces" {
+			atomic.AddInt32(&exported, 1)
+		}
+	}))
+	defer collector.Close()
+
+	ctx := context.Background()
+	shutdown, err := tracing.Setup(ctx, tracing.Config{Endpoint: collector.URL + "/otlp/", ServiceName: "test"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, span := tracing.Tracer("pkg/tracing").Start(ctx, "test")
+	span.End()
+
+	if err = shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&exported) == 0 {
+		t.Error("want spans exported on shutdown")
+	}
+}
+
+func TestSetupDisabled(t *testing.T) {
+	shutdown, err := tracing.Setup(context.Background(), tracing.Config{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = shutdown(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestSetupInvalidEndpoint(t *testing.T) {
+	for _, endpoint := range []string{"localhost:4318", "grpc://localhost:4317", "http://"} {
+		if _, err := tracing.Setup(context.Background(), tracing.Config{Endpoint: endpoint}); !errors.Is(err, tracing.ErrInvalidEndpoint) {
+			t.Errorf("%s: want ErrInvalidEndpoint, got %v", endpoint, err)
+		}
+	}
+}
@@ -0,0 +1,288 @@
+package webapi
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"krisha_kz_bot/pkg/bot"
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/logger"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/scanner"
+
+	"github.com/go-redis/redis/v9"
+	"github.com/pkg/errors"
+)
+
+const (
+	Prefix = "/api/"
+
+	DefaultNotifications = 20
+)
+
+var ErrUnauthorized = errors.New("unauthorized")
+
+// Subscription as stored by worker.
+type Subscription struct {
+	ChatID  int64       `json:"chat_id"`
+	User    string      `json:"user"`
+	URL     string      `json:"url"`
+	State   string      `json:"state"`
+	Visited int64       `json:"visited"`          // number of notified ads
+	Status  *ops.Status `json:"status,omitempty"` // last crawl status, nil if not published by worker
+}
+
+// Request to create subscription.
+type CreateRequest struct {
+	ChatID int64  `json:"chat_id"`
+	User   string `json:"user"`
+	URL    string `json:"url"`
+}
+
+type Config struct {
+	Token string      // bearer token, all requests are refused if empty
+	Clock clock.Clock // system clock if nil
+}
+
+// JSON API over storage of worker, changes are queued as commands to worker.
+//
+//	GET    /api/subscriptions                               - list subscriptions
+//	POST   /api/subscriptions                               - subscribe, CreateRequest in body
+//	GET    /api/subscriptions/<chat>/<user>                 - inspect subscription
+//	DELETE /api/subscriptions/<chat>/<user>                 - unsubscribe
+//	POST   /api/subscriptions/<chat>/<user>/crawl           - crawl out of schedule
+//	GET    /api/subscriptions/<chat>/<user>/notifications?n - recent notifications with delivery status
+type API struct {
+	config Config
+	rdb    *redis.Client
+	store  *ops.Store
+}
+
+func New(rdb *redis.Client, cfg Config) *API {
+	cfg.Clock = clock.OrDefault(cfg.Clock)
+
+	return &API{
+		config: cfg,
+		rdb:    rdb,
+		store:  ops.NewStore(rdb),
+	}
+}
+
+// Implements http.Handler.
+func (api *API) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !api.authorized(r) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="api"`)
+		writeError(w, http.StatusUnauthorized, ErrUnauthorized)
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, Prefix), "/")
+	parts := strings.Split(path, "/")
+	if parts[0] != "subscriptions" {
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+		return
+	}
+
+	if len(parts) == 1 {
+		switch r.Method {
+		case http.MethodGet:
+			api.list(w, r)
+		case http.MethodPost:
+			api.create(w, r)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		}
+		return
+	}
+
+	key, err := parseKey(parts[1:])
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	action := ""
+	if len(parts) > 3 {
+		action = strings.Join(parts[3:], "/")
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		api.inspect(w, r, key)
+	case action == "" && r.Method == http.MethodDelete:
+		api.command(w, r, ops.Command{Op: ops.OpUnsubscribe, ChatID: int64(key.ChatID), User: key.UserName})
+	case action == "crawl" && r.Method == http.MethodPost:
+		api.command(w, r, ops.Command{Op: ops.OpCrawlNow, ChatID: int64(key.ChatID), User: key.UserName})
+	case action == "notifications" && r.Method == http.MethodGet:
+		api.notifications(w, r, key)
+	default:
+		writeError(w, http.StatusNotFound, errors.New("not found"))
+	}
+}
+
+func (api *API) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+	return api.config.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(api.config.Token)) == 1
+}
+
+// Parses subscription key of path <chat>/<user>.
+func parseKey(parts []string) (id.Key, error) {
+	const partsNum = 2
+
+	if len(parts) < partsNum || parts[1] == "" {
+		return id.Key{}, errors.New("please specify <chat>/<user>")
+	}
+
+	chatID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return id.Key{}, errors.Errorf("invalid chat %s", parts[0])
+	}
+
+	return id.Key{
+		ChatID:   id.ChatID(chatID),
+		UserName: strings.TrimPrefix(parts[1], "@"),
+	}, nil
+}
+
+func (api *API) list(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, LoadAll(r.Context(), api.rdb))
+}
+
+func (api *API) inspect(w http.ResponseWriter, r *http.Request, key id.Key) {
+	sub, err := Load(r.Context(), api.rdb, key)
+	switch {
+	case errors.Is(err, bot.ErrNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, sub)
+	}
+}
+
+// Loads all stored subscriptions, failed ones are skipped.
+func LoadAll(ctx context.Context, rdb *redis.Client) []Subscription {
+	subs := []Subscription{}
+	for key := range bot.LoadKeys(ctx, rdb) {
+		sub, err := Load(ctx, rdb, key)
+		if err != nil {
+			logger.FromContext(ctx).WithKey(key).Error("failed to load subscription", logger.Err, err)
+			continue
+		}
+		subs = append(subs, sub)
+	}
+
+	return subs
+}
+
+// Loads subscription with visited count and the last published crawl status.
+func Load(ctx context.Context, rdb *redis.Client, key id.Key) (Subscription, error) {
+	state, filter, err := bot.LoadSubscription(ctx, rdb, key)
+	if err != nil {
+		return Subscription{}, err
+	}
+
+	sub := Subscription{
+		ChatID: int64(key.ChatID),
+		User:   key.UserName,
+		URL:    filter.String(),
+		State:  state.String(),
+	}
+
+	if sub.Visited, err = scanner.LoadVisitedCount(ctx, rdb, key); err != nil {
+		return sub, err
+	}
+
+	status, found, err := ops.NewStore(rdb).GetStatus(ctx, key)
+	if err != nil {
+		return sub, err
+	}
+	if found {
+		sub.Status = &status
+	}
+
+	return sub, nil
+}
+
+// Returns subscription key.
+func (sub *Subscription) Key() id.Key {
+	return id.Key{ChatID: id.ChatID(sub.ChatID), UserName: sub.User}
+}
+
+func (api *API) create(w http.ResponseWriter, r *http.Request) {
+	var req CreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, errors.Wrap(err, "invalid body"))
+		return
+	}
+
+	filter, err := url.ParseRequestURI(req.URL)
+	if err != nil || filter.Hostname() != "krisha.kz" {
+		writeError(w, http.StatusBadRequest, errors.New("url shall be a filter from krisha.kz"))
+		return
+	}
+
+	api.command(w, r, ops.Command{
+		Op:     ops.OpSubscribe,
+		ChatID: req.ChatID,
+		User:   strings.TrimPrefix(req.User, "@"),
+		URL:    filter.String(),
+	})
+}
+
+// Queues the command to worker.
+func (api *API) command(w http.ResponseWriter, r *http.Request, cmd ops.Command) {
+	cmd.At = api.config.Clock.Now()
+
+	if err := cmd.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := api.store.Push(r.Context(), cmd); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	logger.FromContext(r.Context()).WithKey(cmd.Key()).Info("queued command", "op", cmd.Op)
+	writeJSON(w, http.StatusAccepted, cmd)
+}
+
+func (api *API) notifications(w http.ResponseWriter, r *http.Request, key id.Key) {
+	n := DefaultNotifications
+	if raw := r.URL.Query().Get("n"); raw != "" {
+		var err error
+		if n, err = strconv.Atoi(raw); err != nil || n < 1 || n > ops.DefaultNotificationsCap {
+			writeError(w, http.StatusBadRequest, errors.Errorf("n shall be from 1 to %d", ops.DefaultNotificationsCap))
+			return
+		}
+	}
+
+	notifications, err := api.store.Notifications(r.Context(), key, n)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, notifications)
+}
+
+func writeJSON(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		logger.Default().Error("failed to encode response", logger.Err, err)
+	}
+}
+
+func writeError(w http.ResponseWriter, code int, err error) {
+	writeJSON(w, code, map[string]string{"error": err.Error()})
+}
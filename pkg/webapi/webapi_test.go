@@ -0,0 +1,218 @@
+package webapi_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"krisha_kz_bot/pkg/clock"
+	"krisha_kz_bot/pkg/id"
+	"krisha_kz_bot/pkg/ops"
+	"krisha_kz_bot/pkg/webapi"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v9"
+)
+
+const (
+	testToken = "secret"
+	testURL   = "https://krisha.kz/arenda/kvartiry/almaty/?das[live.rooms]=2"
+)
+
+type testAPI struct {
+	srv      *httptest.Server
+	rdb      *redis.Client
+	store    *ops.Store
+	commands <-chan ops.Command
+}
+
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+
+	// subscription of alice as stored by worker
+	mr.HSet("bot;usr:alice;chat:1", "state", "\x01", "url", testURL)
+	if _, err := mr.SAdd("scan;usr:alice;chat:1", "/a/show/1", "/a/show/2"); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	ta := &testAPI{
+		srv: httptest.NewServer(webapi.New(rdb, webapi.Config{
+			Token: testToken,
+			Clock: clock.NewFake(time.Date(2022, 10, 24, 12, 0, 0, 0, time.UTC)),
+		})),
+		rdb:   rdb,
+		store: ops.NewStore(rdb),
+	}
+	ta.commands = ta.store.Commands(ctx)
+
+	t.Cleanup(func() {
+		cancel()
+		ta.srv.Close()
+		rdb.Close()
+	})
+
+	return ta
+}
+
+// Sends request with the token and decodes response into v if not nil.
+func (ta *testAPI) do(t *testing.T, method, path, token, body string, v any) int {
+	t.Helper()
+
+	req, err := http.NewRequest(method, ta.srv.URL+path, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if v != nil {
+		if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatalf("failed to decode response of %s %s, error %v", method, path, err)
+		}
+	}
+
+	return resp.StatusCode
+}
+
+// Returns the next queued command.
+func (ta *testAPI) command(t *testing.T) ops.Command {
+	t.Helper()
+
+	select {
+	case cmd := <-ta.commands:
+		return cmd
+	case <-time.After(time.Second):
+		t.Fatal("want command queued")
+	}
+
+	return ops.Command{}
+}
+
+func TestAuth(t *testing.T) {
+	ta := newTestAPI(t)
+
+	for _, token := range []string{"", "wrong"} {
+		if code := ta.do(t, http.MethodGet, "/api/subscriptions", token, "", nil); code != http.StatusUnauthorized {
+			t.Errorf("want %d with token %q, got %d", http.StatusUnauthorized, token, code)
+		}
+	}
+
+	// api is closed without token configured
+	srv := httptest.NewServer(webapi.New(ta.rdb, webapi.Config{}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/subscriptions")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("want %d without configured token, got %d", http.StatusUnauthorized, resp.StatusCode)
+	}
+}
+
+func TestSubscriptions(t *testing.T) {
+	ta := newTestAPI(t)
+	key := id.Key{ChatID: 1, UserName: "alice"}
+
+	var subs []webapi.Subscription
+	if code := ta.do(t, http.MethodGet, "/api/subscriptions", testToken, "", &subs); code != http.StatusOK {
+		t.Fatalf("want %d, got %d", http.StatusOK, code)
+	}
+	if len(subs) != 1 || subs[0].User != "alice" || subs[0].URL != testURL || subs[0].Visited != 2 ||
+		subs[0].State != "Subscribed" || subs[0].Status != nil {
+		t.Errorf("unexpected subscriptions %+v", subs)
+	}
+
+	status := ops.Status{URL: testURL + "&page=1", Results: 20, Crawls: 3}
+	if err := ta.store.PutStatus(context.Background(), key, status, time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	var sub webapi.Subscription
+	if code := ta.do(t, http.MethodGet, "/api/subscriptions/1/alice", testToken, "", &sub); code != http.StatusOK {
+		t.Fatalf("want %d, got %d", http.StatusOK, code)
+	}
+	if sub.Status == nil || sub.Status.Results != 20 || sub.Status.Crawls != 3 {
+		t.Errorf("want published status, got %+v", sub.Status)
+	}
+
+	if code := ta.do(t, http.MethodGet, "/api/subscriptions/1/bob", testToken, "", nil); code != http.StatusNotFound {
+		t.Errorf("want %d for unknown subscription, got %d", http.StatusNotFound, code)
+	}
+	if code := ta.do(t, http.MethodGet, "/api/subscriptions/x/bob", testToken, "", nil); code != http.StatusBadRequest {
+		t.Errorf("want %d for invalid chat, got %d", http.StatusBadRequest, code)
+	}
+}
+
+func TestCommands(t *testing.T) {
+	ta := newTestAPI(t)
+
+	body := `{"chat_id": 2, "user": "@bob", "url": "https://example.com/"}`
+	if code := ta.do(t, http.MethodPost, "/api/subscriptions", testToken, body, nil); code != http.StatusBadRequest {
+		t.Errorf("want %d for filter of another site, got %d", http.StatusBadRequest, code)
+	}
+
+	body = `{"chat_id": 2, "user": "@bob", "url": "` + testURL + `"}`
+	if code := ta.do(t, http.MethodPost, "/api/subscriptions", testToken, body, nil); code != http.StatusAccepted {
+		t.Fatalf("want %d, got %d", http.StatusAccepted, code)
+	}
+	if cmd := ta.command(t); cmd.Op != ops.OpSubscribe || cmd.Key() != (id.Key{ChatID: 2, UserName: "bob"}) ||
+		cmd.URL != testURL || cmd.At.IsZero() {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+
+	if code := ta.do(t, http.MethodPost, "/api/subscriptions/1/alice/crawl", testToken, "", nil); code != http.StatusAccepted {
+		t.Fatalf("want %d, got %d", http.StatusAccepted, code)
+	}
+	if cmd := ta.command(t); cmd.Op != ops.OpCrawlNow || cmd.User != "alice" {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+
+	if code := ta.do(t, http.MethodDelete, "/api/subscriptions/1/alice", testToken, "", nil); code != http.StatusAccepted {
+		t.Fatalf("want %d, got %d", http.StatusAccepted, code)
+	}
+	if cmd := ta.command(t); cmd.Op != ops.OpUnsubscribe || cmd.ChatID != 1 {
+		t.Errorf("unexpected command %+v", cmd)
+	}
+}
+
+func TestNotifications(t *testing.T) {
+	ta := newTestAPI(t)
+	key := id.Key{ChatID: 1, UserName: "alice"}
+
+	for _, href := range []string{"/a/show/1", "/a/show/2", "/a/show/3"} {
+		if err := ta.store.AddNotification(context.Background(), key, ops.Notification{Href: href}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var notifications []ops.Notification
+	code := ta.do(t, http.MethodGet, "/api/subscriptions/1/alice/notifications?n=2", testToken, "", &notifications)
+	if code != http.StatusOK {
+		t.Fatalf("want %d, got %d", http.StatusOK, code)
+	}
+	if len(notifications) != 2 || notifications[0].Href != "/a/show/3" || notifications[1].Href != "/a/show/2" {
+		t.Errorf("want 2 recent notifications newest first, got %+v", notifications)
+	}
+
+	if code = ta.do(t, http.MethodGet, "/api/subscriptions/1/alice/notifications?n=0", testToken, "", nil); code != http.StatusBadRequest {
+		t.Errorf("want %d for invalid n, got %d", http.StatusBadRequest, code)
+	}
+}